@@ -3,29 +3,105 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	DatabaseURL     string
-	Port           int
-	LogLevel       string
-	SessionDir     string
+	DatabaseURL       string
+	Port              int
+	LogLevel          string
+	SessionDir        string
 	WhatsMeowLogLevel string
-	RedisURL       string
-	EnableMetrics  bool
-	MetricsPort    int
+	RedisURL          string
+	EnableMetrics     bool
+	MetricsPort       int
+	RoutingPolicy     string
+	WebhookURL        string
+	WebhookSecret     string
+	// StreamSecret signs event stream tokens, which are valid for
+	// StreamTokenTTL seconds; StreamReplayEvents are kept per organization
+	// for clients resuming a stream
+	StreamSecret string
+	// StreamAPIKey is shared with the backend, which alone may request
+	// stream tokens; without it no tokens are handed out
+	StreamAPIKey              string
+	StreamTokenTTL            int
+	StreamReplayEvents        int
+	MediaStore                string
+	MediaDir                  string
+	MediaSecret               string
+	MediaLinkTTL              int
+	MediaCacheTTL             int
+	MediaCacheEntries         int
+	MediaAllowedSchemes       []string
+	MediaMaxBytes             int
+	MediaFetchTimeout         int
+	MediaMaxRedirects         int
+	MediaAllowPrivateNetworks bool
+	// MediaDomainAllowlist limits the hosts each organization may send media from
+	MediaDomainAllowlist map[string][]string
+	// MediaInboundMaxBytes is the largest received attachment downloaded
+	MediaInboundMaxBytes int
+	S3Endpoint           string
+	S3Bucket             string
+	S3Region             string
+	S3AccessKey          string
+	S3SecretKey          string
+	// LeadTable and its columns are where inbound senders are matched to leads
+	LeadTable              string
+	LeadPhoneColumn        string
+	LeadOrganizationColumn string
+	// OptOutKeywords and OptInKeywords are whole-message replies that take a
+	// contact off or back onto the organization's audience
+	OptOutKeywords []string
+	OptInKeywords  []string
+	// OptOutReply and OptInReply confirm a keyword when set
+	OptOutReply string
+	OptInReply  string
 }
 
 func Load() *Config {
 	return &Config{
-		DatabaseURL:     getEnv("DATABASE_URL", "postgres://localhost:5432/skyfunnel"),
-		Port:           getEnvAsInt("PORT", 8081),
-		LogLevel:       getEnv("LOG_LEVEL", "info"),
-		SessionDir:     getEnv("WHATSMEOW_SESSION_DIR", "./sessions"),
-		WhatsMeowLogLevel: getEnv("WHATSMEOW_LOG_LEVEL", "info"),
-		RedisURL:       getEnv("REDIS_URL", ""),
-		EnableMetrics:  getEnvAsBool("ENABLE_METRICS", false),
-		MetricsPort:    getEnvAsInt("METRICS_PORT", 9090),
+		DatabaseURL:               getEnv("DATABASE_URL", "postgres://localhost:5432/skyfunnel"),
+		Port:                      getEnvAsInt("PORT", 8081),
+		LogLevel:                  getEnv("LOG_LEVEL", "info"),
+		SessionDir:                getEnv("WHATSMEOW_SESSION_DIR", "./sessions"),
+		WhatsMeowLogLevel:         getEnv("WHATSMEOW_LOG_LEVEL", "info"),
+		RedisURL:                  getEnv("REDIS_URL", ""),
+		EnableMetrics:             getEnvAsBool("ENABLE_METRICS", false),
+		MetricsPort:               getEnvAsInt("METRICS_PORT", 9090),
+		RoutingPolicy:             getEnv("WHATSMEOW_ROUTING_POLICY", "round-robin"),
+		WebhookURL:                getEnv("WHATSMEOW_WEBHOOK_URL", ""),
+		WebhookSecret:             getEnv("WHATSMEOW_WEBHOOK_SECRET", ""),
+		StreamSecret:              getEnv("WHATSMEOW_STREAM_SECRET", ""),
+		StreamAPIKey:              getEnv("WHATSMEOW_STREAM_API_KEY", ""),
+		StreamTokenTTL:            getEnvAsInt("WHATSMEOW_STREAM_TOKEN_TTL", 3600),
+		StreamReplayEvents:        getEnvAsInt("WHATSMEOW_STREAM_REPLAY_EVENTS", 1000),
+		MediaStore:                getEnv("WHATSMEOW_MEDIA_STORE", "filesystem"),
+		MediaDir:                  getEnv("WHATSMEOW_MEDIA_DIR", "./media"),
+		MediaSecret:               getEnv("WHATSMEOW_MEDIA_SECRET", ""),
+		MediaLinkTTL:              getEnvAsInt("WHATSMEOW_MEDIA_LINK_TTL", 900),
+		MediaInboundMaxBytes:      getEnvAsInt("WHATSMEOW_MEDIA_INBOUND_MAX_BYTES", 100<<20),
+		MediaCacheTTL:             getEnvAsInt("WHATSMEOW_MEDIA_CACHE_TTL", 86400),
+		MediaCacheEntries:         getEnvAsInt("WHATSMEOW_MEDIA_CACHE_ENTRIES", 1000),
+		MediaAllowedSchemes:       getEnvAsList("WHATSMEOW_MEDIA_ALLOWED_SCHEMES", []string{"https", "http"}),
+		MediaMaxBytes:             getEnvAsInt("WHATSMEOW_MEDIA_MAX_BYTES", 100<<20),
+		MediaFetchTimeout:         getEnvAsInt("WHATSMEOW_MEDIA_FETCH_TIMEOUT", 60),
+		MediaMaxRedirects:         getEnvAsInt("WHATSMEOW_MEDIA_MAX_REDIRECTS", 5),
+		MediaAllowPrivateNetworks: getEnvAsBool("WHATSMEOW_MEDIA_ALLOW_PRIVATE_NETWORKS", false),
+		MediaDomainAllowlist:      getEnvAsListMap("WHATSMEOW_MEDIA_DOMAIN_ALLOWLIST"),
+		S3Endpoint:                getEnv("WHATSMEOW_S3_ENDPOINT", ""),
+		S3Bucket:                  getEnv("WHATSMEOW_S3_BUCKET", ""),
+		S3Region:                  getEnv("WHATSMEOW_S3_REGION", "us-east-1"),
+		S3AccessKey:               getEnv("WHATSMEOW_S3_ACCESS_KEY", ""),
+		S3SecretKey:               getEnv("WHATSMEOW_S3_SECRET_KEY", ""),
+		LeadTable:                 getEnv("WHATSMEOW_LEAD_TABLE", "Lead"),
+		LeadPhoneColumn:           getEnv("WHATSMEOW_LEAD_PHONE_COLUMN", "phone"),
+		LeadOrganizationColumn:    getEnv("WHATSMEOW_LEAD_ORGANIZATION_COLUMN", "organizationId"),
+		OptOutKeywords:            getEnvAsList("WHATSMEOW_OPT_OUT_KEYWORDS", nil),
+		OptInKeywords:             getEnvAsList("WHATSMEOW_OPT_IN_KEYWORDS", nil),
+		OptOutReply:               getEnv("WHATSMEOW_OPT_OUT_REPLY", ""),
+		OptInReply:                getEnv("WHATSMEOW_OPT_IN_REPLY", ""),
 	}
 }
 
@@ -53,3 +129,36 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvAsList reads a comma-separated list
+func getEnvAsList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvAsListMap reads lists keyed by name, written as
+// "name1=a,b;name2=c"
+func getEnvAsListMap(key string) map[string][]string {
+	lists := make(map[string][]string)
+	for _, entry := range strings.Split(os.Getenv(key), ";") {
+		name, items, ok := strings.Cut(entry, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			continue
+		}
+		for _, item := range strings.Split(items, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				lists[name] = append(lists[name], item)
+			}
+		}
+	}
+	return lists
+}
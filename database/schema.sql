@@ -15,6 +15,7 @@ CREATE TABLE IF NOT EXISTS "WhatsAppMeowAccount" (
     profile_picture TEXT,
     last_seen TIMESTAMP,
     connection_status VARCHAR(20) DEFAULT 'DISCONNECTED',
+    read_receipts VARCHAR(20) NOT NULL DEFAULT 'NEVER',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     
@@ -25,7 +26,7 @@ CREATE TABLE IF NOT EXISTS "WhatsAppMeowAccount" (
 CREATE TABLE IF NOT EXISTS "WhatsAppMeowMessage" (
     id VARCHAR(255) PRIMARY KEY DEFAULT gen_random_uuid()::text,
     whats_app_meow_account_id VARCHAR(255) NOT NULL,
-    message_id VARCHAR(255) UNIQUE NOT NULL,
+    message_id VARCHAR(255) NOT NULL,
     lead_id VARCHAR(255),
     from_jid VARCHAR(255) NOT NULL,
     to_jid VARCHAR(255) NOT NULL,
@@ -33,6 +34,11 @@ CREATE TABLE IF NOT EXISTS "WhatsAppMeowMessage" (
     message_text TEXT,
     media_url TEXT,
     media_type VARCHAR(50),
+    media_reference JSONB,
+    quoted_message_id VARCHAR(255),
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    is_from_me BOOLEAN DEFAULT false,
     is_sent BOOLEAN DEFAULT false,
     is_delivered BOOLEAN DEFAULT false,
     is_read BOOLEAN DEFAULT false,
@@ -43,11 +49,154 @@ CREATE TABLE IF NOT EXISTS "WhatsAppMeowMessage" (
     error_code VARCHAR(50),
     error_message TEXT,
     retry_count INTEGER DEFAULT 0,
+    edited_at TIMESTAMP,
+    edit_history JSONB,
+    is_revoked BOOLEAN DEFAULT false,
+    revoked_at TIMESTAMP,
+    template_id VARCHAR(255),
     
+    -- WhatsApp message IDs are only unique within one account
+    CONSTRAINT uq_message_account_message UNIQUE (whats_app_meow_account_id, message_id),
     CONSTRAINT fk_account FOREIGN KEY (whats_app_meow_account_id) REFERENCES "WhatsAppMeowAccount"(id) ON DELETE CASCADE,
     CONSTRAINT fk_lead FOREIGN KEY (lead_id) REFERENCES "Lead"(id) ON DELETE SET NULL
 );
 
+-- Message IDs used to be unique on their own. The new key has to exist
+-- before reactions reference it; CASCADE drops the old reaction foreign key,
+-- which is recreated with the reaction columns further down.
+DO $$ BEGIN
+    IF EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'WhatsAppMeowMessage_message_id_key') THEN
+        ALTER TABLE "WhatsAppMeowMessage" DROP CONSTRAINT "WhatsAppMeowMessage_message_id_key" CASCADE;
+        ALTER TABLE "WhatsAppMeowMessage"
+            ADD CONSTRAINT uq_message_account_message UNIQUE (whats_app_meow_account_id, message_id);
+    END IF;
+END $$;
+
+-- Reactions hold one row per message and reacting participant
+CREATE TABLE IF NOT EXISTS "WhatsAppMeowReaction" (
+    whats_app_meow_account_id VARCHAR(255) NOT NULL,
+    message_id VARCHAR(255) NOT NULL,
+    sender_jid VARCHAR(255) NOT NULL,
+    reaction VARCHAR(32) NOT NULL,
+    is_from_me BOOLEAN DEFAULT false,
+    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (whats_app_meow_account_id, message_id, sender_jid),
+    CONSTRAINT fk_message FOREIGN KEY (whats_app_meow_account_id, message_id)
+        REFERENCES "WhatsAppMeowMessage"(whats_app_meow_account_id, message_id) ON DELETE CASCADE
+);
+
+-- Contacts who opted out of an organization's messages; sends to them are refused
+CREATE TABLE IF NOT EXISTS "WhatsAppMeowSuppression" (
+    organization_id VARCHAR(255) NOT NULL,
+    jid VARCHAR(255) NOT NULL,
+    keyword VARCHAR(64) NOT NULL DEFAULT '',
+    message_id VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (organization_id, jid),
+    CONSTRAINT fk_organization FOREIGN KEY (organization_id) REFERENCES "Organization"(id) ON DELETE CASCADE
+);
+
+-- Message templates of an organization, with one variant per language
+CREATE TABLE IF NOT EXISTS "WhatsAppMeowTemplate" (
+    id VARCHAR(255) PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    organization_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    default_language VARCHAR(20) NOT NULL,
+    variants JSONB NOT NULL,
+    defaults JSONB NOT NULL DEFAULT '{}',
+    variation JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (organization_id, name),
+    CONSTRAINT fk_organization FOREIGN KEY (organization_id) REFERENCES "Organization"(id) ON DELETE CASCADE
+);
+
+-- Groups an account is in, as last read from WhatsApp
+CREATE TABLE IF NOT EXISTS "WhatsAppMeowGroup" (
+    id VARCHAR(255) PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    whats_app_meow_account_id VARCHAR(255) NOT NULL,
+    jid VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    owner_jid VARCHAR(255) NOT NULL DEFAULT '',
+    is_announce BOOLEAN NOT NULL DEFAULT false,
+    is_locked BOOLEAN NOT NULL DEFAULT false,
+    participants JSONB NOT NULL DEFAULT '[]',
+    invite_link TEXT,
+    photo_id VARCHAR(255),
+    group_created_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (whats_app_meow_account_id, jid),
+    CONSTRAINT fk_account FOREIGN KEY (whats_app_meow_account_id) REFERENCES "WhatsAppMeowAccount"(id) ON DELETE CASCADE
+);
+
+-- Auto-reply rules answer inbound messages of an account, tried by priority
+CREATE TABLE IF NOT EXISTS "WhatsAppMeowAutoReplyRule" (
+    id VARCHAR(255) PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    whats_app_meow_account_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    priority INTEGER NOT NULL DEFAULT 0,
+    is_enabled BOOLEAN DEFAULT true,
+    match_type VARCHAR(20) NOT NULL,
+    pattern TEXT NOT NULL DEFAULT '',
+    schedule VARCHAR(30) NOT NULL DEFAULT 'ALWAYS',
+    business_hours JSONB,
+    cooldown_seconds INTEGER NOT NULL DEFAULT 0,
+    response JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_account FOREIGN KEY (whats_app_meow_account_id) REFERENCES "WhatsAppMeowAccount"(id) ON DELETE CASCADE
+);
+
+-- When each rule last answered a contact, for cooldowns
+CREATE TABLE IF NOT EXISTS "WhatsAppMeowAutoReply" (
+    rule_id VARCHAR(255) NOT NULL,
+    contact_jid VARCHAR(255) NOT NULL,
+    replied_at TIMESTAMP NOT NULL,
+
+    PRIMARY KEY (rule_id, contact_jid),
+    CONSTRAINT fk_rule FOREIGN KEY (rule_id) REFERENCES "WhatsAppMeowAutoReplyRule"(id) ON DELETE CASCADE
+);
+
+-- Columns added after the tables were first created; CREATE TABLE IF NOT
+-- EXISTS leaves existing tables alone, so they are added here too
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS is_from_me BOOLEAN DEFAULT false;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS quoted_message_id VARCHAR(255);
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS edit_history JSONB;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS is_revoked BOOLEAN DEFAULT false;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS media_reference JSONB;
+ALTER TABLE "WhatsAppMeowMessage" ADD COLUMN IF NOT EXISTS template_id VARCHAR(255);
+ALTER TABLE "WhatsAppMeowAccount" ADD COLUMN IF NOT EXISTS read_receipts VARCHAR(20) NOT NULL DEFAULT 'NEVER';
+
+-- Reactions used to be keyed by message ID alone
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_name = 'WhatsAppMeowReaction' AND column_name = 'whats_app_meow_account_id') THEN
+        ALTER TABLE "WhatsAppMeowReaction" ADD COLUMN whats_app_meow_account_id VARCHAR(255);
+        UPDATE "WhatsAppMeowReaction" r
+        SET whats_app_meow_account_id = m.whats_app_meow_account_id
+        FROM "WhatsAppMeowMessage" m
+        WHERE m.message_id = r.message_id;
+        ALTER TABLE "WhatsAppMeowReaction" ALTER COLUMN whats_app_meow_account_id SET NOT NULL;
+
+        ALTER TABLE "WhatsAppMeowReaction" DROP CONSTRAINT IF EXISTS fk_message;
+        ALTER TABLE "WhatsAppMeowReaction" DROP CONSTRAINT "WhatsAppMeowReaction_pkey";
+        ALTER TABLE "WhatsAppMeowReaction" ADD PRIMARY KEY (whats_app_meow_account_id, message_id, sender_jid);
+        ALTER TABLE "WhatsAppMeowReaction" ADD CONSTRAINT fk_message FOREIGN KEY (whats_app_meow_account_id, message_id)
+            REFERENCES "WhatsAppMeowMessage"(whats_app_meow_account_id, message_id) ON DELETE CASCADE;
+    END IF;
+END $$;
+
 -- Indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_whatsmeow_account_org ON "WhatsAppMeowAccount"(organization_id);
 CREATE INDEX IF NOT EXISTS idx_whatsmeow_account_device ON "WhatsAppMeowAccount"(device_id);
@@ -56,6 +205,10 @@ CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_lead ON "WhatsAppMeowMessage"(l
 CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_from ON "WhatsAppMeowMessage"(from_jid);
 CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_to ON "WhatsAppMeowMessage"(to_jid);
 CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_timestamp ON "WhatsAppMeowMessage"(timestamp);
+CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_history ON "WhatsAppMeowMessage"(whats_app_meow_account_id, timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_outbound ON "WhatsAppMeowMessage"(whats_app_meow_account_id, is_from_me, timestamp);
+CREATE INDEX IF NOT EXISTS idx_whatsmeow_message_template ON "WhatsAppMeowMessage"(template_id);
+CREATE INDEX IF NOT EXISTS idx_whatsmeow_auto_reply_rule_account ON "WhatsAppMeowAutoReplyRule"(whats_app_meow_account_id, priority);
 
 -- Enums (if your database supports them)
 -- For PostgreSQL, you can create these as custom types
@@ -72,6 +225,9 @@ EXCEPTION
     WHEN duplicate_object THEN null;
 END $$;
 
+ALTER TYPE whatsapp_meow_connection_status ADD VALUE IF NOT EXISTS 'LOGGED_OUT';
+ALTER TYPE whatsapp_meow_connection_status ADD VALUE IF NOT EXISTS 'BANNED';
+
 DO $$ BEGIN
     CREATE TYPE whatsapp_meow_message_type AS ENUM (
         'TEXT',
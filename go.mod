@@ -3,8 +3,11 @@ module whatsmeow-service
 go 1.24.0
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	github.com/lib/pq v1.10.9
 	go.mau.fi/whatsmeow v0.0.0-20250929162548-7c04e9b206b1
+	golang.org/x/image v0.25.0
+	google.golang.org/protobuf v1.36.9
 )
 
 require (
@@ -12,7 +15,6 @@ require (
 	github.com/beeper/argo-go v1.1.2 // indirect
 	github.com/elliotchance/orderedmap/v3 v3.1.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/petermattis/goid v0.0.0-20250904145737-900bdf8bb490 // indirect
@@ -25,5 +27,4 @@ require (
 	golang.org/x/net v0.44.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
 	golang.org/x/text v0.29.0 // indirect
-	google.golang.org/protobuf v1.36.9 // indirect
 )
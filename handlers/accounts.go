@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"whatsmeow-service/models"
+)
+
+// ListAccounts handles listing the accounts of an organization
+func (h *Handlers) ListAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID := r.URL.Query().Get("organizationId")
+	if organizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	accounts, err := h.service.ListAccounts(organizationID)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to list accounts", err, errorStatus(err))
+		return
+	}
+
+	response := models.AccountListResponse{
+		Success:  true,
+		Accounts: accounts,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// CreateAccount handles account provisioning requests
+func (h *Handlers) CreateAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.CreateAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId is required"), http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.service.CreateAccount(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to create account", err, errorStatus(err))
+		return
+	}
+
+	response := models.AccountResponse{
+		Success: true,
+		Account: account,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusCreated)
+}
+
+// UpdateAccount handles account settings changes
+func (h *Handlers) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.UpdateAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.AccountID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and accountId are required"), http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.service.UpdateAccount(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to update account", err, errorStatus(err))
+		return
+	}
+
+	response := models.AccountResponse{
+		Success: true,
+		Account: account,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// DeleteAccount handles account removal requests
+func (h *Handlers) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		OrganizationID string `json:"organizationId"`
+		AccountID      string `json:"accountId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.AccountID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and accountId are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteAccount(req.OrganizationID, req.AccountID); err != nil {
+		h.sendErrorResponse(w, "Failed to delete account", err, errorStatus(err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Account deleted",
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// Logout handles unlinking an account's device
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		OrganizationID string `json:"organizationId"`
+		AccountID      string `json:"accountId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId is required"), http.StatusBadRequest)
+		return
+	}
+
+	remoteUnlinked, err := h.service.Logout(req.OrganizationID, req.AccountID)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to log out", err, errorStatus(err))
+		return
+	}
+
+	response := models.LogoutResponse{
+		Success:        true,
+		RemoteUnlinked: remoteUnlinked,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
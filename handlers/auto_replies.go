@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"whatsmeow-service/models"
+)
+
+// ListAutoReplyRules handles listing the auto-reply rules of an account
+func (h *Handlers) ListAutoReplyRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	organizationID := query.Get("organizationId")
+	if organizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	rules, err := h.service.ListAutoReplyRules(organizationID, query.Get("accountId"))
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to list auto-reply rules", err, errorStatus(err))
+		return
+	}
+
+	response := models.AutoReplyRuleListResponse{
+		Success: true,
+		Rules:   rules,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// CreateAutoReplyRule handles adding an auto-reply rule to an account
+func (h *Handlers) CreateAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.AutoReplyRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId is required"), http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.service.CreateAutoReplyRule(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to create auto-reply rule", err, errorStatus(err))
+		return
+	}
+
+	response := models.AutoReplyRuleResponse{
+		Success: true,
+		Rule:    rule,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusCreated)
+}
+
+// UpdateAutoReplyRule handles replacing the settings of an auto-reply rule
+func (h *Handlers) UpdateAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.AutoReplyRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.RuleID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and ruleId are required"), http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.service.UpdateAutoReplyRule(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to update auto-reply rule", err, errorStatus(err))
+		return
+	}
+
+	response := models.AutoReplyRuleResponse{
+		Success: true,
+		Rule:    rule,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// DeleteAutoReplyRule handles removing an auto-reply rule
+func (h *Handlers) DeleteAutoReplyRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		OrganizationID string `json:"organizationId"`
+		RuleID         string `json:"ruleId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.RuleID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and ruleId are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteAutoReplyRule(req.OrganizationID, req.RuleID); err != nil {
+		h.sendErrorResponse(w, "Failed to delete auto-reply rule", err, errorStatus(err))
+		return
+	}
+
+	response := models.AutoReplyRuleResponse{
+		Success: true,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// TestAutoReply handles dry runs that show which rule would answer a sample
+// message
+func (h *Handlers) TestAutoReply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.AutoReplyTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId is required"), http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.service.TestAutoReply(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to test auto-replies", err, errorStatus(err))
+		return
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"whatsmeow-service/models"
+)
+
+// ListGroups handles requests for the groups an account is in
+func (h *Handlers) ListGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	organizationID := params.Get("organizationId")
+	if organizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+	var refresh bool
+	if value := params.Get("refresh"); value != "" {
+		var err error
+		if refresh, err = strconv.ParseBool(value); err != nil {
+			h.sendErrorResponse(w, "Invalid parameter", fmt.Errorf("refresh must be true or false"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	groups, err := h.service.ListGroups(organizationID, params.Get("accountId"), refresh)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to list groups", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupListResponse{
+		Success: true,
+		Groups:  groups,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// CreateGroup handles creating a group with participants
+func (h *Handlers) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.Name == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and name are required"), http.StatusBadRequest)
+		return
+	}
+
+	group, failed, err := h.service.CreateGroup(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to create group", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupResponse{
+		Success: true,
+		Group:   group,
+		Failed:  failed,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusCreated)
+}
+
+// UpdateGroupParticipants handles adding, removing, promoting and demoting
+// group participants
+func (h *Handlers) UpdateGroupParticipants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.GroupParticipantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.GroupJID == "" || req.Action == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId, groupJid and action are required"), http.StatusBadRequest)
+		return
+	}
+
+	group, failed, err := h.service.UpdateGroupParticipants(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to update group participants", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupResponse{
+		Success: true,
+		Group:   group,
+		Failed:  failed,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// UpdateGroup handles changing the name and description of a group
+func (h *Handlers) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.UpdateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.GroupJID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and groupJid are required"), http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.service.UpdateGroup(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to update group", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupResponse{
+		Success: true,
+		Group:   group,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// SetGroupPhoto handles setting or removing the picture of a group
+func (h *Handlers) SetGroupPhoto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.GroupPhotoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.GroupJID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and groupJid are required"), http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.service.SetGroupPhoto(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to set group photo", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupResponse{
+		Success: true,
+		Group:   group,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// GetGroupInviteLink handles requests for the invite link of a group
+func (h *Handlers) GetGroupInviteLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	req := models.GroupRequest{
+		OrganizationID: params.Get("organizationId"),
+		AccountID:      params.Get("accountId"),
+		GroupJID:       params.Get("groupJid"),
+	}
+	if req.OrganizationID == "" || req.GroupJID == "" {
+		h.sendErrorResponse(w, "Missing required parameters", fmt.Errorf("organizationId and groupJid parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	h.sendGroupInviteLink(w, req, false)
+}
+
+// RevokeGroupInviteLink handles replacing the invite link of a group, so
+// the old one stops working
+func (h *Handlers) RevokeGroupInviteLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.GroupJID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and groupJid are required"), http.StatusBadRequest)
+		return
+	}
+
+	h.sendGroupInviteLink(w, req, true)
+}
+
+func (h *Handlers) sendGroupInviteLink(w http.ResponseWriter, req models.GroupRequest, reset bool) {
+	link, err := h.service.GroupInviteLink(req, reset)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to get invite link", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupInviteLinkResponse{
+		Success:    true,
+		GroupJID:   req.GroupJID,
+		InviteLink: link,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// JoinGroup handles joining a group with an invite code
+func (h *Handlers) JoinGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.JoinGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.InviteCode == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and inviteCode are required"), http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.service.JoinGroup(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to join group", err, errorStatus(err))
+		return
+	}
+
+	response := models.GroupResponse{
+		Success: true,
+		Group:   group,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// LeaveGroup handles leaving a group
+func (h *Handlers) LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.GroupJID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and groupJid are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.LeaveGroup(req); err != nil {
+		h.sendErrorResponse(w, "Failed to leave group", err, errorStatus(err))
+		return
+	}
+
+	h.sendJSONResponse(w, models.GroupResponse{Success: true}, http.StatusOK)
+}
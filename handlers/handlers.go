@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"whatsmeow-service/config"
 	"whatsmeow-service/models"
 	"whatsmeow-service/services"
+	"whatsmeow-service/store"
 )
 
 type Handlers struct {
@@ -38,15 +40,15 @@ func (h *Handlers) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if req.OrganizationID == "" || req.ToJID == "" || req.MessageType == "" {
-		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId, toJID, and messageType are required"), http.StatusBadRequest)
+	if req.OrganizationID == "" || req.ToJID == "" || (req.MessageType == "" && req.TemplateID == "") {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId, toJID, and messageType or templateId are required"), http.StatusBadRequest)
 		return
 	}
 
 	// Send message via service
 	messageID, err := h.service.SendMessage(req)
 	if err != nil {
-		h.sendErrorResponse(w, "Failed to send message", err, http.StatusInternalServerError)
+		h.sendErrorResponse(w, "Failed to send message", err, errorStatus(err))
 		return
 	}
 
@@ -66,9 +68,9 @@ func (h *Handlers) GetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	account, err := h.service.GetAccount(organizationID)
+	account, err := h.service.GetAccount(organizationID, r.URL.Query().Get("accountId"))
 	if err != nil {
-		h.sendErrorResponse(w, "Failed to get account", err, http.StatusInternalServerError)
+		h.sendErrorResponse(w, "Failed to get account", err, errorStatus(err))
 		return
 	}
 
@@ -88,9 +90,9 @@ func (h *Handlers) GetQR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	qrCode, err := h.service.GetQRCode(organizationID)
+	qrCode, err := h.service.GetQRCode(organizationID, r.URL.Query().Get("accountId"))
 	if err != nil {
-		h.sendErrorResponse(w, "Failed to get QR code", err, http.StatusInternalServerError)
+		h.sendErrorResponse(w, "Failed to get QR code", err, errorStatus(err))
 		return
 	}
 
@@ -111,6 +113,7 @@ func (h *Handlers) Connect(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		OrganizationID string `json:"organizationId"`
+		AccountID      string `json:"accountId"`
 		DeviceID       string `json:"deviceId"`
 	}
 
@@ -119,14 +122,14 @@ func (h *Handlers) Connect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.OrganizationID == "" || req.DeviceID == "" {
-		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and deviceId are required"), http.StatusBadRequest)
+	if req.OrganizationID == "" || (req.DeviceID == "" && req.AccountID == "") {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and either accountId or deviceId are required"), http.StatusBadRequest)
 		return
 	}
 
-	err := h.service.Connect(req.OrganizationID, req.DeviceID)
+	err := h.service.Connect(req.OrganizationID, req.AccountID, req.DeviceID)
 	if err != nil {
-		h.sendErrorResponse(w, "Failed to connect", err, http.StatusInternalServerError)
+		h.sendErrorResponse(w, "Failed to connect", err, errorStatus(err))
 		return
 	}
 
@@ -147,6 +150,7 @@ func (h *Handlers) Disconnect(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		OrganizationID string `json:"organizationId"`
+		AccountID      string `json:"accountId"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -159,9 +163,9 @@ func (h *Handlers) Disconnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.service.Disconnect(req.OrganizationID)
+	err := h.service.Disconnect(req.OrganizationID, req.AccountID)
 	if err != nil {
-		h.sendErrorResponse(w, "Failed to disconnect", err, http.StatusInternalServerError)
+		h.sendErrorResponse(w, "Failed to disconnect", err, errorStatus(err))
 		return
 	}
 
@@ -195,12 +199,53 @@ func (h *Handlers) sendJSONResponse(w http.ResponseWriter, data interface{}, sta
 	}
 }
 
+// errorStatus maps service errors to the HTTP status reported to callers
+func errorStatus(err error) int {
+	var fetchErr *services.MediaFetchError
+	var optedOut *services.OptedOutError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrAccountRequired), errors.Is(err, services.ErrQuotedMessageAccount),
+		errors.Is(err, services.ErrMessageNotFromMe), errors.Is(err, services.ErrMessageNotEditable),
+		errors.Is(err, services.ErrEditWindowExpired), errors.Is(err, services.ErrInvalidMessage),
+		errors.Is(err, services.ErrInvalidQuery), errors.Is(err, services.ErrInvalidRule),
+		errors.Is(err, services.ErrInvalidTemplate), errors.Is(err, services.ErrInvalidPresence),
+		errors.Is(err, services.ErrInvalidReadReceiptPolicy), errors.Is(err, services.ErrInvalidGroup),
+		errors.Is(err, services.ErrInvalidRoutingPolicy):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrStreamTokenInvalid):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrNoAccountAvailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, services.ErrMediaLinkInvalid), errors.Is(err, services.ErrGroupForbidden), errors.As(err, &optedOut):
+		return http.StatusForbidden
+	case errors.As(err, &fetchErr):
+		if fetchErr.Code == services.MediaErrUnavailable || fetchErr.Code == services.MediaErrTimeout {
+			return http.StatusBadGateway
+		}
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorCode returns the machine-readable code of errors that carry one
+func errorCode(err error) string {
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return ""
+}
+
 func (h *Handlers) sendErrorResponse(w http.ResponseWriter, message string, err error, statusCode int) {
 	log.Printf("Error: %s - %v", message, err)
 	
 	response := models.SendMessageResponse{
 		Success: false,
 		Error:   fmt.Sprintf("%s: %v", message, err),
+		Code:    errorCode(err),
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsmeow-service/config"
+	"whatsmeow-service/models"
+	"whatsmeow-service/services"
+	"whatsmeow-service/store"
+)
+
+func newTestHandlers(t *testing.T) (*Handlers, *store.Memory, *services.FakeClient) {
+	t.Helper()
+
+	st := store.NewMemory()
+	err := st.CreateAccount(&models.WhatsAppMeowAccount{
+		OrganizationID:   "org_1",
+		DeviceID:         "device_1",
+		IsConnected:      true,
+		ConnectionStatus: models.ConnectionStatusConnected,
+	})
+	if err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	cfg := &config.Config{}
+	fake := services.NewFakeClient(types.NewJID("15550000001", types.DefaultUserServer))
+	svc := services.NewWhatsAppMeowService(cfg, st, fake.Factory())
+	return NewHandlers(cfg, svc), st, fake
+}
+
+func TestSendMessageHandler(t *testing.T) {
+	h, st, fake := newTestHandlers(t)
+
+	body := `{"organizationId":"org_1","toJID":"15550000002@s.whatsapp.net","messageType":"text","messageText":"Hi"}`
+	rec := httptest.NewRecorder()
+	h.SendMessage(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/send", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp models.SendMessageResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !resp.Success || resp.MessageID == "" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(fake.SentMessages()) != 1 {
+		t.Errorf("expected one message to be sent")
+	}
+	if stored, err := st.ListMessages(store.MessageFilter{MessageID: resp.MessageID}); err != nil || len(stored) != 1 {
+		t.Errorf("expected message to be stored: %v", err)
+	}
+}
+
+func TestSendMessageHandlerValidation(t *testing.T) {
+	h, st, fake := newTestHandlers(t)
+	st.AddSuppression(&models.WhatsAppMeowSuppression{OrganizationID: "org_1", JID: "15550000009@s.whatsapp.net"})
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid json", http.MethodPost, "{", http.StatusBadRequest},
+		{"missing fields", http.MethodPost, `{"organizationId":"org_1"}`, http.StatusBadRequest},
+		{"unknown type", http.MethodPost, `{"organizationId":"org_1","toJID":"1@s.whatsapp.net","messageType":"poll"}`, http.StatusInternalServerError},
+		{"internal media", http.MethodPost, `{"organizationId":"org_1","toJID":"1@s.whatsapp.net","messageType":"image","mediaUrl":"http://127.0.0.1:9/a.png"}`, http.StatusBadRequest},
+		{"media scheme", http.MethodPost, `{"organizationId":"org_1","toJID":"1@s.whatsapp.net","messageType":"image","mediaUrl":"file:///etc/passwd"}`, http.StatusBadRequest},
+		{"opted out", http.MethodPost, `{"organizationId":"org_1","toJID":"15550000009@s.whatsapp.net","messageType":"text","messageText":"Hi"}`, http.StatusForbidden},
+		{"unknown template", http.MethodPost, `{"organizationId":"org_1","toJID":"1@s.whatsapp.net","templateId":"missing"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.SendMessage(rec, httptest.NewRequest(tt.method, "/api/whatsmeow/send", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	if len(fake.SentMessages()) != 0 {
+		t.Errorf("expected nothing to be sent")
+	}
+}
+
+func TestConnectAndStatusHandlers(t *testing.T) {
+	h, _, fake := newTestHandlers(t)
+
+	rec := httptest.NewRecorder()
+	h.Connect(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/connect",
+		strings.NewReader(`{"organizationId":"org_1","deviceId":"device_1"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	fake.EmitQR("qr-code-1")
+
+	rec = httptest.NewRecorder()
+	h.GetQR(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/qr?organizationId=org_1", nil))
+	var qr models.QRCodeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&qr); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if qr.QRCode != "qr-code-1" {
+		t.Errorf("expected QR code, got %+v", qr)
+	}
+
+	fake.EmitDisconnected()
+
+	rec = httptest.NewRecorder()
+	h.GetStatus(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/status?organizationId=org_1", nil))
+	var status models.ConnectionStatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if status.Account == nil || status.Account.ConnectionStatus != models.ConnectionStatusDisconnected {
+		t.Errorf("expected disconnected account, got %+v", status.Account)
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetStatus(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/status", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without organizationId, got %d", rec.Code)
+	}
+}
+
+func TestAccountHandlers(t *testing.T) {
+	h, _, _ := newTestHandlers(t)
+
+	rec := httptest.NewRecorder()
+	h.CreateAccount(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/accounts/create",
+		strings.NewReader(`{"organizationId":"org_1","displayName":"Sales 2"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created models.AccountResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.Account == nil || created.Account.DeviceID == "" {
+		t.Fatalf("expected account with generated device ID, got %+v", created.Account)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ListAccounts(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/accounts?organizationId=org_1", nil))
+	var list models.AccountListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list.Accounts) != 2 {
+		t.Fatalf("expected 2 accounts, got %d", len(list.Accounts))
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetStatus(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/status?organizationId=org_1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when accountId is ambiguous, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.UpdateAccount(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/accounts/update",
+		strings.NewReader(`{"organizationId":"org_1","accountId":"`+created.Account.ID+`","displayName":"Sales Two"}`)))
+	var updated models.AccountResponse
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if updated.Account == nil || updated.Account.DisplayName == nil || *updated.Account.DisplayName != "Sales Two" {
+		t.Errorf("expected renamed account, got %+v", updated.Account)
+	}
+
+	rec = httptest.NewRecorder()
+	h.DeleteAccount(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/accounts/delete",
+		strings.NewReader(`{"organizationId":"org_2","accountId":"`+created.Account.ID+`"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when deleting another organization's account, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.DeleteAccount(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/accounts/delete",
+		strings.NewReader(`{"organizationId":"org_1","accountId":"`+created.Account.ID+`"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAutoReplyHandlers(t *testing.T) {
+	h, _, _ := newTestHandlers(t)
+
+	rec := httptest.NewRecorder()
+	h.CreateAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/auto-replies/create",
+		strings.NewReader(`{"organizationId":"org_1","name":"Pricing","matchType":"contains","pattern":"price",
+			"response":{"messageType":"text","messageText":"Our prices: {{contact.phone}}"}}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created models.AutoReplyRuleResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.Rule == nil || !created.Rule.IsEnabled || created.Rule.Schedule != models.AutoReplyAlways {
+		t.Fatalf("expected an enabled rule with defaults, got %+v", created.Rule)
+	}
+
+	rec = httptest.NewRecorder()
+	h.CreateAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/auto-replies/create",
+		strings.NewReader(`{"organizationId":"org_1","matchType":"regex","pattern":"(","response":{"messageType":"text","messageText":"Hi"}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid pattern, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.TestAutoReply(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/auto-replies/test",
+		strings.NewReader(`{"organizationId":"org_1","messageText":"What is the PRICE?","fromJID":"15550000002@s.whatsapp.net"}`)))
+	var dryRun models.AutoReplyTestResponse
+	if err := json.NewDecoder(rec.Body).Decode(&dryRun); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if dryRun.Rule == nil || dryRun.Rule.ID != created.Rule.ID || dryRun.Reply.MessageText != "Our prices: 15550000002" {
+		t.Errorf("expected the pricing rule to fire, got %+v", dryRun)
+	}
+
+	rec = httptest.NewRecorder()
+	h.DeleteAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/auto-replies/delete",
+		strings.NewReader(`{"organizationId":"org_2","ruleId":"`+created.Rule.ID+`"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when deleting another organization's rule, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.DeleteAutoReplyRule(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/auto-replies/delete",
+		strings.NewReader(`{"organizationId":"org_1","ruleId":"`+created.Rule.ID+`"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestEventStreamHandlers(t *testing.T) {
+	h, _, fake := newTestHandlers(t)
+	h.service.UseEventStream(services.NewEventStream(10), nil)
+	tokenRequest := func(apiKey string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/api/whatsmeow/events/token?organizationId=org_1", nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		return r
+	}
+
+	// Tokens are only handed out to callers holding the stream API key
+	rec := httptest.NewRecorder()
+	h.GetStreamToken(rec, tokenRequest("backend-key"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a configured key, got %d: %s", rec.Code, rec.Body)
+	}
+	h.config.StreamAPIKey = "backend-key"
+	for _, apiKey := range []string{"", "guess"} {
+		rec = httptest.NewRecorder()
+		h.GetStreamToken(rec, tokenRequest(apiKey))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for key %q, got %d: %s", apiKey, rec.Code, rec.Body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetStreamToken(rec, tokenRequest("backend-key"))
+	var token models.StreamTokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&token); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !token.Success || token.Token == "" || !strings.HasPrefix(token.URL, services.EventStreamPath+"?") {
+		t.Fatalf("expected a stream token, got %+v", token)
+	}
+
+	rec = httptest.NewRecorder()
+	h.StreamEvents(rec, httptest.NewRequest(http.MethodGet, services.EventStreamPath+"?organizationId=org_2&token="+token.Token, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for another organization's token, got %d: %s", rec.Code, rec.Body)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(services.EventStreamPath, h.StreamEvents)
+	mux.HandleFunc(services.EventSocketPath, h.StreamEventsWebSocket)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + token.URL)
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() && lines.Text() != ": connected" {
+	}
+
+	if err := h.service.Connect("org_1", "", "device_1"); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	fake.EmitQR("qr-code-1")
+
+	fields := make(map[string]string)
+	for lines.Scan() && (lines.Text() != "" || len(fields) == 0) {
+		if name, value, ok := strings.Cut(lines.Text(), ": "); ok {
+			fields[name] = value
+		}
+	}
+	var qr models.Event
+	if err := json.Unmarshal([]byte(fields["data"]), &qr); err != nil {
+		t.Fatalf("decode event %v: %v", fields, err)
+	}
+	if fields["event"] != "account.qr" || qr.Type != models.EventAccountQR || qr.ID == "" || fields["id"] != qr.ID {
+		t.Fatalf("expected a QR event, got %v", fields)
+	}
+
+	// A WebSocket client resuming from an unknown ID is told it missed
+	// events and gets the buffer
+	socketURL := "ws" + strings.TrimPrefix(server.URL, "http") + token.WebSocketURL
+	conn, _, err := websocket.DefaultDialer.Dial(socketURL+"&lastEventId=gone", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	for _, want := range []models.EventType{models.EventStreamReset, models.EventAccountQR} {
+		var event models.Event
+		if err := conn.ReadJSON(&event); err != nil || event.Type != want {
+			t.Fatalf("expected %s, got %+v (%v)", want, event, err)
+		}
+	}
+
+	fake.Emit(&events.Connected{})
+	var connected models.Event
+	if err := conn.ReadJSON(&connected); err != nil || connected.Type != models.EventAccountConnected {
+		t.Errorf("expected a connected event, got %+v (%v)", connected, err)
+	}
+}
+
+func TestGroupHandlers(t *testing.T) {
+	h, _, _ := newTestHandlers(t)
+	if err := h.service.Connect("org_1", "", "device_1"); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.CreateGroup(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/groups/create",
+		strings.NewReader(`{"organizationId":"org_1","name":"Spring launch","participants":["15550000002"]}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created models.GroupResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.Group == nil || len(created.Group.Participants) != 2 {
+		t.Fatalf("expected group with two participants, got %+v", created.Group)
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetGroupInviteLink(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/groups/invite-link?organizationId=org_1&groupJid="+created.Group.JID, nil))
+	var invite models.GroupInviteLinkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&invite); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if invite.InviteLink == "" || invite.GroupJID != created.Group.JID {
+		t.Errorf("expected an invite link, got %+v", invite)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ListGroups(rec, httptest.NewRequest(http.MethodGet, "/api/whatsmeow/groups?organizationId=org_1&refresh=true", nil))
+	var list models.GroupListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list.Groups) != 1 || list.Groups[0].InviteLink == nil || *list.Groups[0].InviteLink != invite.InviteLink {
+		t.Errorf("expected the group with its invite link, got %+v", list.Groups)
+	}
+
+	for name, check := range map[string]struct {
+		handler http.HandlerFunc
+		body    string
+		status  int
+	}{
+		"unknown group":  {h.LeaveGroup, `{"organizationId":"org_1","groupJid":"120363000000000000@g.us"}`, http.StatusNotFound},
+		"contact JID":    {h.UpdateGroup, `{"organizationId":"org_1","groupJid":"15550000002@s.whatsapp.net","name":"x"}`, http.StatusBadRequest},
+		"invalid invite": {h.JoinGroup, `{"organizationId":"org_1","inviteCode":"NOPE"}`, http.StatusBadRequest},
+		"missing action": {h.UpdateGroupParticipants, `{"organizationId":"org_1","groupJid":"` + created.Group.JID + `"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		check.handler(rec, httptest.NewRequest(http.MethodPost, "/api/whatsmeow/groups", strings.NewReader(check.body)))
+		if rec.Code != check.status {
+			t.Errorf("%s: expected %d, got %d: %s", name, check.status, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"whatsmeow-service/models"
+)
+
+// ListMessages handles message history requests
+func (h *Handlers) ListMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	query := models.MessageHistoryQuery{
+		OrganizationID: params.Get("organizationId"),
+		AccountID:      params.Get("accountId"),
+		ChatJID:        params.Get("chatJID"),
+		LeadID:         params.Get("leadId"),
+		TemplateID:     params.Get("templateId"),
+		Direction:      params.Get("direction"),
+		MessageType:    params.Get("messageType"),
+		Status:         params.Get("status"),
+		Cursor:         params.Get("cursor"),
+	}
+	if query.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if query.Since, err = timeParam(params, "since"); err != nil {
+		h.sendErrorResponse(w, "Invalid parameter", err, http.StatusBadRequest)
+		return
+	}
+	if query.Until, err = timeParam(params, "until"); err != nil {
+		h.sendErrorResponse(w, "Invalid parameter", err, http.StatusBadRequest)
+		return
+	}
+	if query.Limit, err = limitParam(params); err != nil {
+		h.sendErrorResponse(w, "Invalid parameter", err, http.StatusBadRequest)
+		return
+	}
+
+	messages, nextCursor, err := h.service.ListMessages(query)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to list messages", err, errorStatus(err))
+		return
+	}
+
+	response := models.MessageListResponse{
+		Success:    true,
+		Messages:   messages,
+		NextCursor: nextCursor,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// ListConversations handles requests for an organization's chats
+func (h *Handlers) ListConversations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	organizationID := params.Get("organizationId")
+	if organizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+	limit, err := limitParam(params)
+	if err != nil {
+		h.sendErrorResponse(w, "Invalid parameter", err, http.StatusBadRequest)
+		return
+	}
+
+	conversations, nextCursor, err := h.service.ListConversations(organizationID, params.Get("accountId"), params.Get("cursor"), limit)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to list conversations", err, errorStatus(err))
+		return
+	}
+
+	response := models.ConversationListResponse{
+		Success:       true,
+		Conversations: conversations,
+		NextCursor:    nextCursor,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// timeParam reads an optional RFC 3339 timestamp
+func timeParam(params url.Values, name string) (time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return parsed, nil
+}
+
+// limitParam reads the optional page size
+func limitParam(params url.Values) (int, error) {
+	value := params.Get("limit")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("limit must be a positive number")
+	}
+	return limit, nil
+}
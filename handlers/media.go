@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"whatsmeow-service/models"
+)
+
+// GetMediaLink hands out a signed, time-limited link to a message's media
+func (h *Handlers) GetMediaLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	organizationID := params.Get("organizationId")
+	messageID := params.Get("messageId")
+	if organizationID == "" || messageID == "" {
+		h.sendErrorResponse(w, "Missing required parameters", fmt.Errorf("organizationId and messageId parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	url, expiresAt, err := h.service.MediaLink(organizationID, params.Get("accountId"), messageID)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to create media link", err, errorStatus(err))
+		return
+	}
+
+	response := models.MediaLinkResponse{
+		Success:   true,
+		URL:       url,
+		ExpiresAt: expiresAt,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// DownloadMedia serves the media behind a link from GetMediaLink
+func (h *Handlers) DownloadMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	data, mimeType, err := h.service.OpenMedia(query.Get("accountId"), query.Get("messageId"), query.Get("expires"), query.Get("signature"))
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to download media", err, errorStatus(err))
+		return
+	}
+
+	// The bytes come from whoever messaged us, so never let a browser run them
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
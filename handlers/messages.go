@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"whatsmeow-service/models"
+)
+
+// GetMessage handles looking up a stored message with its reactions
+func (h *Handlers) GetMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	organizationID := params.Get("organizationId")
+	messageID := params.Get("messageId")
+	if organizationID == "" || messageID == "" {
+		h.sendErrorResponse(w, "Missing required parameters", fmt.Errorf("organizationId and messageId parameters are required"), http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.service.GetMessage(organizationID, params.Get("accountId"), messageID)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to get message", err, errorStatus(err))
+		return
+	}
+
+	response := models.MessageResponse{
+		Success: true,
+		Message: message,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// SendReaction handles reacting to a stored message
+func (h *Handlers) SendReaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SendReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.MessageID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and messageId are required"), http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := h.service.SendReaction(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to send reaction", err, errorStatus(err))
+		return
+	}
+
+	response := models.SendMessageResponse{
+		Success:   true,
+		MessageID: messageID,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// EditMessage handles editing the text or caption of a sent message
+func (h *Handlers) EditMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.MessageID == "" || req.MessageText == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId, messageId, and messageText are required"), http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := h.service.EditMessage(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to edit message", err, errorStatus(err))
+		return
+	}
+
+	response := models.SendMessageResponse{
+		Success:   true,
+		MessageID: messageID,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// RevokeMessage handles deleting a sent message for everyone
+func (h *Handlers) RevokeMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.RevokeMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.MessageID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and messageId are required"), http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := h.service.RevokeMessage(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to revoke message", err, errorStatus(err))
+		return
+	}
+
+	response := models.SendMessageResponse{
+		Success:   true,
+		MessageID: messageID,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// MarkRead handles sending read receipts for inbound messages
+func (h *Handlers) MarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || (req.ChatJID == "" && len(req.MessageIDs) == 0) {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and chatJID or messageIds are required"), http.StatusBadRequest)
+		return
+	}
+
+	marked, err := h.service.MarkRead(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to mark messages as read", err, errorStatus(err))
+		return
+	}
+
+	response := models.MarkReadResponse{
+		Success: true,
+		Marked:  marked,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"whatsmeow-service/models"
+)
+
+// SendChatPresence handles showing or clearing a typing indicator in a chat
+func (h *Handlers) SendChatPresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ChatPresenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.ToJID == "" || req.State == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId, toJID and state are required"), http.StatusBadRequest)
+		return
+	}
+
+	accountID, err := h.service.SendChatPresence(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to send chat presence", err, errorStatus(err))
+		return
+	}
+
+	response := models.PresenceResponse{
+		Success:   true,
+		AccountID: accountID,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// SetPresence handles marking an account as online or offline
+func (h *Handlers) SetPresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.PresenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId is required"), http.StatusBadRequest)
+		return
+	}
+
+	accountID, err := h.service.SetPresence(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to send presence", err, errorStatus(err))
+		return
+	}
+
+	response := models.PresenceResponse{
+		Success:   true,
+		AccountID: accountID,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// SubscribePresence handles subscribing to a contact's presence updates
+func (h *Handlers) SubscribePresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SubscribePresenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.JID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and jid are required"), http.StatusBadRequest)
+		return
+	}
+
+	accountID, err := h.service.SubscribePresence(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to subscribe to presence", err, errorStatus(err))
+		return
+	}
+
+	response := models.PresenceResponse{
+		Success:   true,
+		AccountID: accountID,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/services"
+)
+
+const (
+	// streamHeartbeat keeps idle streams from being closed by proxies
+	streamHeartbeat = 25 * time.Second
+	// streamWriteTimeout bounds writing one event to a client
+	streamWriteTimeout = 10 * time.Second
+)
+
+// eventSocketUpgrader accepts WebSocket clients from any origin; stream
+// tokens, not cookies, authenticate them
+var eventSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// GetStreamToken hands out a signed, time-limited token for an
+// organization's event stream. Only the backend may ask for one: it proves
+// itself with the shared stream API key and passes the token on to browsers
+// of users it has signed in.
+func (h *Handlers) GetStreamToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.config.StreamAPIKey == "" {
+		h.sendErrorResponse(w, "Stream tokens are disabled", fmt.Errorf("WHATSMEOW_STREAM_API_KEY is not set"), http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(h.config.StreamAPIKey)) != 1 {
+		h.sendErrorResponse(w, "Unauthorized", fmt.Errorf("a valid X-API-Key header is required"), http.StatusUnauthorized)
+		return
+	}
+
+	organizationID := r.URL.Query().Get("organizationId")
+	if organizationID == "" {
+		h.sendErrorResponse(w, "Missing required parameters", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	token, expiresAt, err := h.service.StreamToken(organizationID)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to create stream token", err, errorStatus(err))
+		return
+	}
+
+	query := url.Values{}
+	query.Set("organizationId", organizationID)
+	query.Set("token", token)
+	response := models.StreamTokenResponse{
+		Success:      true,
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		URL:          services.EventStreamPath + "?" + query.Encode(),
+		WebSocketURL: services.EventSocketPath + "?" + query.Encode(),
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// StreamEvents sends an organization's events as Server-Sent Events
+func (h *Handlers) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscription, err := h.subscribeEvents(r)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to open event stream", err, errorStatus(err))
+		return
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	send := func(write func() error) bool {
+		controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return write() == nil && controller.Flush() == nil
+	}
+
+	for _, event := range subscription.Replay {
+		if !send(func() error { return writeServerSentEvent(w, event) }) {
+			return
+		}
+	}
+	if !send(func() error { _, err := io.WriteString(w, ": connected\n\n"); return err }) {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			// A client that fell behind reconnects and resumes from the
+			// replay buffer
+			if !ok || !send(func() error { return writeServerSentEvent(w, event) }) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(func() error { _, err := io.WriteString(w, ": heartbeat\n\n"); return err }) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// StreamEventsWebSocket sends an organization's events as WebSocket text
+// messages, one event per message
+func (h *Handlers) StreamEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscription, err := h.subscribeEvents(r)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to open event stream", err, errorStatus(err))
+		return
+	}
+	defer subscription.Close()
+
+	conn, err := eventSocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered the request
+		return
+	}
+	defer conn.Close()
+
+	// Clients only send control frames; reading handles them and notices
+	// when the connection goes away
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event models.Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(event) == nil
+	}
+	for _, event := range subscription.Replay {
+		if !send(event) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client fell behind"),
+					time.Now().Add(streamWriteTimeout))
+				return
+			}
+			if !send(event) {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// subscribeEvents authenticates a stream request. Browsers cannot set
+// headers on EventSource, so the token may come in the query string too.
+func (h *Handlers) subscribeEvents(r *http.Request) (*services.StreamSubscription, error) {
+	query := r.URL.Query()
+	organizationID := query.Get("organizationId")
+	if organizationID == "" {
+		return nil, fmt.Errorf("%w: organizationId parameter is required", services.ErrStreamTokenInvalid)
+	}
+
+	token := query.Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+	return h.service.SubscribeEvents(organizationID, token, lastEventID)
+}
+
+// writeServerSentEvent writes an event in the text/event-stream format.
+// Synthetic events such as stream.reset carry no ID, so they leave the
+// client's last event ID alone.
+func writeServerSentEvent(w io.Writer, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"whatsmeow-service/models"
+)
+
+// ListTemplates handles listing the message templates of an organization
+func (h *Handlers) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	organizationID := r.URL.Query().Get("organizationId")
+	if organizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	templates, err := h.service.ListTemplates(organizationID)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to list templates", err, errorStatus(err))
+		return
+	}
+
+	response := models.TemplateListResponse{
+		Success:   true,
+		Templates: templates,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// CreateTemplate handles adding a message template to an organization
+func (h *Handlers) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" {
+		h.sendErrorResponse(w, "Organization ID is required", fmt.Errorf("organizationId is required"), http.StatusBadRequest)
+		return
+	}
+
+	template, err := h.service.CreateTemplate(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to create template", err, errorStatus(err))
+		return
+	}
+
+	response := models.TemplateResponse{
+		Success:  true,
+		Template: template,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusCreated)
+}
+
+// UpdateTemplate handles replacing a message template
+func (h *Handlers) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.TemplateID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and templateId are required"), http.StatusBadRequest)
+		return
+	}
+
+	template, err := h.service.UpdateTemplate(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to update template", err, errorStatus(err))
+		return
+	}
+
+	response := models.TemplateResponse{
+		Success:  true,
+		Template: template,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// DeleteTemplate handles removing a message template
+func (h *Handlers) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		OrganizationID string `json:"organizationId"`
+		TemplateID     string `json:"templateId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.TemplateID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and templateId are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteTemplate(req.OrganizationID, req.TemplateID); err != nil {
+		h.sendErrorResponse(w, "Failed to delete template", err, errorStatus(err))
+		return
+	}
+
+	response := models.TemplateResponse{
+		Success: true,
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
+
+// PreviewTemplate handles rendering a template without sending it
+func (h *Handlers) PreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.TemplatePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendErrorResponse(w, "Invalid JSON", err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OrganizationID == "" || req.TemplateID == "" {
+		h.sendErrorResponse(w, "Missing required fields", fmt.Errorf("organizationId and templateId are required"), http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.service.PreviewTemplate(req)
+	if err != nil {
+		h.sendErrorResponse(w, "Failed to preview template", err, errorStatus(err))
+		return
+	}
+
+	h.sendJSONResponse(w, response, http.StatusOK)
+}
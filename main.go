@@ -8,7 +8,9 @@ import (
 
 	"whatsmeow-service/config"
 	"whatsmeow-service/handlers"
+	"whatsmeow-service/models"
 	"whatsmeow-service/services"
+	"whatsmeow-service/store"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
@@ -17,6 +19,10 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	if err := services.ValidateRoutingPolicy(models.RoutingPolicy(cfg.RoutingPolicy)); err != nil {
+		log.Fatal("Invalid WHATSMEOW_ROUTING_POLICY:", err)
+	}
+
 	// Database connection
 	db, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
@@ -30,20 +36,87 @@ func main() {
 	}
 
 	// Initialize services
-	whatsAppService := services.NewWhatsAppMeowService(cfg, db)
+	postgres := store.NewPostgres(db)
+	postgres.SetLeadLookup(store.LeadLookup{
+		Table:              cfg.LeadTable,
+		IDColumn:           store.DefaultLeadLookup.IDColumn,
+		PhoneColumn:        cfg.LeadPhoneColumn,
+		OrganizationColumn: cfg.LeadOrganizationColumn,
+	})
+	whatsAppService := services.NewWhatsAppMeowService(cfg, postgres, services.NewDeviceStoreClientFactory(cfg.DatabaseURL))
+	if cfg.WebhookURL != "" {
+		whatsAppService.AddNotifier(services.NewWebhookNotifier(cfg.WebhookURL, cfg.WebhookSecret))
+	}
+
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		log.Fatal("Failed to set up media storage:", err)
+	}
+	whatsAppService.UseMediaStore(blobs, []byte(cfg.MediaSecret))
+	whatsAppService.UseEventStream(services.NewEventStream(cfg.StreamReplayEvents), []byte(cfg.StreamSecret))
 
 	// Initialize handlers
 	handlers := handlers.NewHandlers(cfg, whatsAppService)
 
 	// Setup HTTP routes
 	http.HandleFunc("/api/whatsmeow/send", handlers.SendMessage)
+	http.HandleFunc("/api/whatsmeow/react", handlers.SendReaction)
+	http.HandleFunc("/api/whatsmeow/edit", handlers.EditMessage)
+	http.HandleFunc("/api/whatsmeow/revoke", handlers.RevokeMessage)
+	http.HandleFunc("/api/whatsmeow/message", handlers.GetMessage)
+	http.HandleFunc("/api/whatsmeow/messages", handlers.ListMessages)
+	http.HandleFunc("/api/whatsmeow/conversations", handlers.ListConversations)
+	http.HandleFunc("/api/whatsmeow/read", handlers.MarkRead)
+	http.HandleFunc("/api/whatsmeow/presence", handlers.SetPresence)
+	http.HandleFunc("/api/whatsmeow/presence/chat", handlers.SendChatPresence)
+	http.HandleFunc("/api/whatsmeow/presence/subscribe", handlers.SubscribePresence)
+	http.HandleFunc("/api/whatsmeow/events/token", handlers.GetStreamToken)
+	http.HandleFunc(services.EventStreamPath, handlers.StreamEvents)
+	http.HandleFunc(services.EventSocketPath, handlers.StreamEventsWebSocket)
+	http.HandleFunc("/api/whatsmeow/media/link", handlers.GetMediaLink)
+	http.HandleFunc(services.MediaDownloadPath, handlers.DownloadMedia)
 	http.HandleFunc("/api/whatsmeow/status", handlers.GetStatus)
 	http.HandleFunc("/api/whatsmeow/qr", handlers.GetQR)
 	http.HandleFunc("/api/whatsmeow/connect", handlers.Connect)
 	http.HandleFunc("/api/whatsmeow/disconnect", handlers.Disconnect)
+	http.HandleFunc("/api/whatsmeow/logout", handlers.Logout)
+	http.HandleFunc("/api/whatsmeow/accounts", handlers.ListAccounts)
+	http.HandleFunc("/api/whatsmeow/accounts/create", handlers.CreateAccount)
+	http.HandleFunc("/api/whatsmeow/accounts/update", handlers.UpdateAccount)
+	http.HandleFunc("/api/whatsmeow/accounts/delete", handlers.DeleteAccount)
+	http.HandleFunc("/api/whatsmeow/auto-replies", handlers.ListAutoReplyRules)
+	http.HandleFunc("/api/whatsmeow/auto-replies/create", handlers.CreateAutoReplyRule)
+	http.HandleFunc("/api/whatsmeow/auto-replies/update", handlers.UpdateAutoReplyRule)
+	http.HandleFunc("/api/whatsmeow/auto-replies/delete", handlers.DeleteAutoReplyRule)
+	http.HandleFunc("/api/whatsmeow/auto-replies/test", handlers.TestAutoReply)
+	http.HandleFunc("/api/whatsmeow/templates", handlers.ListTemplates)
+	http.HandleFunc("/api/whatsmeow/templates/create", handlers.CreateTemplate)
+	http.HandleFunc("/api/whatsmeow/templates/update", handlers.UpdateTemplate)
+	http.HandleFunc("/api/whatsmeow/templates/delete", handlers.DeleteTemplate)
+	http.HandleFunc("/api/whatsmeow/templates/preview", handlers.PreviewTemplate)
+	http.HandleFunc("/api/whatsmeow/groups", handlers.ListGroups)
+	http.HandleFunc("/api/whatsmeow/groups/create", handlers.CreateGroup)
+	http.HandleFunc("/api/whatsmeow/groups/participants", handlers.UpdateGroupParticipants)
+	http.HandleFunc("/api/whatsmeow/groups/update", handlers.UpdateGroup)
+	http.HandleFunc("/api/whatsmeow/groups/photo", handlers.SetGroupPhoto)
+	http.HandleFunc("/api/whatsmeow/groups/invite-link", handlers.GetGroupInviteLink)
+	http.HandleFunc("/api/whatsmeow/groups/invite-link/revoke", handlers.RevokeGroupInviteLink)
+	http.HandleFunc("/api/whatsmeow/groups/join", handlers.JoinGroup)
+	http.HandleFunc("/api/whatsmeow/groups/leave", handlers.LeaveGroup)
 	http.HandleFunc("/health", handlers.Health)
 
 	log.Printf("WhatsApp Meow service starting on port %d", cfg.Port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), nil))
 }
 
+// newBlobStore opens the storage inbound media is downloaded into
+func newBlobStore(cfg *config.Config) (store.BlobStore, error) {
+	switch cfg.MediaStore {
+	case "s3":
+		return store.NewS3BlobStore(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey)
+	case "filesystem", "":
+		return store.NewFileBlobStore(cfg.MediaDir)
+	default:
+		return nil, fmt.Errorf("unknown media store %q", cfg.MediaStore)
+	}
+}
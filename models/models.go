@@ -8,44 +8,300 @@ import (
 
 // WhatsAppMeowAccount represents a WhatsApp Meow account
 type WhatsAppMeowAccount struct {
-	ID               string                     `json:"id" db:"id"`
-	OrganizationID   string                     `json:"organizationId" db:"organization_id"`
-	DeviceID         string                     `json:"deviceId" db:"device_id"`
-	SessionData      *SessionData               `json:"sessionData,omitempty" db:"session_data"`
-	QRCode           *string                    `json:"qrCode,omitempty" db:"qr_code"`
-	IsConnected      bool                       `json:"isConnected" db:"is_connected"`
-	IsPaired         bool                       `json:"isPaired" db:"is_paired"`
-	PhoneNumber      *string                    `json:"phoneNumber,omitempty" db:"phone_number"`
-	DisplayName      *string                    `json:"displayName,omitempty" db:"display_name"`
-	ProfilePicture   *string                    `json:"profilePicture,omitempty" db:"profile_picture"`
-	LastSeen         *time.Time                 `json:"lastSeen,omitempty" db:"last_seen"`
+	ID               string                       `json:"id" db:"id"`
+	OrganizationID   string                       `json:"organizationId" db:"organization_id"`
+	DeviceID         string                       `json:"deviceId" db:"device_id"`
+	SessionData      *SessionData                 `json:"sessionData,omitempty" db:"session_data"`
+	QRCode           *string                      `json:"qrCode,omitempty" db:"qr_code"`
+	IsConnected      bool                         `json:"isConnected" db:"is_connected"`
+	IsPaired         bool                         `json:"isPaired" db:"is_paired"`
+	PhoneNumber      *string                      `json:"phoneNumber,omitempty" db:"phone_number"`
+	DisplayName      *string                      `json:"displayName,omitempty" db:"display_name"`
+	ProfilePicture   *string                      `json:"profilePicture,omitempty" db:"profile_picture"`
+	LastSeen         *time.Time                   `json:"lastSeen,omitempty" db:"last_seen"`
 	ConnectionStatus WhatsAppMeowConnectionStatus `json:"connectionStatus" db:"connection_status"`
-	CreatedAt        time.Time                  `json:"createdAt" db:"created_at"`
-	UpdatedAt        time.Time                  `json:"updatedAt" db:"updated_at"`
+	ReadReceipts     ReadReceiptPolicy            `json:"readReceipts" db:"read_receipts"`
+	CreatedAt        time.Time                    `json:"createdAt" db:"created_at"`
+	UpdatedAt        time.Time                    `json:"updatedAt" db:"updated_at"`
 }
 
 // WhatsAppMeowMessage represents a WhatsApp Meow message
 type WhatsAppMeowMessage struct {
-	ID                    string                    `json:"id" db:"id"`
-	WhatsAppMeowAccountID   string                    `json:"whatsAppMeowAccountId" db:"whats_app_meow_account_id"`
-	MessageID             string                    `json:"messageId" db:"message_id"`
-	LeadID                *string                   `json:"leadId,omitempty" db:"lead_id"`
-	FromJID               string                    `json:"fromJID" db:"from_jid"`
-	ToJID                 string                    `json:"toJID" db:"to_jid"`
-	MessageType           WhatsAppMeowMessageType   `json:"messageType" db:"message_type"`
-	MessageText           *string                   `json:"messageText,omitempty" db:"message_text"`
-	MediaURL              *string                   `json:"mediaUrl,omitempty" db:"media_url"`
-	MediaType             *string                   `json:"mediaType,omitempty" db:"media_type"`
-	IsSent                bool                      `json:"isSent" db:"is_sent"`
-	IsDelivered           bool                      `json:"isDelivered" db:"is_delivered"`
-	IsRead                bool                      `json:"isRead" db:"is_read"`
-	Timestamp             time.Time                 `json:"timestamp" db:"timestamp"`
-	SentAt                *time.Time                `json:"sentAt,omitempty" db:"sent_at"`
-	DeliveredAt           *time.Time                `json:"deliveredAt,omitempty" db:"delivered_at"`
-	ReadAt                *time.Time                `json:"readAt,omitempty" db:"read_at"`
-	ErrorCode             *string                   `json:"errorCode,omitempty" db:"error_code"`
-	ErrorMessage          *string                   `json:"errorMessage,omitempty" db:"error_message"`
-	RetryCount            int                       `json:"retryCount" db:"retry_count"`
+	ID                    string                  `json:"id" db:"id"`
+	WhatsAppMeowAccountID string                  `json:"whatsAppMeowAccountId" db:"whats_app_meow_account_id"`
+	MessageID             string                  `json:"messageId" db:"message_id"`
+	LeadID                *string                 `json:"leadId,omitempty" db:"lead_id"`
+	FromJID               string                  `json:"fromJID" db:"from_jid"`
+	ToJID                 string                  `json:"toJID" db:"to_jid"`
+	MessageType           WhatsAppMeowMessageType `json:"messageType" db:"message_type"`
+	MessageText           *string                 `json:"messageText,omitempty" db:"message_text"`
+	MediaURL              *string                 `json:"mediaUrl,omitempty" db:"media_url"`
+	MediaType             *string                 `json:"mediaType,omitempty" db:"media_type"`
+	MediaReference        *MediaReference         `json:"-" db:"media_reference"`
+	QuotedMessageID       *string                 `json:"quotedMessageId,omitempty" db:"quoted_message_id"`
+	Latitude              *float64                `json:"latitude,omitempty" db:"latitude"`
+	Longitude             *float64                `json:"longitude,omitempty" db:"longitude"`
+	IsFromMe              bool                    `json:"isFromMe" db:"is_from_me"`
+	IsSent                bool                    `json:"isSent" db:"is_sent"`
+	IsDelivered           bool                    `json:"isDelivered" db:"is_delivered"`
+	IsRead                bool                    `json:"isRead" db:"is_read"`
+	Timestamp             time.Time               `json:"timestamp" db:"timestamp"`
+	SentAt                *time.Time              `json:"sentAt,omitempty" db:"sent_at"`
+	DeliveredAt           *time.Time              `json:"deliveredAt,omitempty" db:"delivered_at"`
+	ReadAt                *time.Time              `json:"readAt,omitempty" db:"read_at"`
+	ErrorCode             *string                 `json:"errorCode,omitempty" db:"error_code"`
+	ErrorMessage          *string                 `json:"errorMessage,omitempty" db:"error_message"`
+	RetryCount            int                     `json:"retryCount" db:"retry_count"`
+	EditedAt              *time.Time              `json:"editedAt,omitempty" db:"edited_at"`
+	EditHistory           []MessageEdit           `json:"editHistory,omitempty" db:"edit_history"`
+	IsRevoked             bool                    `json:"isRevoked" db:"is_revoked"`
+	RevokedAt             *time.Time              `json:"revokedAt,omitempty" db:"revoked_at"`
+	TemplateID            *string                 `json:"templateId,omitempty" db:"template_id"`
+	Reactions             []WhatsAppMeowReaction  `json:"reactions,omitempty" db:"-"`
+}
+
+// Location is the payload of a location message
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+// ContactCard is sent as a vCard in a contact message
+type ContactCard struct {
+	Name         string   `json:"name"`
+	Organization string   `json:"organization,omitempty"`
+	Phones       []string `json:"phones,omitempty"`
+	Emails       []string `json:"emails,omitempty"`
+}
+
+// MessageEdit keeps the text a message had before an edit replaced it
+type MessageEdit struct {
+	PreviousText string    `json:"previousText"`
+	EditedAt     time.Time `json:"editedAt"`
+}
+
+// WhatsAppMeowReaction is the emoji one participant currently has on a message
+type WhatsAppMeowReaction struct {
+	WhatsAppMeowAccountID string    `json:"whatsAppMeowAccountId" db:"whats_app_meow_account_id"`
+	MessageID             string    `json:"messageId" db:"message_id"`
+	SenderJID             string    `json:"senderJID" db:"sender_jid"`
+	Reaction              string    `json:"reaction" db:"reaction"`
+	IsFromMe              bool      `json:"isFromMe" db:"is_from_me"`
+	Timestamp             time.Time `json:"timestamp" db:"timestamp"`
+}
+
+// WhatsAppMeowSuppression is a contact who opted out of an organization's
+// messages
+type WhatsAppMeowSuppression struct {
+	OrganizationID string    `json:"organizationId" db:"organization_id"`
+	JID            string    `json:"jid" db:"jid"`
+	Keyword        string    `json:"keyword,omitempty" db:"keyword"`
+	MessageID      *string   `json:"messageId,omitempty" db:"message_id"`
+	CreatedAt      time.Time `json:"createdAt" db:"created_at"`
+}
+
+// AutoReplyMatchType says which inbound messages an auto-reply rule answers
+type AutoReplyMatchType string
+
+const (
+	AutoReplyMatchExact    AutoReplyMatchType = "EXACT"
+	AutoReplyMatchContains AutoReplyMatchType = "CONTAINS"
+	AutoReplyMatchRegex    AutoReplyMatchType = "REGEX"
+	// AutoReplyMatchFirstMessage answers the first message a contact ever
+	// sends the account
+	AutoReplyMatchFirstMessage AutoReplyMatchType = "FIRST_MESSAGE"
+	AutoReplyMatchAny          AutoReplyMatchType = "ANY"
+)
+
+// AutoReplySchedule says when an auto-reply rule may fire relative to the
+// rule's business hours
+type AutoReplySchedule string
+
+const (
+	AutoReplyAlways               AutoReplySchedule = "ALWAYS"
+	AutoReplyDuringBusinessHours  AutoReplySchedule = "BUSINESS_HOURS"
+	AutoReplyOutsideBusinessHours AutoReplySchedule = "OUTSIDE_BUSINESS_HOURS"
+)
+
+// BusinessHours is a weekly opening schedule in a time zone
+type BusinessHours struct {
+	// TimeZone is an IANA name such as "Europe/Madrid"; empty means UTC
+	TimeZone string                `json:"timeZone,omitempty"`
+	Windows  []BusinessHoursWindow `json:"windows"`
+}
+
+// BusinessHoursWindow opens on the given days, "mon" to "sun", from Start to
+// End as "15:04" local times. End may be "24:00".
+type BusinessHoursWindow struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// Value implements driver.Valuer for database storage
+func (bh *BusinessHours) Value() (driver.Value, error) {
+	if bh == nil {
+		return nil, nil
+	}
+	return json.Marshal(bh)
+}
+
+// AutoReplyResponse is the message an auto-reply rule sends. Texts and
+// captions may contain placeholders such as {{contact.name}}.
+type AutoReplyResponse struct {
+	MessageType string `json:"messageType"`
+	MessageText string `json:"messageText,omitempty"`
+	MediaURL    string `json:"mediaUrl,omitempty"`
+	MediaType   string `json:"mediaType,omitempty"`
+}
+
+// Value implements driver.Valuer for database storage
+func (r AutoReplyResponse) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+// WhatsAppMeowAutoReplyRule answers matching inbound messages of an account.
+// Rules are tried by ascending priority and at most one fires per message.
+type WhatsAppMeowAutoReplyRule struct {
+	ID                    string             `json:"id" db:"id"`
+	WhatsAppMeowAccountID string             `json:"whatsAppMeowAccountId" db:"whats_app_meow_account_id"`
+	Name                  string             `json:"name" db:"name"`
+	Priority              int                `json:"priority" db:"priority"`
+	IsEnabled             bool               `json:"isEnabled" db:"is_enabled"`
+	MatchType             AutoReplyMatchType `json:"matchType" db:"match_type"`
+	// Pattern is the keyword or regular expression of text rules
+	Pattern       string            `json:"pattern,omitempty" db:"pattern"`
+	Schedule      AutoReplySchedule `json:"schedule" db:"schedule"`
+	BusinessHours *BusinessHours    `json:"businessHours,omitempty" db:"business_hours"`
+	// CooldownSeconds is how long the rule stays quiet for a contact after
+	// answering them
+	CooldownSeconds int               `json:"cooldownSeconds" db:"cooldown_seconds"`
+	Response        AutoReplyResponse `json:"response" db:"response"`
+	CreatedAt       time.Time         `json:"createdAt" db:"created_at"`
+	UpdatedAt       time.Time         `json:"updatedAt" db:"updated_at"`
+}
+
+// TemplateVariant is the message a template sends in one language. Texts
+// and captions may contain placeholders such as {{lead.firstName}}, with an
+// optional inline default as in {{lead.firstName|there}}, and spintax such
+// as {Hi|Hello|Hey} picking one option per recipient.
+type TemplateVariant struct {
+	Language    string `json:"language"`
+	MessageType string `json:"messageType"`
+	MessageText string `json:"messageText,omitempty"`
+	MediaURL    string `json:"mediaUrl,omitempty"`
+	MediaType   string `json:"mediaType,omitempty"`
+}
+
+// TemplateVariants is stored as JSON
+type TemplateVariants []TemplateVariant
+
+// Value implements driver.Valuer for database storage
+func (tv TemplateVariants) Value() (driver.Value, error) {
+	return json.Marshal(tv)
+}
+
+// TemplateDefaults is stored as JSON
+type TemplateDefaults map[string]string
+
+// Value implements driver.Valuer for database storage
+func (td TemplateDefaults) Value() (driver.Value, error) {
+	if td == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(td)
+}
+
+// TemplateVariation varies rendered texts beyond their spintax, so bulk
+// sends are not identical
+type TemplateVariation struct {
+	// Emojis are appended to the text, one picked per recipient
+	Emojis []string `json:"emojis,omitempty"`
+	// Whitespace doubles a space picked per recipient, if any
+	Whitespace bool `json:"whitespace,omitempty"`
+}
+
+// Value implements driver.Valuer for database storage
+func (tv TemplateVariation) Value() (driver.Value, error) {
+	return json.Marshal(tv)
+}
+
+// WhatsAppMeowTemplate is a reusable message of an organization
+type WhatsAppMeowTemplate struct {
+	ID             string `json:"id" db:"id"`
+	OrganizationID string `json:"organizationId" db:"organization_id"`
+	Name           string `json:"name" db:"name"`
+	// DefaultLanguage is used when a send asks for a language the template
+	// does not have
+	DefaultLanguage string           `json:"defaultLanguage" db:"default_language"`
+	Variants        TemplateVariants `json:"variants" db:"variants"`
+	// Defaults fill placeholders a send leaves without a value
+	Defaults  TemplateDefaults  `json:"defaults,omitempty" db:"defaults"`
+	Variation TemplateVariation `json:"variation" db:"variation"`
+	CreatedAt time.Time         `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time         `json:"updatedAt" db:"updated_at"`
+}
+
+// GroupParticipant is a member of a WhatsApp group
+type GroupParticipant struct {
+	JID          string `json:"jid"`
+	PhoneNumber  string `json:"phoneNumber,omitempty"`
+	IsAdmin      bool   `json:"isAdmin"`
+	IsSuperAdmin bool   `json:"isSuperAdmin"`
+	// Error is the code WhatsApp refused a participant change with, such
+	// as 403 when the contact's privacy settings only allow invites
+	Error int `json:"error,omitempty"`
+}
+
+// GroupParticipants is stored as JSON
+type GroupParticipants []GroupParticipant
+
+// Value implements driver.Valuer for database storage
+func (gp GroupParticipants) Value() (driver.Value, error) {
+	return json.Marshal(gp)
+}
+
+// WhatsAppMeowGroup is the local copy of a group an account is in
+type WhatsAppMeowGroup struct {
+	ID                    string `json:"id" db:"id"`
+	WhatsAppMeowAccountID string `json:"whatsAppMeowAccountId" db:"whats_app_meow_account_id"`
+	JID                   string `json:"jid" db:"jid"`
+	Name                  string `json:"name" db:"name"`
+	Description           string `json:"description" db:"description"`
+	OwnerJID              string `json:"ownerJid,omitempty" db:"owner_jid"`
+	// IsAnnounce lets only admins send messages and IsLocked lets only
+	// admins change the group's info
+	IsAnnounce   bool              `json:"isAnnounce" db:"is_announce"`
+	IsLocked     bool              `json:"isLocked" db:"is_locked"`
+	Participants GroupParticipants `json:"participants" db:"participants"`
+	// InviteLink and PhotoID are known once fetched or set through the
+	// service
+	InviteLink     *string    `json:"inviteLink,omitempty" db:"invite_link"`
+	PhotoID        *string    `json:"photoId,omitempty" db:"photo_id"`
+	GroupCreatedAt *time.Time `json:"groupCreatedAt,omitempty" db:"group_created_at"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// MediaReference holds what is needed to download a received media file
+// from WhatsApp. It includes the decryption key and is never sent to clients.
+type MediaReference struct {
+	DirectPath    string `json:"directPath"`
+	MediaKey      []byte `json:"mediaKey"`
+	FileEncSHA256 []byte `json:"fileEncSha256"`
+	FileSHA256    []byte `json:"fileSha256"`
+	FileLength    uint64 `json:"fileLength"`
+	MimeType      string `json:"mimeType,omitempty"`
+}
+
+// Value implements driver.Valuer for database storage
+func (mr *MediaReference) Value() (driver.Value, error) {
+	if mr == nil {
+		return nil, nil
+	}
+	return json.Marshal(mr)
 }
 
 // SessionData represents encrypted session data
@@ -68,12 +324,12 @@ func (sd *SessionData) Scan(value interface{}) error {
 	if value == nil {
 		return nil
 	}
-	
+
 	bytes, ok := value.([]byte)
 	if !ok {
 		return nil
 	}
-	
+
 	return json.Unmarshal(bytes, sd)
 }
 
@@ -82,13 +338,35 @@ type WhatsAppMeowConnectionStatus string
 
 const (
 	ConnectionStatusDisconnected WhatsAppMeowConnectionStatus = "DISCONNECTED"
-	ConnectionStatusConnecting    WhatsAppMeowConnectionStatus = "CONNECTING"
-	ConnectionStatusConnected     WhatsAppMeowConnectionStatus = "CONNECTED"
-	ConnectionStatusPairing       WhatsAppMeowConnectionStatus = "PAIRING"
-	ConnectionStatusPaired        WhatsAppMeowConnectionStatus = "PAIRED"
-	ConnectionStatusError         WhatsAppMeowConnectionStatus = "ERROR"
+	ConnectionStatusConnecting   WhatsAppMeowConnectionStatus = "CONNECTING"
+	ConnectionStatusConnected    WhatsAppMeowConnectionStatus = "CONNECTED"
+	ConnectionStatusPairing      WhatsAppMeowConnectionStatus = "PAIRING"
+	ConnectionStatusPaired       WhatsAppMeowConnectionStatus = "PAIRED"
+	ConnectionStatusError        WhatsAppMeowConnectionStatus = "ERROR"
+	ConnectionStatusLoggedOut    WhatsAppMeowConnectionStatus = "LOGGED_OUT"
+	ConnectionStatusBanned       WhatsAppMeowConnectionStatus = "BANNED"
+)
+
+// ReadReceiptPolicy decides when an account marks inbound messages as read
+type ReadReceiptPolicy string
+
+const (
+	ReadReceiptsNever       ReadReceiptPolicy = "NEVER"
+	ReadReceiptsOnFetch     ReadReceiptPolicy = "ON_FETCH"
+	ReadReceiptsImmediately ReadReceiptPolicy = "IMMEDIATE"
 )
 
+// WhatsAppMeowConversation summarizes one chat of an account
+type WhatsAppMeowConversation struct {
+	WhatsAppMeowAccountID string               `json:"whatsAppMeowAccountId"`
+	ChatJID               string               `json:"chatJID"`
+	LeadID                *string              `json:"leadId,omitempty"`
+	LastMessage           *WhatsAppMeowMessage `json:"lastMessage"`
+	// UnreadCount counts inbound messages not marked as read
+	UnreadCount  int       `json:"unreadCount"`
+	LastActivity time.Time `json:"lastActivity"`
+}
+
 // WhatsAppMeowMessageType represents message types
 type WhatsAppMeowMessageType string
 
@@ -104,27 +382,142 @@ const (
 	MessageTypeSystem   WhatsAppMeowMessageType = "SYSTEM"
 )
 
+// WhatsAppMeowMessageStatus represents delivery status transitions
+type WhatsAppMeowMessageStatus string
+
+const (
+	MessageStatusSent      WhatsAppMeowMessageStatus = "SENT"
+	MessageStatusDelivered WhatsAppMeowMessageStatus = "DELIVERED"
+	MessageStatusRead      WhatsAppMeowMessageStatus = "READ"
+	MessageStatusFailed    WhatsAppMeowMessageStatus = "FAILED"
+)
+
+// RoutingPolicy decides which account sends when a request names none
+type RoutingPolicy string
+
+const (
+	RoutingRoundRobin    RoutingPolicy = "round-robin"
+	RoutingStickyPerLead RoutingPolicy = "sticky-per-lead"
+	RoutingLeastLoaded   RoutingPolicy = "least-loaded"
+)
+
 // Request/Response types
+
 type SendMessageRequest struct {
+	OrganizationID string        `json:"organizationId"`
+	AccountID      string        `json:"accountId,omitempty"`
+	RoutingPolicy  RoutingPolicy `json:"routingPolicy,omitempty"`
+	ToJID          string        `json:"toJID"`
+	MessageType    string        `json:"messageType"`
+	MessageText    string        `json:"messageText,omitempty"`
+	MediaURL       string        `json:"mediaUrl,omitempty"`
+	MediaType      string        `json:"mediaType,omitempty"`
+	// PTT sends Ogg/Opus audio as a voice note
+	PTT bool `json:"ptt,omitempty"`
+	// ThumbnailURL is an optional preview image for videos and documents
+	ThumbnailURL    string `json:"thumbnailUrl,omitempty"`
+	LeadID          string `json:"leadId,omitempty"`
+	QuotedMessageID string `json:"quotedMessageId,omitempty"`
+	// TemplateID renders a stored template with Variables in Language
+	// instead of taking the message from the fields above
+	TemplateID string            `json:"templateId,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	Language   string            `json:"language,omitempty"`
+	Location   *Location         `json:"location,omitempty"`
+	Contacts   []ContactCard     `json:"contacts,omitempty"`
+}
+
+// SendReactionRequest reacts to a stored message; an empty reaction removes ours.
+// AccountID may be left out when only one account holds the message ID.
+type SendReactionRequest struct {
 	OrganizationID string `json:"organizationId"`
-	ToJID          string `json:"toJID"`
-	MessageType    string `json:"messageType"`
-	MessageText    string `json:"messageText,omitempty"`
-	MediaURL       string `json:"mediaUrl,omitempty"`
-	MediaType      string `json:"mediaType,omitempty"`
-	LeadID         string `json:"leadId,omitempty"`
+	AccountID      string `json:"accountId,omitempty"`
+	MessageID      string `json:"messageId"`
+	Reaction       string `json:"reaction"`
+}
+
+// EditMessageRequest replaces the text or caption of a message we sent
+type EditMessageRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	MessageID      string `json:"messageId"`
+	MessageText    string `json:"messageText"`
+}
+
+// RevokeMessageRequest deletes a message we sent for everyone
+type RevokeMessageRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	MessageID      string `json:"messageId"`
+}
+
+type MessageResponse struct {
+	Success bool                 `json:"success"`
+	Message *WhatsAppMeowMessage `json:"message,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+type MediaLinkResponse struct {
+	Success   bool      `json:"success"`
+	URL       string    `json:"url,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// StreamTokenResponse hands out a token for an organization's event stream
+// along with the SSE and WebSocket URLs that carry it
+type StreamTokenResponse struct {
+	Success      bool      `json:"success"`
+	Token        string    `json:"token,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+	URL          string    `json:"url,omitempty"`
+	WebSocketURL string    `json:"webSocketUrl,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// MessageHistoryQuery filters a listing of messages; empty fields are ignored
+type MessageHistoryQuery struct {
+	OrganizationID string
+	AccountID      string
+	ChatJID        string
+	LeadID         string
+	TemplateID     string
+	// Direction is inbound or outbound
+	Direction   string
+	MessageType string
+	Status      string
+	Since       time.Time
+	Until       time.Time
+	Cursor      string
+	Limit       int
+}
+
+type MessageListResponse struct {
+	Success    bool                   `json:"success"`
+	Messages   []*WhatsAppMeowMessage `json:"messages"`
+	NextCursor string                 `json:"nextCursor,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+type ConversationListResponse struct {
+	Success       bool                        `json:"success"`
+	Conversations []*WhatsAppMeowConversation `json:"conversations"`
+	NextCursor    string                      `json:"nextCursor,omitempty"`
+	Error         string                      `json:"error,omitempty"`
 }
 
 type SendMessageResponse struct {
 	Success   bool   `json:"success"`
 	MessageID string `json:"messageId,omitempty"`
 	Error     string `json:"error,omitempty"`
+	// Code identifies the kind of error for errors that carry one
+	Code string `json:"code,omitempty"`
 }
 
 type ConnectionStatusResponse struct {
-	Success bool                    `json:"success"`
-	Account *WhatsAppMeowAccount    `json:"account,omitempty"`
-	Error   string                  `json:"error,omitempty"`
+	Success bool                 `json:"success"`
+	Account *WhatsAppMeowAccount `json:"account,omitempty"`
+	Error   string               `json:"error,omitempty"`
 }
 
 type QRCodeResponse struct {
@@ -132,3 +525,330 @@ type QRCodeResponse struct {
 	QRCode  string `json:"qrCode,omitempty"`
 	Error   string `json:"error,omitempty"`
 }
+
+type CreateAccountRequest struct {
+	OrganizationID string            `json:"organizationId"`
+	DeviceID       string            `json:"deviceId,omitempty"`
+	DisplayName    string            `json:"displayName,omitempty"`
+	ReadReceipts   ReadReceiptPolicy `json:"readReceipts,omitempty"`
+}
+
+type UpdateAccountRequest struct {
+	OrganizationID string             `json:"organizationId"`
+	AccountID      string             `json:"accountId"`
+	DeviceID       *string            `json:"deviceId,omitempty"`
+	DisplayName    *string            `json:"displayName,omitempty"`
+	ReadReceipts   *ReadReceiptPolicy `json:"readReceipts,omitempty"`
+}
+
+type AccountResponse struct {
+	Success bool                 `json:"success"`
+	Account *WhatsAppMeowAccount `json:"account,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+type AccountListResponse struct {
+	Success  bool                   `json:"success"`
+	Accounts []*WhatsAppMeowAccount `json:"accounts"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// AutoReplyRuleRequest creates an auto-reply rule, or replaces one when
+// RuleID is set
+type AutoReplyRuleRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	RuleID         string `json:"ruleId,omitempty"`
+	Name           string `json:"name"`
+	Priority       int    `json:"priority"`
+	// IsEnabled defaults to true
+	IsEnabled       *bool              `json:"isEnabled,omitempty"`
+	MatchType       AutoReplyMatchType `json:"matchType"`
+	Pattern         string             `json:"pattern,omitempty"`
+	Schedule        AutoReplySchedule  `json:"schedule,omitempty"`
+	BusinessHours   *BusinessHours     `json:"businessHours,omitempty"`
+	CooldownSeconds int                `json:"cooldownSeconds"`
+	Response        AutoReplyResponse  `json:"response"`
+}
+
+type AutoReplyRuleResponse struct {
+	Success bool                       `json:"success"`
+	Rule    *WhatsAppMeowAutoReplyRule `json:"rule,omitempty"`
+	Error   string                     `json:"error,omitempty"`
+}
+
+type AutoReplyRuleListResponse struct {
+	Success bool                         `json:"success"`
+	Rules   []*WhatsAppMeowAutoReplyRule `json:"rules"`
+	Error   string                       `json:"error,omitempty"`
+}
+
+// AutoReplyTestRequest asks which rule would answer a sample message
+type AutoReplyTestRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	MessageText    string `json:"messageText"`
+	// FromJID lets first-message rules and cooldowns look at the contact's
+	// history; without it the sample counts as a first message
+	FromJID   string     `json:"fromJID,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// AutoReplyEvaluation explains the outcome of one rule for a sample message
+type AutoReplyEvaluation struct {
+	RuleID string `json:"ruleId"`
+	Name   string `json:"name"`
+	Fires  bool   `json:"fires"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type AutoReplyTestResponse struct {
+	Success bool `json:"success"`
+	// Rule and Reply are empty when no rule would fire
+	Rule        *WhatsAppMeowAutoReplyRule `json:"rule,omitempty"`
+	Reply       *AutoReplyResponse         `json:"reply,omitempty"`
+	Evaluations []AutoReplyEvaluation      `json:"evaluations"`
+	Error       string                     `json:"error,omitempty"`
+}
+
+// TemplateRequest creates a template, or replaces one when TemplateID is
+// set
+type TemplateRequest struct {
+	OrganizationID  string            `json:"organizationId"`
+	TemplateID      string            `json:"templateId,omitempty"`
+	Name            string            `json:"name"`
+	DefaultLanguage string            `json:"defaultLanguage,omitempty"`
+	Variants        []TemplateVariant `json:"variants"`
+	Defaults        map[string]string `json:"defaults,omitempty"`
+	Variation       TemplateVariation `json:"variation"`
+}
+
+type TemplateResponse struct {
+	Success  bool                  `json:"success"`
+	Template *WhatsAppMeowTemplate `json:"template,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+type TemplateListResponse struct {
+	Success   bool                    `json:"success"`
+	Templates []*WhatsAppMeowTemplate `json:"templates"`
+	Error     string                  `json:"error,omitempty"`
+}
+
+// TemplatePreviewRequest renders a template without sending it: once for
+// each of ToJIDs exactly as a send to them would, or Count samples
+type TemplatePreviewRequest struct {
+	OrganizationID string            `json:"organizationId"`
+	TemplateID     string            `json:"templateId"`
+	Language       string            `json:"language,omitempty"`
+	Variables      map[string]string `json:"variables,omitempty"`
+	ToJIDs         []string          `json:"toJIDs,omitempty"`
+	Count          int               `json:"count,omitempty"`
+}
+
+type TemplatePreview struct {
+	ToJID       string `json:"toJID,omitempty"`
+	Language    string `json:"language"`
+	MessageType string `json:"messageType"`
+	MessageText string `json:"messageText"`
+	MediaURL    string `json:"mediaUrl,omitempty"`
+}
+
+type TemplatePreviewResponse struct {
+	Success bool `json:"success"`
+	// Combinations counts the distinct texts the variant's spintax can
+	// produce
+	Combinations int               `json:"combinations"`
+	Previews     []TemplatePreview `json:"previews"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// ChatPresenceState is what a chat shows the other side doing
+type ChatPresenceState string
+
+const (
+	ChatPresenceComposing ChatPresenceState = "composing"
+	ChatPresenceRecording ChatPresenceState = "recording"
+	ChatPresencePaused    ChatPresenceState = "paused"
+)
+
+// ChatPresenceRequest shows or clears a typing or recording indicator in a
+// chat. It is sent from the account a message to the chat would use.
+type ChatPresenceRequest struct {
+	OrganizationID string            `json:"organizationId"`
+	AccountID      string            `json:"accountId,omitempty"`
+	ToJID          string            `json:"toJID"`
+	LeadID         string            `json:"leadId,omitempty"`
+	State          ChatPresenceState `json:"state"`
+}
+
+// PresenceRequest sets whether an account shows as online
+type PresenceRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	Available      bool   `json:"available"`
+}
+
+// SubscribePresenceRequest asks to be told when a contact comes online or
+// goes offline
+type SubscribePresenceRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	JID            string `json:"jid"`
+	LeadID         string `json:"leadId,omitempty"`
+}
+
+// MarkReadRequest marks inbound messages as read: the given ones, or every
+// unread message of a chat
+type MarkReadRequest struct {
+	OrganizationID string   `json:"organizationId"`
+	AccountID      string   `json:"accountId,omitempty"`
+	ChatJID        string   `json:"chatJID,omitempty"`
+	MessageIDs     []string `json:"messageIds,omitempty"`
+}
+
+type MarkReadResponse struct {
+	Success bool `json:"success"`
+	// Marked counts the messages that were unread
+	Marked int    `json:"marked"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GroupParticipantAction changes the members of a group
+type GroupParticipantAction string
+
+const (
+	GroupParticipantAdd     GroupParticipantAction = "add"
+	GroupParticipantRemove  GroupParticipantAction = "remove"
+	GroupParticipantPromote GroupParticipantAction = "promote"
+	GroupParticipantDemote  GroupParticipantAction = "demote"
+)
+
+// GroupRequest names a group of an account. AccountID may be left out when
+// the organization has a single account.
+type GroupRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	GroupJID       string `json:"groupJid"`
+}
+
+// CreateGroupRequest creates a group with the given participants, as JIDs
+// or phone numbers
+type CreateGroupRequest struct {
+	OrganizationID string   `json:"organizationId"`
+	AccountID      string   `json:"accountId,omitempty"`
+	Name           string   `json:"name"`
+	Participants   []string `json:"participants"`
+}
+
+// GroupParticipantsRequest adds, removes, promotes or demotes participants,
+// given as JIDs or phone numbers
+type GroupParticipantsRequest struct {
+	GroupRequest
+	Action       GroupParticipantAction `json:"action"`
+	Participants []string               `json:"participants"`
+}
+
+// UpdateGroupRequest changes the fields that are set; an empty description
+// removes it
+type UpdateGroupRequest struct {
+	GroupRequest
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// GroupPhotoRequest sets the group picture from an image URL; an empty URL
+// removes it
+type GroupPhotoRequest struct {
+	GroupRequest
+	PhotoURL string `json:"photoUrl"`
+}
+
+// JoinGroupRequest joins a group with an invite code or
+// https://chat.whatsapp.com/ link
+type JoinGroupRequest struct {
+	OrganizationID string `json:"organizationId"`
+	AccountID      string `json:"accountId,omitempty"`
+	InviteCode     string `json:"inviteCode"`
+}
+
+type GroupResponse struct {
+	Success bool               `json:"success"`
+	Group   *WhatsAppMeowGroup `json:"group,omitempty"`
+	// Failed lists the participants WhatsApp did not accept
+	Failed []GroupParticipant `json:"failed,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+type GroupListResponse struct {
+	Success bool                 `json:"success"`
+	Groups  []*WhatsAppMeowGroup `json:"groups"`
+	Error   string               `json:"error,omitempty"`
+}
+
+type GroupInviteLinkResponse struct {
+	Success    bool   `json:"success"`
+	GroupJID   string `json:"groupJid,omitempty"`
+	InviteLink string `json:"inviteLink,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type PresenceResponse struct {
+	Success   bool   `json:"success"`
+	AccountID string `json:"accountId,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// EventType names a notification published to webhooks and event streams
+type EventType string
+
+const (
+	EventAccountLoggedOut EventType = "account.logged_out"
+	// EventAccountQR carries each QR code an unpaired account rotates
+	// through, and the connection events report status changes
+	EventAccountQR           EventType = "account.qr"
+	EventAccountConnected    EventType = "account.connected"
+	EventAccountDisconnected EventType = "account.disconnected"
+	EventAccountBanned       EventType = "account.banned"
+	// EventMessageReceived carries each message stored from WhatsApp and
+	// EventMessageStatus the delivery and read receipts of messages
+	EventMessageReceived EventType = "message.received"
+	EventMessageStatus   EventType = "message.status"
+	EventMessageReaction EventType = "message.reaction"
+	EventMessageEdited   EventType = "message.edited"
+	EventMessageRevoked  EventType = "message.revoked"
+	// EventContactUnknown reports an inbound message from a number that
+	// matches no lead
+	EventContactUnknown EventType = "contact.unknown"
+	// EventContactOptedOut and EventContactOptedIn report opt-out and opt-in
+	// keywords received from a contact
+	EventContactOptedOut EventType = "contact.opted_out"
+	EventContactOptedIn  EventType = "contact.opted_in"
+	// EventChatPresence reports a contact typing, recording or pausing in
+	// a chat, and EventContactPresence a subscribed contact coming online or
+	// going offline
+	EventChatPresence    EventType = "chat.presence"
+	EventContactPresence EventType = "contact.presence"
+	// EventStreamReset tells a resuming stream client that events after its
+	// last event ID are no longer buffered
+	EventStreamReset EventType = "stream.reset"
+)
+
+// Event is a notification about an account published to webhooks
+type Event struct {
+	ID             string      `json:"id"`
+	Type           EventType   `json:"type"`
+	OrganizationID string      `json:"organizationId"`
+	AccountID      string      `json:"accountId"`
+	Timestamp      time.Time   `json:"timestamp"`
+	Data           interface{} `json:"data,omitempty"`
+}
+
+type LogoutResponse struct {
+	Success bool `json:"success"`
+	// RemoteUnlinked is false when the account was offline and the device
+	// could only be wiped locally; it then still shows up under the phone's
+	// linked devices until removed there.
+	RemoteUnlinked bool   `json:"remoteUnlinked"`
+	Error          string `json:"error,omitempty"`
+}
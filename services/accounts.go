@@ -0,0 +1,112 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"whatsmeow-service/models"
+)
+
+// ErrAccountRequired is returned when an organization owns several accounts
+// and the request does not say which one to use
+var ErrAccountRequired = errors.New("organization has multiple accounts; accountId is required")
+
+// CreateAccount provisions a new, unpaired account for an organization
+func (s *WhatsAppMeowService) CreateAccount(req models.CreateAccountRequest) (*models.WhatsAppMeowAccount, error) {
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		var err error
+		if deviceID, err = generateDeviceID(); err != nil {
+			return nil, fmt.Errorf("failed to generate device ID: %w", err)
+		}
+	}
+
+	account := &models.WhatsAppMeowAccount{
+		OrganizationID:   req.OrganizationID,
+		DeviceID:         deviceID,
+		DisplayName:      optionalString(req.DisplayName),
+		ConnectionStatus: models.ConnectionStatusDisconnected,
+		ReadReceipts:     models.ReadReceiptsNever,
+	}
+	if req.ReadReceipts != "" {
+		policy, err := parseReadReceiptPolicy(req.ReadReceipts)
+		if err != nil {
+			return nil, err
+		}
+		account.ReadReceipts = policy
+	}
+	if err := s.accounts.CreateAccount(account); err != nil {
+		return nil, fmt.Errorf("failed to create account: %w", err)
+	}
+
+	return account, nil
+}
+
+// ListAccounts returns every account of an organization
+func (s *WhatsAppMeowService) ListAccounts(organizationID string) ([]*models.WhatsAppMeowAccount, error) {
+	accounts, err := s.accounts.ListAccounts(organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if accounts == nil {
+		accounts = []*models.WhatsAppMeowAccount{}
+	}
+	return accounts, nil
+}
+
+// UpdateAccount changes the editable settings of an account
+func (s *WhatsAppMeowService) UpdateAccount(req models.UpdateAccountRequest) (*models.WhatsAppMeowAccount, error) {
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.DeviceID != nil && *req.DeviceID != account.DeviceID {
+		// The device ID names the whatsmeow session of a paired phone
+		if account.IsPaired {
+			return nil, fmt.Errorf("cannot change the device ID of a paired account")
+		}
+		account.DeviceID = *req.DeviceID
+	}
+	if req.DisplayName != nil {
+		account.DisplayName = optionalString(*req.DisplayName)
+	}
+	if req.ReadReceipts != nil {
+		policy, err := parseReadReceiptPolicy(*req.ReadReceipts)
+		if err != nil {
+			return nil, err
+		}
+		account.ReadReceipts = policy
+	}
+
+	if err := s.accounts.UpdateAccount(account); err != nil {
+		return nil, fmt.Errorf("failed to update account: %w", err)
+	}
+
+	return account, nil
+}
+
+// DeleteAccount unlinks an account's device like Logout and removes the
+// account with its messages
+func (s *WhatsAppMeowService) DeleteAccount(organizationID, accountID string) error {
+	account, err := s.getAccount(organizationID, accountID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.unlinkDevice(account); err != nil {
+		return err
+	}
+
+	return s.accounts.DeleteAccount(account.ID)
+}
+
+func generateDeviceID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// ErrInvalidRule is returned when an auto-reply rule cannot be saved as given
+var ErrInvalidRule = errors.New("invalid auto-reply rule")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// CreateAutoReplyRule adds an auto-reply rule to an account
+func (s *WhatsAppMeowService) CreateAutoReplyRule(req models.AutoReplyRuleRequest) (*models.WhatsAppMeowAutoReplyRule, error) {
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := autoReplyRuleFromRequest(req)
+	rule.WhatsAppMeowAccountID = account.ID
+	if err := s.validateAutoReplyRule(account.OrganizationID, rule); err != nil {
+		return nil, err
+	}
+	if err := s.rules.CreateAutoReplyRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to create auto-reply rule: %w", err)
+	}
+	return rule, nil
+}
+
+// ListAutoReplyRules returns the rules of an account in the order they are
+// tried
+func (s *WhatsAppMeowService) ListAutoReplyRules(organizationID, accountID string) ([]*models.WhatsAppMeowAutoReplyRule, error) {
+	account, err := s.getAccount(organizationID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := s.rules.ListAutoReplyRules(account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []*models.WhatsAppMeowAutoReplyRule{}
+	}
+	return rules, nil
+}
+
+// UpdateAutoReplyRule replaces the settings of a rule
+func (s *WhatsAppMeowService) UpdateAutoReplyRule(req models.AutoReplyRuleRequest) (*models.WhatsAppMeowAutoReplyRule, error) {
+	existing, err := s.getAutoReplyRule(req.OrganizationID, req.RuleID)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := autoReplyRuleFromRequest(req)
+	rule.ID = existing.ID
+	rule.WhatsAppMeowAccountID = existing.WhatsAppMeowAccountID
+	if err := s.validateAutoReplyRule(req.OrganizationID, rule); err != nil {
+		return nil, err
+	}
+	if err := s.rules.UpdateAutoReplyRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to update auto-reply rule: %w", err)
+	}
+	return rule, nil
+}
+
+// DeleteAutoReplyRule removes a rule
+func (s *WhatsAppMeowService) DeleteAutoReplyRule(organizationID, ruleID string) error {
+	rule, err := s.getAutoReplyRule(organizationID, ruleID)
+	if err != nil {
+		return err
+	}
+	return s.rules.DeleteAutoReplyRule(rule.ID)
+}
+
+// TestAutoReply reports which rule would answer a sample message, and why
+// the rules before it would not, without sending anything
+func (s *WhatsAppMeowService) TestAutoReply(req models.AutoReplyTestRequest) (*models.AutoReplyTestResponse, error) {
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	trigger := autoReplyTrigger{account: account, text: req.MessageText, at: time.Now()}
+	if req.Timestamp != nil {
+		trigger.at = *req.Timestamp
+	}
+	if req.FromJID != "" {
+		jid, err := types.ParseJID(req.FromJID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid fromJID: %v", ErrInvalidMessage, err)
+		}
+		trigger.source = types.MessageSource{Chat: jid, Sender: jid}
+	}
+
+	rule, evaluations, err := s.evaluateAutoReplies(trigger)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.AutoReplyTestResponse{Success: true, Rule: rule, Evaluations: evaluations}
+	if rule != nil {
+		reply := rule.Response
+		reply.MessageText = renderPlaceholders(reply.MessageText, trigger.placeholders())
+		result.Reply = &reply
+	}
+	return result, nil
+}
+
+// getAutoReplyRule loads a rule of one of the organization's accounts
+func (s *WhatsAppMeowService) getAutoReplyRule(organizationID, ruleID string) (*models.WhatsAppMeowAutoReplyRule, error) {
+	rule, err := s.rules.GetAutoReplyRule(ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.getAccount(organizationID, rule.WhatsAppMeowAccountID); err != nil {
+		return nil, fmt.Errorf("auto-reply rule %s: %w", ruleID, store.ErrNotFound)
+	}
+	return rule, nil
+}
+
+func autoReplyRuleFromRequest(req models.AutoReplyRuleRequest) *models.WhatsAppMeowAutoReplyRule {
+	rule := &models.WhatsAppMeowAutoReplyRule{
+		Name:            req.Name,
+		Priority:        req.Priority,
+		IsEnabled:       req.IsEnabled == nil || *req.IsEnabled,
+		MatchType:       models.AutoReplyMatchType(strings.ToUpper(string(req.MatchType))),
+		Pattern:         req.Pattern,
+		Schedule:        models.AutoReplySchedule(strings.ToUpper(string(req.Schedule))),
+		BusinessHours:   req.BusinessHours,
+		CooldownSeconds: req.CooldownSeconds,
+		Response:        req.Response,
+	}
+	if rule.Schedule == "" {
+		rule.Schedule = models.AutoReplyAlways
+	}
+	return rule
+}
+
+func (s *WhatsAppMeowService) validateAutoReplyRule(organizationID string, rule *models.WhatsAppMeowAutoReplyRule) error {
+	switch rule.MatchType {
+	case models.AutoReplyMatchExact, models.AutoReplyMatchContains:
+		if strings.TrimSpace(rule.Pattern) == "" {
+			return fmt.Errorf("%w: pattern is required for %s rules", ErrInvalidRule, rule.MatchType)
+		}
+	case models.AutoReplyMatchRegex:
+		if _, err := regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("%w: pattern must be a regular expression", ErrInvalidRule)
+		}
+	case models.AutoReplyMatchFirstMessage, models.AutoReplyMatchAny:
+	default:
+		return fmt.Errorf("%w: unknown matchType %q", ErrInvalidRule, rule.MatchType)
+	}
+
+	switch rule.Schedule {
+	case models.AutoReplyAlways:
+	case models.AutoReplyDuringBusinessHours, models.AutoReplyOutsideBusinessHours:
+		if rule.BusinessHours == nil || len(rule.BusinessHours.Windows) == 0 {
+			return fmt.Errorf("%w: businessHours are required for %s rules", ErrInvalidRule, rule.Schedule)
+		}
+	default:
+		return fmt.Errorf("%w: unknown schedule %q", ErrInvalidRule, rule.Schedule)
+	}
+	if rule.BusinessHours != nil {
+		if _, err := withinBusinessHours(rule.BusinessHours, time.Now()); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	}
+
+	if rule.CooldownSeconds < 0 {
+		return fmt.Errorf("%w: cooldownSeconds cannot be negative", ErrInvalidRule)
+	}
+
+	response := rule.Response
+	switch response.MessageType {
+	case "text":
+		if strings.TrimSpace(response.MessageText) == "" {
+			return fmt.Errorf("%w: text responses need a messageText", ErrInvalidRule)
+		}
+	case "image", "video", "audio", "document":
+		parsed, err := url.Parse(response.MediaURL)
+		if err != nil || response.MediaURL == "" {
+			return fmt.Errorf("%w: %s responses need a mediaUrl", ErrInvalidRule, response.MessageType)
+		}
+		if err := s.fetcher.checkURL(organizationID, parsed); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported response messageType %q", ErrInvalidRule, response.MessageType)
+	}
+	return nil
+}
+
+// withinBusinessHours reports whether at falls into one of the windows
+func withinBusinessHours(hours *models.BusinessHours, at time.Time) (bool, error) {
+	location := time.UTC
+	if hours.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(hours.TimeZone); err != nil {
+			return false, fmt.Errorf("unknown time zone %q", hours.TimeZone)
+		}
+	}
+	local := at.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	// Every window is checked so that invalid ones are reported
+	open := false
+	for _, window := range hours.Windows {
+		start, err := parseClock(window.Start)
+		if err != nil {
+			return false, err
+		}
+		end, err := parseClock(window.End)
+		if err != nil {
+			return false, err
+		}
+		if end <= start {
+			return false, fmt.Errorf("business hours window %s-%s must end after it starts", window.Start, window.End)
+		}
+		for _, day := range window.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return false, fmt.Errorf("unknown day %q; use mon to sun", day)
+			}
+			if weekday == local.Weekday() && start <= minute && minute < end {
+				open = true
+			}
+		}
+	}
+	return open, nil
+}
+
+// parseClock turns "15:04" into minutes since midnight
+func parseClock(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q; use HH:MM", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// autoReplyTrigger is an inbound message auto-reply rules are evaluated
+// against. Dry runs may leave out the message and its sender.
+type autoReplyTrigger struct {
+	account   *models.WhatsAppMeowAccount
+	source    types.MessageSource
+	messageID string
+	pushName  string
+	text      string
+	at        time.Time
+}
+
+func (t autoReplyTrigger) contactJID() string {
+	if t.source.Sender.IsEmpty() {
+		return ""
+	}
+	return t.source.Sender.ToNonAD().String()
+}
+
+func (t autoReplyTrigger) placeholders() map[string]string {
+	values := map[string]string{
+		"contact.name":  t.pushName,
+		"contact.phone": senderPhone(t.source),
+		"contact.jid":   t.contactJID(),
+		"message.text":  t.text,
+	}
+	if t.account.DisplayName != nil {
+		values["account.name"] = *t.account.DisplayName
+	}
+	return values
+}
+
+// evaluateAutoReplies returns the first of the account's rules that fires
+// for the trigger, if any, with the outcome of every rule tried
+func (s *WhatsAppMeowService) evaluateAutoReplies(trigger autoReplyTrigger) (*models.WhatsAppMeowAutoReplyRule, []models.AutoReplyEvaluation, error) {
+	rules, err := s.rules.ListAutoReplyRules(trigger.account.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	evaluations := []models.AutoReplyEvaluation{}
+	for _, rule := range rules {
+		reason, err := s.autoReplySkipReason(rule, trigger)
+		if err != nil {
+			return nil, nil, err
+		}
+		evaluations = append(evaluations, models.AutoReplyEvaluation{
+			RuleID: rule.ID,
+			Name:   rule.Name,
+			Fires:  reason == "",
+			Reason: reason,
+		})
+		if reason == "" {
+			return rule, evaluations, nil
+		}
+	}
+	return nil, evaluations, nil
+}
+
+// autoReplySkipReason explains why a rule does not fire for a trigger, or
+// returns an empty string when it does
+func (s *WhatsAppMeowService) autoReplySkipReason(rule *models.WhatsAppMeowAutoReplyRule, trigger autoReplyTrigger) (string, error) {
+	if !rule.IsEnabled {
+		return "rule is disabled", nil
+	}
+
+	switch rule.MatchType {
+	case models.AutoReplyMatchExact:
+		if normalizeKeyword(trigger.text) != normalizeKeyword(rule.Pattern) {
+			return "message is not the keyword", nil
+		}
+	case models.AutoReplyMatchContains:
+		text := strings.ToLower(strings.Join(strings.Fields(trigger.text), " "))
+		pattern := strings.ToLower(strings.Join(strings.Fields(rule.Pattern), " "))
+		if !strings.Contains(text, pattern) {
+			return "message does not contain the keyword", nil
+		}
+	case models.AutoReplyMatchRegex:
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return "pattern is not a valid regular expression", nil
+		}
+		if !pattern.MatchString(trigger.text) {
+			return "message does not match the pattern", nil
+		}
+	case models.AutoReplyMatchFirstMessage:
+		first, err := s.isFirstMessage(trigger)
+		if err != nil {
+			return "", err
+		}
+		if !first {
+			return "contact has written before", nil
+		}
+	}
+
+	if rule.Schedule != models.AutoReplyAlways && rule.BusinessHours != nil {
+		open, err := withinBusinessHours(rule.BusinessHours, trigger.at)
+		if err != nil {
+			return "business hours are invalid", nil
+		}
+		if rule.Schedule == models.AutoReplyDuringBusinessHours && !open {
+			return "outside business hours", nil
+		}
+		if rule.Schedule == models.AutoReplyOutsideBusinessHours && open {
+			return "during business hours", nil
+		}
+	}
+
+	if contact := trigger.contactJID(); contact != "" && rule.CooldownSeconds > 0 {
+		last, err := s.rules.LastAutoReply(rule.ID, contact)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return "", err
+		}
+		if until := last.Add(time.Duration(rule.CooldownSeconds) * time.Second); err == nil && trigger.at.Before(until) {
+			return fmt.Sprintf("cooling down for this contact until %s", until.UTC().Format(time.RFC3339)), nil
+		}
+	}
+	return "", nil
+}
+
+// isFirstMessage reports whether the trigger is the first message the
+// contact sent the account
+func (s *WhatsAppMeowService) isFirstMessage(trigger autoReplyTrigger) (bool, error) {
+	contact := trigger.contactJID()
+	if contact == "" {
+		return true, nil
+	}
+
+	fromMe := false
+	messages, err := s.messages.ListMessages(store.MessageFilter{
+		AccountID: trigger.account.ID,
+		FromJID:   contact,
+		FromMe:    &fromMe,
+		Limit:     2,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, message := range messages {
+		if message.MessageID != trigger.messageID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// autoReply answers an inbound direct message with the first rule that
+// fires for it
+func (s *WhatsAppMeowService) autoReply(account *models.WhatsAppMeowAccount, msg *events.Message, message *models.WhatsAppMeowMessage) {
+	trigger := autoReplyTrigger{
+		account:   account,
+		source:    msg.Info.MessageSource,
+		messageID: message.MessageID,
+		pushName:  msg.Info.PushName,
+		at:        msg.Info.Timestamp,
+	}
+	if message.MessageText != nil {
+		trigger.text = *message.MessageText
+	}
+
+	rule, _, err := s.evaluateAutoReplies(trigger)
+	if err != nil {
+		log.Printf("Failed to evaluate auto-replies for message %s: %v", message.MessageID, err)
+		return
+	}
+	if rule == nil {
+		return
+	}
+	if err := s.checkSuppression(account.OrganizationID, msg.Info.Chat); err != nil {
+		return
+	}
+
+	// Concurrent messages from the contact must not both pass the cooldown
+	cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+	claimed, err := s.rules.ClaimAutoReply(rule.ID, trigger.contactJID(), trigger.at, trigger.at.Add(-cooldown))
+	if err != nil {
+		log.Printf("Failed to record auto-reply of rule %s: %v", rule.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	req := models.SendMessageRequest{
+		OrganizationID: account.OrganizationID,
+		AccountID:      account.ID,
+		ToJID:          msg.Info.Chat.ToNonAD().String(),
+		MessageType:    rule.Response.MessageType,
+		MessageText:    renderPlaceholders(rule.Response.MessageText, trigger.placeholders()),
+		MediaURL:       rule.Response.MediaURL,
+		MediaType:      rule.Response.MediaType,
+	}
+	if message.LeadID != nil {
+		req.LeadID = *message.LeadID
+	}
+	// Media responses are fetched and uploaded off the event loop
+	go func() {
+		if _, err := s.SendMessage(req); err != nil {
+			log.Printf("Failed to send auto-reply of rule %s: %v", rule.ID, err)
+		}
+	}()
+}
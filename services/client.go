@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsmeow-service/models"
+)
+
+// WhatsAppClient is the subset of *whatsmeow.Client the service relies on.
+// Keeping it narrow lets tests substitute FakeClient for a real phone.
+type WhatsAppClient interface {
+	Connect() error
+	Disconnect()
+	IsConnected() bool
+	IsLoggedIn() bool
+	OwnJID() types.JID
+	Logout(ctx context.Context) error
+	DeleteDevice(ctx context.Context) error
+
+	SendMessage(ctx context.Context, to types.JID, message *waE2E.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waE2E.Message
+	BuildEdit(chat types.JID, id types.MessageID, newContent *waE2E.Message) *waE2E.Message
+	BuildRevoke(chat, sender types.JID, id types.MessageID) *waE2E.Message
+	Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	Download(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error)
+
+	GetQRChannel(ctx context.Context) (<-chan whatsmeow.QRChannelItem, error)
+	IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error)
+
+	SendPresence(state types.Presence) error
+	SendChatPresence(jid types.JID, state types.ChatPresence, media types.ChatPresenceMedia) error
+	SubscribePresence(jid types.JID) error
+	MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error
+
+	GetJoinedGroups(ctx context.Context) ([]*types.GroupInfo, error)
+	GetGroupInfo(jid types.JID) (*types.GroupInfo, error)
+	CreateGroup(ctx context.Context, req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error)
+	UpdateGroupParticipants(jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error)
+	SetGroupName(jid types.JID, name string) error
+	SetGroupTopic(jid types.JID, previousID, newID, topic string) error
+	SetGroupPhoto(jid types.JID, avatar []byte) (string, error)
+	GetGroupInviteLink(jid types.JID, reset bool) (string, error)
+	JoinGroupWithLink(code string) (types.JID, error)
+	LeaveGroup(jid types.JID) error
+
+	AddEventHandler(handler whatsmeow.EventHandler) uint32
+}
+
+// ClientFactory builds an unconnected client for the given account
+type ClientFactory func(account *models.WhatsAppMeowAccount) (WhatsAppClient, error)
+
+// whatsmeowClient adapts *whatsmeow.Client to WhatsAppClient
+type whatsmeowClient struct {
+	*whatsmeow.Client
+}
+
+func (c *whatsmeowClient) OwnJID() types.JID {
+	return c.Store.GetJID()
+}
+
+// DeleteDevice wipes the local session keys. whatsmeow already does this
+// after a successful Logout, so an unset device ID means nothing is left.
+func (c *whatsmeowClient) DeleteDevice(ctx context.Context) error {
+	if c.Store.ID == nil {
+		return nil
+	}
+	return c.Store.Delete(ctx)
+}
+
+// NewDeviceStoreClientFactory returns a ClientFactory that keeps whatsmeow
+// device sessions in the given PostgreSQL database
+func NewDeviceStoreClientFactory(databaseURL string) ClientFactory {
+	var once sync.Once
+	var container *sqlstore.Container
+	var containerErr error
+
+	return func(account *models.WhatsAppMeowAccount) (WhatsAppClient, error) {
+		// Initialize device store
+		once.Do(func() {
+			container, containerErr = sqlstore.New(context.Background(), "postgres", databaseURL, nil)
+		})
+		if containerErr != nil {
+			return nil, fmt.Errorf("failed to create device store: %w", containerErr)
+		}
+
+		// Get or create device. Paired accounts remember the JID the
+		// phone assigned; unpaired ones fall back to the configured device ID.
+		deviceJID := types.JID{User: account.DeviceID, Server: types.DefaultUserServer}
+		if account.SessionData != nil && account.SessionData.DeviceID != "" {
+			if pairedJID, err := types.ParseJID(account.SessionData.DeviceID); err == nil {
+				deviceJID = pairedJID
+			}
+		}
+		device, err := container.GetDevice(context.Background(), deviceJID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get device: %w", err)
+		}
+		if device == nil {
+			device = container.NewDevice()
+		}
+
+		return &whatsmeowClient{Client: whatsmeow.NewClient(device, nil)}, nil
+	}
+}
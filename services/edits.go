@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+var (
+	// ErrMessageNotFromMe is returned when editing or revoking a message the
+	// account received rather than sent
+	ErrMessageNotFromMe = errors.New("only messages sent by the account can be changed")
+	// ErrMessageNotEditable is returned for message types without text
+	ErrMessageNotEditable = errors.New("only text messages and media captions can be edited")
+	// ErrEditWindowExpired is returned once WhatsApp no longer accepts edits
+	ErrEditWindowExpired = errors.New("message is too old to be edited")
+)
+
+// EditMessage replaces the text or caption of a message we sent
+func (s *WhatsAppMeowService) EditMessage(req models.EditMessageRequest) (string, error) {
+	message, account, err := s.getMessage(req.OrganizationID, req.AccountID, req.MessageID)
+	if err != nil {
+		return "", err
+	}
+	if !message.IsFromMe {
+		return "", ErrMessageNotFromMe
+	}
+	if message.IsRevoked {
+		return "", fmt.Errorf("message %s was revoked", message.MessageID)
+	}
+	if time.Since(message.Timestamp) > whatsmeow.EditWindow {
+		return "", ErrEditWindowExpired
+	}
+
+	content, err := editedContent(message.MessageType, req.MessageText)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	chat, _, err := messageChat(message)
+	if err != nil {
+		return "", err
+	}
+
+	editID, err := s.send(client, chat, client.BuildEdit(chat, message.MessageID, content))
+	if err != nil {
+		return "", fmt.Errorf("failed to send edit: %w", err)
+	}
+
+	s.recordEdit(account, message.MessageID, req.MessageText, time.Now())
+	return editID, nil
+}
+
+// RevokeMessage deletes a message we sent for everyone
+func (s *WhatsAppMeowService) RevokeMessage(req models.RevokeMessageRequest) (string, error) {
+	message, account, err := s.getMessage(req.OrganizationID, req.AccountID, req.MessageID)
+	if err != nil {
+		return "", err
+	}
+	if !message.IsFromMe {
+		return "", ErrMessageNotFromMe
+	}
+
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	chat, sender, err := messageChat(message)
+	if err != nil {
+		return "", err
+	}
+
+	revokeID, err := s.send(client, chat, client.BuildRevoke(chat, sender, message.MessageID))
+	if err != nil {
+		return "", fmt.Errorf("failed to send revoke: %w", err)
+	}
+
+	s.recordRevoke(account, message.MessageID, time.Now())
+	return revokeID, nil
+}
+
+// editedContent builds the replacement content for a message of the given type
+func editedContent(messageType models.WhatsAppMeowMessageType, text string) (*waE2E.Message, error) {
+	switch messageType {
+	case models.MessageTypeText:
+		return &waE2E.Message{Conversation: proto.String(text)}, nil
+	case models.MessageTypeImage:
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: proto.String(text)}}, nil
+	case models.MessageTypeVideo:
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Caption: proto.String(text)}}, nil
+	case models.MessageTypeDocument:
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{Caption: proto.String(text)}}, nil
+	default:
+		return nil, ErrMessageNotEditable
+	}
+}
+
+// protocolMessage returns the edit or revoke carried by a message. whatsmeow
+// unwraps edits before dispatching them, but a wrapped edit is accepted too.
+func protocolMessage(message *waE2E.Message) *waE2E.ProtocolMessage {
+	protocol := message.GetProtocolMessage()
+	if protocol == nil {
+		protocol = message.GetEditedMessage().GetMessage().GetProtocolMessage()
+	}
+
+	switch protocol.GetType() {
+	case waE2E.ProtocolMessage_MESSAGE_EDIT, waE2E.ProtocolMessage_REVOKE:
+		if protocol.GetKey().GetID() != "" {
+			return protocol
+		}
+	}
+	return nil
+}
+
+// handleProtocolMessage applies an incoming edit or revoke to the stored
+// message it targets
+func (s *WhatsAppMeowService) handleProtocolMessage(accountID string, msg *events.Message, protocol *waE2E.ProtocolMessage) {
+	target, err := s.messages.GetMessage(accountID, protocol.GetKey().GetID())
+	if errors.Is(err, store.ErrNotFound) {
+		return
+	} else if err != nil {
+		log.Printf("Failed to load message %s: %v", protocol.GetKey().GetID(), err)
+		return
+	}
+
+	// Only the original sender may change a message
+	sender := msg.Info.Sender.ToNonAD().String()
+	if target.IsFromMe != msg.Info.IsFromMe || (!msg.Info.IsFromMe && target.FromJID != sender) {
+		log.Printf("Ignoring change to message %s from %s", target.MessageID, sender)
+		return
+	}
+
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+
+	timestamp := msg.Info.Timestamp
+	if ms := protocol.GetTimestampMS(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	if protocol.GetType() == waE2E.ProtocolMessage_REVOKE {
+		s.recordRevoke(account, target.MessageID, timestamp)
+		return
+	}
+	_, text, _ := describeMessage(protocol.GetEditedMessage())
+	s.recordEdit(account, target.MessageID, text, timestamp)
+}
+
+func (s *WhatsAppMeowService) recordEdit(account *models.WhatsAppMeowAccount, messageID, text string, at time.Time) {
+	if err := s.messages.EditMessage(account.ID, messageID, text, at); err != nil {
+		log.Printf("Failed to save edit of message %s: %v", messageID, err)
+		return
+	}
+
+	s.publish(account, models.EventMessageEdited, map[string]interface{}{
+		"messageId":   messageID,
+		"messageText": text,
+		"editedAt":    at,
+	})
+}
+
+func (s *WhatsAppMeowService) recordRevoke(account *models.WhatsAppMeowAccount, messageID string, at time.Time) {
+	if err := s.messages.RevokeMessage(account.ID, messageID, at); err != nil {
+		log.Printf("Failed to save revoke of message %s: %v", messageID, err)
+		return
+	}
+
+	s.publish(account, models.EventMessageRevoked, map[string]interface{}{
+		"messageId": messageID,
+		"revokedAt": at,
+	})
+}
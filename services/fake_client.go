@@ -0,0 +1,661 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/models"
+)
+
+// FakeSentMessage is a message recorded by FakeClient.SendMessage
+type FakeSentMessage struct {
+	To      types.JID
+	Message *waE2E.Message
+	ID      types.MessageID
+}
+
+// FakeChatPresence is a chat presence recorded by FakeClient.SendChatPresence
+type FakeChatPresence struct {
+	JID   types.JID
+	State types.ChatPresence
+	Media types.ChatPresenceMedia
+}
+
+// FakeRead is a read receipt recorded by FakeClient.MarkRead
+type FakeRead struct {
+	IDs    []types.MessageID
+	Chat   types.JID
+	Sender types.JID
+}
+
+// FakeClient is a scriptable in-memory WhatsAppClient for tests. It records
+// everything sent through it and lets tests emit synthetic events to the
+// registered handlers. The *Err fields make the matching call fail.
+type FakeClient struct {
+	mu        sync.Mutex
+	ownJID    types.JID
+	connected bool
+	loggedIn  bool
+	deleted   bool
+	handlers  []whatsmeow.EventHandler
+	qrChannel chan whatsmeow.QRChannelItem
+
+	sent          []FakeSentMessage
+	uploads       [][]byte
+	presences     []types.Presence
+	chatPresences []FakeChatPresence
+	subscriptions []types.JID
+	reads         []FakeRead
+	groups        map[types.JID]*types.GroupInfo
+	inviteCodes   map[types.JID]string
+
+	// Downloads maps a media direct path to the bytes Download returns
+	Downloads map[string][]byte
+	// OnWhatsApp lists the phone numbers IsOnWhatsApp reports as registered
+	OnWhatsApp map[string]bool
+	// Invites maps the invite codes JoinGroupWithLink accepts to the groups
+	// they join
+	Invites map[string]*types.GroupInfo
+	// RefusedParticipants maps contacts to the error code group changes
+	// report for them, such as 403 for privacy settings
+	RefusedParticipants map[types.JID]int
+
+	ConnectErr  error
+	LogoutErr   error
+	SendErr     error
+	UploadErr   error
+	DownloadErr error
+	QRErr       error
+}
+
+var _ WhatsAppClient = (*FakeClient)(nil)
+
+// fakeMessageIDs keeps message IDs unique across every fake in a test
+var fakeMessageIDs atomic.Int64
+
+// fakeGroupIDs does the same for the JIDs of created groups
+var fakeGroupIDs atomic.Int64
+
+// NewFakeClient returns a logged-in fake that identifies as ownJID
+func NewFakeClient(ownJID types.JID) *FakeClient {
+	return &FakeClient{
+		ownJID:     ownJID,
+		loggedIn:   true,
+		Downloads:  make(map[string][]byte),
+		OnWhatsApp: make(map[string]bool),
+		Invites:    make(map[string]*types.GroupInfo),
+
+		RefusedParticipants: make(map[types.JID]int),
+		groups:              make(map[types.JID]*types.GroupInfo),
+		inviteCodes:         make(map[types.JID]string),
+	}
+}
+
+// Factory returns a ClientFactory that always hands out this fake. Like a
+// freshly built client, the fake forgets earlier event handlers each time.
+func (f *FakeClient) Factory() ClientFactory {
+	return func(account *models.WhatsAppMeowAccount) (WhatsAppClient, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.handlers = nil
+		return f, nil
+	}
+}
+
+func (f *FakeClient) Connect() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ConnectErr != nil {
+		return f.ConnectErr
+	}
+	f.connected = true
+	return nil
+}
+
+func (f *FakeClient) Disconnect() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.connected = false
+}
+
+func (f *FakeClient) IsConnected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.connected
+}
+
+func (f *FakeClient) IsLoggedIn() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.loggedIn
+}
+
+// SetLoggedIn changes what IsLoggedIn reports
+func (f *FakeClient) SetLoggedIn(loggedIn bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.loggedIn = loggedIn
+}
+
+func (f *FakeClient) OwnJID() types.JID {
+	return f.ownJID
+}
+
+func (f *FakeClient) Logout(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.LogoutErr != nil {
+		return f.LogoutErr
+	}
+	f.connected = false
+	f.loggedIn = false
+	f.deleted = true
+	return nil
+}
+
+func (f *FakeClient) DeleteDevice(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deleted = true
+	return nil
+}
+
+// DeviceDeleted reports whether the device session was wiped
+func (f *FakeClient) DeviceDeleted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.deleted
+}
+
+func (f *FakeClient) SendMessage(ctx context.Context, to types.JID, message *waE2E.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.SendErr != nil {
+		return whatsmeow.SendResponse{}, f.SendErr
+	}
+
+	id := types.MessageID(fmt.Sprintf("FAKE%06d", fakeMessageIDs.Add(1)))
+	if len(extra) > 0 && extra[0].ID != "" {
+		id = extra[0].ID
+	}
+	f.sent = append(f.sent, FakeSentMessage{To: to, Message: message, ID: id})
+
+	return whatsmeow.SendResponse{ID: id, Timestamp: time.Now()}, nil
+}
+
+func (f *FakeClient) BuildReaction(chat, sender types.JID, id types.MessageID, reaction string) *waE2E.Message {
+	return &waE2E.Message{
+		ReactionMessage: &waE2E.ReactionMessage{
+			Key:               f.buildMessageKey(chat, sender, id),
+			Text:              proto.String(reaction),
+			SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+		},
+	}
+}
+
+func (f *FakeClient) BuildEdit(chat types.JID, id types.MessageID, newContent *waE2E.Message) *waE2E.Message {
+	return &waE2E.Message{
+		EditedMessage: &waE2E.FutureProofMessage{
+			Message: &waE2E.Message{
+				ProtocolMessage: &waE2E.ProtocolMessage{
+					Key:           f.buildMessageKey(chat, types.EmptyJID, id),
+					Type:          waE2E.ProtocolMessage_MESSAGE_EDIT.Enum(),
+					EditedMessage: newContent,
+					TimestampMS:   proto.Int64(time.Now().UnixMilli()),
+				},
+			},
+		},
+	}
+}
+
+func (f *FakeClient) BuildRevoke(chat, sender types.JID, id types.MessageID) *waE2E.Message {
+	return &waE2E.Message{
+		ProtocolMessage: &waE2E.ProtocolMessage{
+			Type: waE2E.ProtocolMessage_REVOKE.Enum(),
+			Key:  f.buildMessageKey(chat, sender, id),
+		},
+	}
+}
+
+// buildMessageKey mirrors whatsmeow's Client.BuildMessageKey
+func (f *FakeClient) buildMessageKey(chat, sender types.JID, id types.MessageID) *waCommon.MessageKey {
+	key := &waCommon.MessageKey{
+		FromMe:    proto.Bool(true),
+		ID:        proto.String(id),
+		RemoteJID: proto.String(chat.String()),
+	}
+	if !sender.IsEmpty() && sender.User != f.ownJID.User {
+		key.FromMe = proto.Bool(false)
+		if chat.Server != types.DefaultUserServer {
+			key.Participant = proto.String(sender.ToNonAD().String())
+		}
+	}
+	return key
+}
+
+func (f *FakeClient) Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.UploadErr != nil {
+		return whatsmeow.UploadResponse{}, f.UploadErr
+	}
+
+	f.uploads = append(f.uploads, plaintext)
+	hash := sha256.Sum256(plaintext)
+	directPath := fmt.Sprintf("/fake/%x", hash[:8])
+	f.Downloads[directPath] = plaintext
+
+	return whatsmeow.UploadResponse{
+		URL:           "https://mmg.whatsapp.net" + directPath,
+		DirectPath:    directPath,
+		MediaKey:      hash[:],
+		FileEncSHA256: hash[:],
+		FileSHA256:    hash[:],
+		FileLength:    uint64(len(plaintext)),
+	}, nil
+}
+
+func (f *FakeClient) Download(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.DownloadErr != nil {
+		return nil, f.DownloadErr
+	}
+
+	data, ok := f.Downloads[msg.GetDirectPath()]
+	if !ok {
+		return nil, whatsmeow.ErrMediaDownloadFailedWith404
+	}
+	return data, nil
+}
+
+func (f *FakeClient) GetQRChannel(ctx context.Context) (<-chan whatsmeow.QRChannelItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.QRErr != nil {
+		return nil, f.QRErr
+	}
+	if f.qrChannel == nil {
+		f.qrChannel = make(chan whatsmeow.QRChannelItem, 8)
+	}
+	return f.qrChannel, nil
+}
+
+// EmitQRItem pushes an item to the channel returned by GetQRChannel
+func (f *FakeClient) EmitQRItem(item whatsmeow.QRChannelItem) {
+	f.mu.Lock()
+	if f.qrChannel == nil {
+		f.qrChannel = make(chan whatsmeow.QRChannelItem, 8)
+	}
+	qrChannel := f.qrChannel
+	f.mu.Unlock()
+
+	qrChannel <- item
+}
+
+func (f *FakeClient) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	responses := make([]types.IsOnWhatsAppResponse, 0, len(phones))
+	for _, phone := range phones {
+		responses = append(responses, types.IsOnWhatsAppResponse{
+			Query: phone,
+			JID:   types.NewJID(phone, types.DefaultUserServer),
+			IsIn:  f.OnWhatsApp[phone],
+		})
+	}
+	return responses, nil
+}
+
+func (f *FakeClient) SendPresence(state types.Presence) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.presences = append(f.presences, state)
+	return nil
+}
+
+func (f *FakeClient) SendChatPresence(jid types.JID, state types.ChatPresence, media types.ChatPresenceMedia) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.chatPresences = append(f.chatPresences, FakeChatPresence{JID: jid, State: state, Media: media})
+	return nil
+}
+
+func (f *FakeClient) SubscribePresence(jid types.JID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subscriptions = append(f.subscriptions, jid)
+	return nil
+}
+
+func (f *FakeClient) MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reads = append(f.reads, FakeRead{IDs: append([]types.MessageID(nil), ids...), Chat: chat, Sender: sender})
+	return nil
+}
+
+func (f *FakeClient) GetJoinedGroups(ctx context.Context) ([]*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	groups := make([]*types.GroupInfo, 0, len(f.groups))
+	for _, group := range f.groups {
+		groups = append(groups, copyGroupInfo(group))
+	}
+	return groups, nil
+}
+
+func (f *FakeClient) GetGroupInfo(jid types.JID) (*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.groups[jid]
+	if !ok {
+		return nil, whatsmeow.ErrNotInGroup
+	}
+	return copyGroupInfo(group), nil
+}
+
+// CreateGroup makes the fake's account the owner of a new group. Refused
+// participants are reported but not added.
+func (f *FakeClient) CreateGroup(ctx context.Context, req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group := &types.GroupInfo{
+		JID:          types.NewJID(fmt.Sprintf("120363%012d", fakeGroupIDs.Add(1)), types.GroupServer),
+		OwnerJID:     f.ownJID.ToNonAD(),
+		GroupName:    types.GroupName{Name: req.Name, NameSetAt: time.Now()},
+		GroupCreated: time.Now(),
+		Participants: []types.GroupParticipant{{JID: f.ownJID.ToNonAD(), IsAdmin: true, IsSuperAdmin: true}},
+	}
+	created := copyGroupInfo(group)
+	for _, jid := range req.Participants {
+		participant := types.GroupParticipant{JID: jid, Error: f.RefusedParticipants[jid]}
+		if participant.Error == 0 {
+			group.Participants = append(group.Participants, participant)
+		}
+		created.Participants = append(created.Participants, participant)
+	}
+	f.groups[group.JID] = group
+	return created, nil
+}
+
+func (f *FakeClient) UpdateGroupParticipants(jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.groups[jid]
+	if !ok {
+		return nil, whatsmeow.ErrNotInGroup
+	}
+
+	var changed []types.GroupParticipant
+	for _, participantJID := range participantChanges {
+		index := slices.IndexFunc(group.Participants, func(p types.GroupParticipant) bool { return p.JID == participantJID })
+		participant := types.GroupParticipant{JID: participantJID}
+		switch {
+		case action == whatsmeow.ParticipantChangeAdd && f.RefusedParticipants[participantJID] != 0:
+			participant.Error = f.RefusedParticipants[participantJID]
+		case action == whatsmeow.ParticipantChangeAdd:
+			if index < 0 {
+				group.Participants = append(group.Participants, participant)
+			}
+		case index < 0:
+			participant.Error = 404
+		case action == whatsmeow.ParticipantChangeRemove:
+			group.Participants = slices.Delete(group.Participants, index, index+1)
+		default:
+			group.Participants[index].IsAdmin = action == whatsmeow.ParticipantChangePromote
+			participant = group.Participants[index]
+		}
+		changed = append(changed, participant)
+	}
+	return changed, nil
+}
+
+func (f *FakeClient) SetGroupName(jid types.JID, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.groups[jid]
+	if !ok {
+		return whatsmeow.ErrNotInGroup
+	}
+	group.Name = name
+	return nil
+}
+
+func (f *FakeClient) SetGroupTopic(jid types.JID, previousID, newID, topic string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.groups[jid]
+	if !ok {
+		return whatsmeow.ErrNotInGroup
+	}
+	group.Topic = topic
+	group.TopicDeleted = topic == ""
+	return nil
+}
+
+// SetGroupPhoto accepts JPEG photos only, like WhatsApp
+func (f *FakeClient) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.groups[jid]; !ok {
+		return "", whatsmeow.ErrNotInGroup
+	}
+	if avatar == nil {
+		return "remove", nil
+	}
+	if !bytes.HasPrefix(avatar, []byte{0xFF, 0xD8}) {
+		return "", whatsmeow.ErrInvalidImageFormat
+	}
+	return fmt.Sprintf("%d", fakeGroupIDs.Add(1)), nil
+}
+
+func (f *FakeClient) GetGroupInviteLink(jid types.JID, reset bool) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.groups[jid]
+	if !ok {
+		return "", whatsmeow.ErrNotInGroup
+	}
+	if code, ok := f.inviteCodes[jid]; ok && !reset {
+		return whatsmeow.InviteLinkPrefix + code, nil
+	}
+	delete(f.Invites, f.inviteCodes[jid])
+	code := fmt.Sprintf("INVITE%06d", fakeGroupIDs.Add(1))
+	f.inviteCodes[jid] = code
+	f.Invites[code] = group
+	return whatsmeow.InviteLinkPrefix + code, nil
+}
+
+func (f *FakeClient) JoinGroupWithLink(code string) (types.JID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.Invites[strings.TrimPrefix(code, whatsmeow.InviteLinkPrefix)]
+	if !ok {
+		return types.EmptyJID, whatsmeow.ErrInviteLinkInvalid
+	}
+	joined := copyGroupInfo(group)
+	joined.Participants = append(joined.Participants, types.GroupParticipant{JID: f.ownJID.ToNonAD()})
+	f.groups[joined.JID] = joined
+	return joined.JID, nil
+}
+
+func (f *FakeClient) LeaveGroup(jid types.JID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.groups[jid]; !ok {
+		return whatsmeow.ErrNotInGroup
+	}
+	delete(f.groups, jid)
+	return nil
+}
+
+// copyGroupInfo keeps callers from sharing the fake's participant lists
+func copyGroupInfo(group *types.GroupInfo) *types.GroupInfo {
+	copied := *group
+	copied.Participants = append([]types.GroupParticipant(nil), group.Participants...)
+	return &copied
+}
+
+func (f *FakeClient) AddEventHandler(handler whatsmeow.EventHandler) uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handlers = append(f.handlers, handler)
+	return uint32(len(f.handlers))
+}
+
+// Emit synchronously delivers evt to every registered event handler
+func (f *FakeClient) Emit(evt interface{}) {
+	f.mu.Lock()
+	handlers := append([]whatsmeow.EventHandler(nil), f.handlers...)
+	f.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(evt)
+	}
+}
+
+// EmitMessage delivers an incoming message from sender in a direct chat
+func (f *FakeClient) EmitMessage(sender types.JID, id types.MessageID, message *waE2E.Message) *events.Message {
+	evt := &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:   sender,
+				Sender: sender,
+			},
+			ID:        id,
+			Timestamp: time.Now(),
+		},
+		Message: message,
+	}
+	f.Emit(evt)
+	return evt
+}
+
+// EmitReceipt delivers a receipt from sender for our outgoing messages
+func (f *FakeClient) EmitReceipt(sender types.JID, receiptType types.ReceiptType, ids ...types.MessageID) {
+	f.Emit(&events.Receipt{
+		MessageSource: types.MessageSource{
+			Chat:   sender,
+			Sender: sender,
+		},
+		MessageIDs: ids,
+		Timestamp:  time.Now(),
+		Type:       receiptType,
+	})
+}
+
+// EmitQR delivers a pairing QR event with the given codes
+func (f *FakeClient) EmitQR(codes ...string) {
+	f.Emit(&events.QR{Codes: codes})
+}
+
+// EmitLoggedOut simulates the device being unlinked from the phone
+func (f *FakeClient) EmitLoggedOut() {
+	f.mu.Lock()
+	f.connected = false
+	f.loggedIn = false
+	f.mu.Unlock()
+
+	f.Emit(&events.LoggedOut{Reason: events.ConnectFailureLoggedOut})
+}
+
+// EmitDisconnected simulates the websocket dropping
+func (f *FakeClient) EmitDisconnected() {
+	f.mu.Lock()
+	f.connected = false
+	f.mu.Unlock()
+
+	f.Emit(&events.Disconnected{})
+}
+
+// SentMessages returns every message sent so far
+func (f *FakeClient) SentMessages() []FakeSentMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeSentMessage(nil), f.sent...)
+}
+
+// Uploads returns the plaintext of every upload so far
+func (f *FakeClient) Uploads() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]byte(nil), f.uploads...)
+}
+
+// Presences returns every global presence sent so far
+func (f *FakeClient) Presences() []types.Presence {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]types.Presence(nil), f.presences...)
+}
+
+// ChatPresences returns every chat presence sent so far
+func (f *FakeClient) ChatPresences() []FakeChatPresence {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeChatPresence(nil), f.chatPresences...)
+}
+
+// Reads returns every read receipt sent so far
+func (f *FakeClient) Reads() []FakeRead {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeRead(nil), f.reads...)
+}
+
+// Subscriptions returns every JID whose presence was subscribed to
+func (f *FakeClient) Subscriptions() []types.JID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]types.JID(nil), f.subscriptions...)
+}
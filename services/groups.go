@@ -0,0 +1,525 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/image/draw"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+const (
+	// maxGroupNameLength is the longest group name WhatsApp accepts
+	maxGroupNameLength = 25
+	// groupPhotoSize is the edge of the square pictures WhatsApp shows
+	groupPhotoSize    = 640
+	groupPhotoQuality = 85
+)
+
+var (
+	// ErrInvalidGroup is returned when a group request cannot be sent as
+	// given
+	ErrInvalidGroup = errors.New("invalid group request")
+	// ErrGroupForbidden is returned when the account may not make a change,
+	// usually because it is not an admin of the group
+	ErrGroupForbidden = errors.New("not allowed in this group")
+)
+
+// ListGroups returns the stored groups of an account. With refresh, they
+// are read from WhatsApp first and groups the account left are dropped.
+func (s *WhatsAppMeowService) ListGroups(organizationID, accountID string, refresh bool) ([]*models.WhatsAppMeowGroup, error) {
+	account, err := s.getAccount(organizationID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		client, err := s.connectedClient(account)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.syncGroups(account, client); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := s.groups.ListGroups(account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if groups == nil {
+		groups = []*models.WhatsAppMeowGroup{}
+	}
+	return groups, nil
+}
+
+// CreateGroup creates a group owned by the account. It also returns the
+// participants WhatsApp did not add, such as contacts whose privacy
+// settings only allow invites.
+func (s *WhatsAppMeowService) CreateGroup(req models.CreateGroupRequest) (*models.WhatsAppMeowGroup, []models.GroupParticipant, error) {
+	name := strings.TrimSpace(req.Name)
+	if err := checkGroupName(name); err != nil {
+		return nil, nil, err
+	}
+	participants, err := parseParticipants(req.Participants)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := client.CreateGroup(context.Background(), whatsmeow.ReqCreateGroup{Name: name, Participants: participants})
+	if err != nil {
+		return nil, nil, groupError("create group", err)
+	}
+	group, failed := groupFromInfo(account.ID, info)
+	if err := s.saveGroup(group); err != nil {
+		return nil, nil, err
+	}
+	return group, failed, nil
+}
+
+// UpdateGroupParticipants adds, removes, promotes or demotes participants.
+// It also returns the participants WhatsApp refused to change.
+func (s *WhatsAppMeowService) UpdateGroupParticipants(req models.GroupParticipantsRequest) (*models.WhatsAppMeowGroup, []models.GroupParticipant, error) {
+	var action whatsmeow.ParticipantChange
+	switch req.Action {
+	case models.GroupParticipantAdd:
+		action = whatsmeow.ParticipantChangeAdd
+	case models.GroupParticipantRemove:
+		action = whatsmeow.ParticipantChangeRemove
+	case models.GroupParticipantPromote:
+		action = whatsmeow.ParticipantChangePromote
+	case models.GroupParticipantDemote:
+		action = whatsmeow.ParticipantChangeDemote
+	default:
+		return nil, nil, fmt.Errorf("%w: action must be add, remove, promote or demote", ErrInvalidGroup)
+	}
+	participants, err := parseParticipants(req.Participants)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(participants) == 0 {
+		return nil, nil, fmt.Errorf("%w: participants are required", ErrInvalidGroup)
+	}
+
+	account, client, jid, err := s.groupClient(req.GroupRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed, err := client.UpdateGroupParticipants(jid, participants, action)
+	if err != nil {
+		return nil, nil, groupError("update participants", err)
+	}
+
+	var failed []models.GroupParticipant
+	for _, participant := range changed {
+		if participant.Error != 0 {
+			failed = append(failed, groupParticipant(participant))
+		}
+	}
+	group, err := s.refreshGroup(account, client, jid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, failed, nil
+}
+
+// UpdateGroup changes the name and description of a group
+func (s *WhatsAppMeowService) UpdateGroup(req models.UpdateGroupRequest) (*models.WhatsAppMeowGroup, error) {
+	if req.Name == nil && req.Description == nil {
+		return nil, fmt.Errorf("%w: name or description is required", ErrInvalidGroup)
+	}
+	var name string
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if err := checkGroupName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	account, client, jid, err := s.groupClient(req.GroupRequest)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		if err := client.SetGroupName(jid, name); err != nil {
+			return nil, groupError("set group name", err)
+		}
+	}
+	if req.Description != nil {
+		if err := client.SetGroupTopic(jid, "", "", strings.TrimSpace(*req.Description)); err != nil {
+			return nil, groupError("set group description", err)
+		}
+	}
+	return s.refreshGroup(account, client, jid)
+}
+
+// SetGroupPhoto sets the picture of a group from an image URL, cropped to
+// a square JPEG. An empty URL removes the picture.
+func (s *WhatsAppMeowService) SetGroupPhoto(req models.GroupPhotoRequest) (*models.WhatsAppMeowGroup, error) {
+	var photo []byte
+	if req.PhotoURL != "" {
+		media, err := s.fetcher.fetch(req.OrganizationID, req.PhotoURL, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch group photo: %w", err)
+		}
+		if photo, err = makeGroupPhoto(media.data); err != nil {
+			return nil, fmt.Errorf("%w: photoUrl must point to a PNG, JPEG, GIF or WebP image", ErrInvalidGroup)
+		}
+	}
+
+	account, client, jid, err := s.groupClient(req.GroupRequest)
+	if err != nil {
+		return nil, err
+	}
+	photoID, err := client.SetGroupPhoto(jid, photo)
+	if err != nil {
+		return nil, groupError("set group photo", err)
+	}
+
+	group, err := s.refreshGroup(account, client, jid)
+	if err != nil {
+		return nil, err
+	}
+	group.PhotoID = nil
+	if photo != nil {
+		group.PhotoID = &photoID
+	}
+	if err := s.saveGroup(group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// GroupInviteLink returns the invite link of a group. With reset, the
+// current link is revoked and a new one made.
+func (s *WhatsAppMeowService) GroupInviteLink(req models.GroupRequest, reset bool) (string, error) {
+	account, client, jid, err := s.groupClient(req)
+	if err != nil {
+		return "", err
+	}
+	link, err := client.GetGroupInviteLink(jid, reset)
+	if err != nil {
+		return "", groupError("get invite link", err)
+	}
+
+	group, err := s.groups.GetGroup(account.ID, jid.String())
+	if errors.Is(err, store.ErrNotFound) {
+		group, err = s.refreshGroup(account, client, jid)
+	}
+	if err != nil {
+		return "", err
+	}
+	group.InviteLink = &link
+	if err := s.saveGroup(group); err != nil {
+		return "", err
+	}
+	return link, nil
+}
+
+// JoinGroup joins a group with an invite code or link. Groups that need an
+// admin's approval come back with only their JID until they approve.
+func (s *WhatsAppMeowService) JoinGroup(req models.JoinGroupRequest) (*models.WhatsAppMeowGroup, error) {
+	code := strings.TrimSpace(req.InviteCode)
+	if code == "" {
+		return nil, fmt.Errorf("%w: inviteCode is required", ErrInvalidGroup)
+	}
+
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := client.JoinGroupWithLink(code)
+	if err != nil {
+		return nil, groupError("join group", err)
+	}
+	group, err := s.refreshGroup(account, client, jid)
+	if errors.Is(err, store.ErrNotFound) {
+		return &models.WhatsAppMeowGroup{WhatsAppMeowAccountID: account.ID, JID: jid.String()}, nil
+	}
+	return group, err
+}
+
+// LeaveGroup leaves a group and forgets it
+func (s *WhatsAppMeowService) LeaveGroup(req models.GroupRequest) error {
+	account, client, jid, err := s.groupClient(req)
+	if err != nil {
+		return err
+	}
+	if err := client.LeaveGroup(jid); err != nil {
+		return groupError("leave group", err)
+	}
+	if err := s.groups.DeleteGroup(account.ID, jid.String()); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// groupClient resolves the account, client and JID a group request is for
+func (s *WhatsAppMeowService) groupClient(req models.GroupRequest) (*models.WhatsAppMeowAccount, WhatsAppClient, types.JID, error) {
+	jid, err := types.ParseJID(req.GroupJID)
+	if err != nil || jid.Server != types.GroupServer {
+		return nil, nil, types.EmptyJID, fmt.Errorf("%w: groupJid must be a group JID such as 120363000000000000@g.us", ErrInvalidGroup)
+	}
+
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return nil, nil, types.EmptyJID, err
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return nil, nil, types.EmptyJID, err
+	}
+	return account, client, jid, nil
+}
+
+// syncGroups replaces the stored groups of an account with the ones it is
+// in on WhatsApp
+func (s *WhatsAppMeowService) syncGroups(account *models.WhatsAppMeowAccount, client WhatsAppClient) error {
+	infos, err := client.GetJoinedGroups(context.Background())
+	if err != nil {
+		return groupError("list groups", err)
+	}
+
+	joined := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		group, _ := groupFromInfo(account.ID, info)
+		if err := s.saveGroup(group); err != nil {
+			return err
+		}
+		joined[group.JID] = true
+	}
+
+	stored, err := s.groups.ListGroups(account.ID)
+	if err != nil {
+		return err
+	}
+	for _, group := range stored {
+		if !joined[group.JID] {
+			if err := s.groups.DeleteGroup(account.ID, group.JID); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshGroup reads a group from WhatsApp and stores it
+func (s *WhatsAppMeowService) refreshGroup(account *models.WhatsAppMeowAccount, client WhatsAppClient, jid types.JID) (*models.WhatsAppMeowGroup, error) {
+	info, err := client.GetGroupInfo(jid)
+	if err != nil {
+		return nil, groupError("get group info", err)
+	}
+	group, _ := groupFromInfo(account.ID, info)
+	if err := s.saveGroup(group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// saveGroup stores a group, keeping the invite link and photo ID WhatsApp
+// does not include in group info
+func (s *WhatsAppMeowService) saveGroup(group *models.WhatsAppMeowGroup) error {
+	if group.InviteLink == nil || group.PhotoID == nil {
+		if stored, err := s.groups.GetGroup(group.WhatsAppMeowAccountID, group.JID); err == nil {
+			if group.InviteLink == nil {
+				group.InviteLink = stored.InviteLink
+			}
+			if group.PhotoID == nil {
+				group.PhotoID = stored.PhotoID
+			}
+		}
+	}
+	if err := s.groups.SaveGroup(group); err != nil {
+		return fmt.Errorf("failed to save group: %w", err)
+	}
+	return nil
+}
+
+// handleJoinedGroup stores groups the account is added to or creates
+func (s *WhatsAppMeowService) handleJoinedGroup(accountID string, joined *events.JoinedGroup) {
+	group, _ := groupFromInfo(accountID, &joined.GroupInfo)
+	if err := s.saveGroup(group); err != nil {
+		log.Printf("Failed to save group %s: %v", group.JID, err)
+	}
+}
+
+// handleGroupInfo re-reads stored groups when someone changes them, and
+// forgets them once the account is no longer in them
+func (s *WhatsAppMeowService) handleGroupInfo(accountID string, change *events.GroupInfo) {
+	if _, err := s.groups.GetGroup(accountID, change.JID.String()); err != nil {
+		return
+	}
+	client := s.clientFor(accountID)
+	if client == nil {
+		return
+	}
+
+	// Event handlers must not block on requests to WhatsApp
+	go func() {
+		info, err := client.GetGroupInfo(change.JID)
+		switch {
+		case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrGroupNotFound):
+			if err := s.groups.DeleteGroup(accountID, change.JID.String()); err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Failed to delete group %s: %v", change.JID, err)
+			}
+		case err != nil:
+			log.Printf("Failed to refresh group %s: %v", change.JID, err)
+		default:
+			group, _ := groupFromInfo(accountID, info)
+			if err := s.saveGroup(group); err != nil {
+				log.Printf("Failed to save group %s: %v", change.JID, err)
+			}
+		}
+	}()
+}
+
+// groupFromInfo converts WhatsApp's view of a group. Participants carrying
+// an error were not added and are returned separately.
+func groupFromInfo(accountID string, info *types.GroupInfo) (*models.WhatsAppMeowGroup, []models.GroupParticipant) {
+	group := &models.WhatsAppMeowGroup{
+		WhatsAppMeowAccountID: accountID,
+		JID:                   info.JID.String(),
+		Name:                  info.Name,
+		Description:           info.Topic,
+		IsAnnounce:            info.IsAnnounce,
+		IsLocked:              info.IsLocked,
+		Participants:          models.GroupParticipants{},
+	}
+	if !info.OwnerJID.IsEmpty() {
+		group.OwnerJID = info.OwnerJID.ToNonAD().String()
+	}
+	if !info.GroupCreated.IsZero() {
+		created := info.GroupCreated
+		group.GroupCreatedAt = &created
+	}
+
+	var failed []models.GroupParticipant
+	for _, participant := range info.Participants {
+		if participant.Error != 0 {
+			failed = append(failed, groupParticipant(participant))
+			continue
+		}
+		group.Participants = append(group.Participants, groupParticipant(participant))
+	}
+	return group, failed
+}
+
+func groupParticipant(participant types.GroupParticipant) models.GroupParticipant {
+	converted := models.GroupParticipant{
+		JID:          participant.JID.ToNonAD().String(),
+		IsAdmin:      participant.IsAdmin,
+		IsSuperAdmin: participant.IsSuperAdmin,
+		Error:        participant.Error,
+	}
+	switch {
+	case !participant.PhoneNumber.IsEmpty():
+		converted.PhoneNumber = participant.PhoneNumber.User
+	case participant.JID.Server == types.DefaultUserServer:
+		converted.PhoneNumber = participant.JID.User
+	}
+	return converted
+}
+
+// parseParticipants accepts JIDs and phone numbers in any format
+func parseParticipants(values []string) ([]types.JID, error) {
+	participants := make([]types.JID, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "@") {
+			jid, err := types.ParseJID(strings.TrimSpace(value))
+			if err != nil || jid.Server == types.GroupServer {
+				return nil, fmt.Errorf("%w: invalid participant %q", ErrInvalidGroup, value)
+			}
+			participants = append(participants, jid.ToNonAD())
+			continue
+		}
+		phone := store.NormalizePhone(value)
+		if phone == "" {
+			return nil, fmt.Errorf("%w: invalid participant %q", ErrInvalidGroup, value)
+		}
+		participants = append(participants, types.NewJID(phone, types.DefaultUserServer))
+	}
+	return participants, nil
+}
+
+func checkGroupName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidGroup)
+	case utf8.RuneCountInString(name) > maxGroupNameLength:
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidGroup, maxGroupNameLength)
+	}
+	return nil
+}
+
+// groupError makes WhatsApp's answers about groups map to the service's
+// errors
+func groupError(action string, err error) error {
+	switch {
+	case errors.Is(err, whatsmeow.ErrGroupNotFound), errors.Is(err, whatsmeow.ErrNotInGroup):
+		return fmt.Errorf("failed to %s: %w: %w", action, store.ErrNotFound, err)
+	case errors.Is(err, whatsmeow.ErrInviteLinkInvalid), errors.Is(err, whatsmeow.ErrInviteLinkRevoked),
+		errors.Is(err, whatsmeow.ErrInvalidImageFormat):
+		return fmt.Errorf("failed to %s: %w: %w", action, ErrInvalidGroup, err)
+	case errors.Is(err, whatsmeow.ErrGroupInviteLinkUnauthorized), errors.Is(err, whatsmeow.ErrIQNotAuthorized),
+		errors.Is(err, whatsmeow.ErrIQForbidden):
+		return fmt.Errorf("failed to %s: %w: %w", action, ErrGroupForbidden, err)
+	default:
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+}
+
+// makeGroupPhoto crops an image to its centered square and scales it down
+// to a JPEG WhatsApp accepts as a group picture
+func makeGroupPhoto(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image of %dx%d cannot be used", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	square := image.Rect(0, 0, side, side).Add(bounds.Min).Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+	size := min(side, groupPhotoSize)
+
+	photo := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(photo, photo.Rect, image.White, image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(photo, photo.Rect, src, square, draw.Over, nil)
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, photo, &jpeg.Options{Quality: groupPhotoQuality}); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
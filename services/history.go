@@ -0,0 +1,155 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+const (
+	// defaultHistoryLimit is the page size when a listing names none
+	defaultHistoryLimit = 50
+	// maxHistoryLimit caps the page size of listings
+	maxHistoryLimit = 200
+)
+
+// ErrInvalidQuery is returned for malformed listing filters and cursors
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ListMessages returns one page of an organization's messages, newest first,
+// and the cursor of the next page, which is empty on the last page
+func (s *WhatsAppMeowService) ListMessages(query models.MessageHistoryQuery) ([]*models.WhatsAppMeowMessage, string, error) {
+	filter := store.MessageFilter{
+		OrganizationID: query.OrganizationID,
+		LeadID:         query.LeadID,
+		TemplateID:     query.TemplateID,
+		ChatJID:        query.ChatJID,
+		Since:          query.Since,
+		Until:          query.Until,
+	}
+	if query.AccountID != "" {
+		account, err := s.getAccount(query.OrganizationID, query.AccountID)
+		if err != nil {
+			return nil, "", err
+		}
+		filter.AccountID = account.ID
+	}
+
+	switch strings.ToLower(query.Direction) {
+	case "":
+	case "inbound":
+		filter.FromMe = new(bool)
+	case "outbound":
+		fromMe := true
+		filter.FromMe = &fromMe
+	default:
+		return nil, "", fmt.Errorf("%w: direction must be inbound or outbound", ErrInvalidQuery)
+	}
+
+	if query.MessageType != "" {
+		filter.MessageType = models.WhatsAppMeowMessageType(strings.ToUpper(query.MessageType))
+		switch filter.MessageType {
+		case models.MessageTypeText, models.MessageTypeImage, models.MessageTypeVideo, models.MessageTypeAudio,
+			models.MessageTypeDocument, models.MessageTypeSticker, models.MessageTypeLocation,
+			models.MessageTypeContact, models.MessageTypeSystem:
+		default:
+			return nil, "", fmt.Errorf("%w: unknown message type %s", ErrInvalidQuery, query.MessageType)
+		}
+	}
+
+	if query.Status != "" {
+		filter.Status = models.WhatsAppMeowMessageStatus(strings.ToUpper(query.Status))
+		switch filter.Status {
+		case models.MessageStatusSent, models.MessageStatusDelivered, models.MessageStatusRead, models.MessageStatusFailed:
+		default:
+			return nil, "", fmt.Errorf("%w: unknown status %s", ErrInvalidQuery, query.Status)
+		}
+	}
+
+	var err error
+	if filter.After, err = decodeCursor(query.Cursor); err != nil {
+		return nil, "", err
+	}
+	limit := historyLimit(query.Limit)
+	filter.Limit = limit + 1
+
+	messages, err := s.messages.ListMessages(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(messages) > limit {
+		messages = messages[:limit]
+		next = encodeCursor(messages[limit-1])
+	}
+	// Opening a chat reads it
+	if query.ChatJID != "" {
+		s.readOnFetch(query.OrganizationID, messages)
+	}
+	return messages, next, nil
+}
+
+// ListConversations returns one page of an organization's chats, most
+// recently active first, and the cursor of the next page
+func (s *WhatsAppMeowService) ListConversations(organizationID, accountID, cursor string, limit int) ([]*models.WhatsAppMeowConversation, string, error) {
+	filter := store.ConversationFilter{OrganizationID: organizationID}
+	if accountID != "" {
+		account, err := s.getAccount(organizationID, accountID)
+		if err != nil {
+			return nil, "", err
+		}
+		filter.AccountID = account.ID
+	}
+
+	var err error
+	if filter.After, err = decodeCursor(cursor); err != nil {
+		return nil, "", err
+	}
+	limit = historyLimit(limit)
+	filter.Limit = limit + 1
+
+	conversations, err := s.messages.ListConversations(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(conversations) <= limit {
+		return conversations, "", nil
+	}
+	conversations = conversations[:limit]
+	return conversations, encodeCursor(conversations[limit-1].LastMessage), nil
+}
+
+func historyLimit(limit int) int {
+	if limit <= 0 {
+		return defaultHistoryLimit
+	}
+	return min(limit, maxHistoryLimit)
+}
+
+// encodeCursor makes an opaque cursor pointing behind message
+func encodeCursor(message *models.WhatsAppMeowMessage) string {
+	raw := strconv.FormatInt(message.Timestamp.UnixNano(), 10) + ":" + message.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*store.MessageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	timestamp, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return &store.MessageCursor{Timestamp: time.Unix(0, timestamp), ID: id}, nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+const (
+	// storedMediaPrefix marks media_url values that point into the blob store
+	// rather than at a remote URL
+	storedMediaPrefix = "blob:"
+	// MediaDownloadPath is where signed media links are served
+	MediaDownloadPath = "/api/whatsmeow/media"
+	// defaultMediaLinkTTL applies when the configuration sets no lifetime
+	defaultMediaLinkTTL = 15 * time.Minute
+	// mediaDownloadTimeout bounds fetching one attachment from WhatsApp
+	mediaDownloadTimeout = 2 * time.Minute
+	// defaultInboundMediaMaxBytes applies when the configuration sets no
+	// limit on received attachments
+	defaultInboundMediaMaxBytes = 100 << 20
+)
+
+// ErrMediaLinkInvalid is returned for media links with a wrong signature or
+// past their expiry
+var ErrMediaLinkInvalid = errors.New("invalid media link")
+
+// mediaMessage is a received message part with a downloadable attachment
+type mediaMessage interface {
+	whatsmeow.DownloadableMessage
+	GetFileLength() uint64
+	GetMimetype() string
+}
+
+// UseMediaStore turns on downloading inbound media into blobs. signingKey
+// signs download links; a random key is generated when it is empty. Call it
+// before accounts connect.
+func (s *WhatsAppMeowService) UseMediaStore(blobs store.BlobStore, signingKey []byte) {
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		rand.Read(signingKey)
+	}
+	s.blobs = blobs
+	s.mediaKey = signingKey
+}
+
+// downloadableMedia returns the attachment part of a message, if it has one
+func downloadableMedia(msg *waE2E.Message) mediaMessage {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage()
+	default:
+		return nil
+	}
+}
+
+// mediaReference keeps the download details of a received attachment
+func mediaReference(media mediaMessage) *models.MediaReference {
+	if media == nil || media.GetDirectPath() == "" {
+		return nil
+	}
+	return &models.MediaReference{
+		DirectPath:    media.GetDirectPath(),
+		MediaKey:      media.GetMediaKey(),
+		FileEncSHA256: media.GetFileEncSHA256(),
+		FileSHA256:    media.GetFileSHA256(),
+		FileLength:    media.GetFileLength(),
+		MimeType:      media.GetMimetype(),
+	}
+}
+
+// storeInboundMedia downloads the attachment of a received message into the
+// blob store and points the stored row at it. Attachments larger than the
+// configured limit are left on WhatsApp's servers; the row keeps their
+// media reference.
+func (s *WhatsAppMeowService) storeInboundMedia(client WhatsAppClient, message *models.WhatsAppMeowMessage, media mediaMessage) {
+	maxBytes := uint64(defaultInboundMediaMaxBytes)
+	if s.config.MediaInboundMaxBytes > 0 {
+		maxBytes = uint64(s.config.MediaInboundMaxBytes)
+	}
+	if media.GetFileLength() > maxBytes {
+		log.Printf("Not downloading media of message %s: %d bytes exceeds the limit of %d", message.MessageID, media.GetFileLength(), maxBytes)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaDownloadTimeout)
+	defer cancel()
+
+	data, err := client.Download(ctx, media)
+	if err != nil {
+		log.Printf("Failed to download media of message %s: %v", message.MessageID, err)
+		return
+	}
+
+	mimeType := media.GetMimetype()
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	key := message.WhatsAppMeowAccountID + "/" + message.MessageID
+	if err := s.blobs.PutBlob(key, data, mimeType); err != nil {
+		log.Printf("Failed to store media of message %s: %v", message.MessageID, err)
+		return
+	}
+
+	if err := s.messages.SetMessageMedia(message.WhatsAppMeowAccountID, message.MessageID, storedMediaPrefix+key, mimeType); err != nil {
+		log.Printf("Failed to save media of message %s: %v", message.MessageID, err)
+	}
+}
+
+// MediaLink returns a signed, time-limited download link for the stored
+// media of a message
+func (s *WhatsAppMeowService) MediaLink(organizationID, accountID, messageID string) (string, time.Time, error) {
+	message, _, err := s.getMessage(organizationID, accountID, messageID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if _, ok := storedMediaKey(message); !ok || s.blobs == nil {
+		return "", time.Time{}, fmt.Errorf("media of message %s: %w", messageID, store.ErrNotFound)
+	}
+
+	ttl := time.Duration(s.config.MediaLinkTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultMediaLinkTTL
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+
+	query := url.Values{}
+	query.Set("accountId", message.WhatsAppMeowAccountID)
+	query.Set("messageId", messageID)
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", s.signMediaLink(message.WhatsAppMeowAccountID, messageID, expiresAt.Unix()))
+	return MediaDownloadPath + "?" + query.Encode(), expiresAt, nil
+}
+
+// OpenMedia checks a download link and returns the media it points to
+func (s *WhatsAppMeowService) OpenMedia(accountID, messageID, expires, signature string) ([]byte, string, error) {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || s.blobs == nil {
+		return nil, "", ErrMediaLinkInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signMediaLink(accountID, messageID, expiresAt))) {
+		return nil, "", ErrMediaLinkInvalid
+	}
+	if time.Now().Unix() > expiresAt {
+		return nil, "", fmt.Errorf("%w: link expired", ErrMediaLinkInvalid)
+	}
+
+	message, err := s.messages.GetMessage(accountID, messageID)
+	if err != nil {
+		return nil, "", fmt.Errorf("message %s: %w", messageID, err)
+	}
+	key, ok := storedMediaKey(message)
+	if !ok {
+		return nil, "", fmt.Errorf("media of message %s: %w", messageID, store.ErrNotFound)
+	}
+
+	data, err := s.blobs.GetBlob(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("media of message %s: %w", messageID, err)
+	}
+	mimeType := "application/octet-stream"
+	if message.MediaType != nil && *message.MediaType != "" {
+		mimeType = *message.MediaType
+	}
+	return data, mimeType, nil
+}
+
+func (s *WhatsAppMeowService) signMediaLink(accountID, messageID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.mediaKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", accountID, messageID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// storedMediaKey returns the blob key of a message's media when it was
+// downloaded into the blob store
+func storedMediaKey(message *models.WhatsAppMeowMessage) (string, bool) {
+	if message.MediaURL == nil || !strings.HasPrefix(*message.MediaURL, storedMediaPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(*message.MediaURL, storedMediaPrefix), true
+}
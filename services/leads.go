@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// attributeLead links an inbound direct message to the lead who sent it and
+// publishes EventContactUnknown when no lead matches
+func (s *WhatsAppMeowService) attributeLead(account *models.WhatsAppMeowAccount, msg *events.Message, message *models.WhatsAppMeowMessage) {
+	leadID, err := s.matchLead(account.OrganizationID, msg.Info.MessageSource)
+	if err != nil {
+		log.Printf("Failed to match message %s to a lead: %v", msg.Info.ID, err)
+		return
+	}
+	if leadID != "" {
+		message.LeadID = &leadID
+		return
+	}
+
+	s.publish(account, models.EventContactUnknown, map[string]interface{}{
+		"jid":         message.FromJID,
+		"phoneNumber": senderPhone(msg.Info.MessageSource),
+		"pushName":    msg.Info.PushName,
+		"messageId":   msg.Info.ID,
+	})
+}
+
+// matchLead resolves a sender to a lead of the organization: first through
+// the latest message we sent them on behalf of a lead, then by phone number
+// in the lead table. It returns an empty ID when nothing matches.
+func (s *WhatsAppMeowService) matchLead(organizationID string, source types.MessageSource) (string, error) {
+	fromMe := true
+	for _, jid := range senderJIDs(source) {
+		messages, err := s.messages.ListMessages(store.MessageFilter{
+			OrganizationID: organizationID,
+			ToJID:          jid.String(),
+			FromMe:         &fromMe,
+			HasLead:        true,
+			Limit:          1,
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(messages) > 0 {
+			return *messages[0].LeadID, nil
+		}
+	}
+
+	phone := senderPhone(source)
+	if phone == "" {
+		return "", nil
+	}
+	leadID, err := s.leads.FindLeadByPhone(organizationID, store.NormalizePhone(phone))
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	return leadID, err
+}
+
+// senderJIDs lists the addresses a sender may have been messaged at: their
+// JID and, for senders hidden behind a LID, their phone number JID
+func senderJIDs(source types.MessageSource) []types.JID {
+	jids := []types.JID{source.Sender.ToNonAD()}
+	if !source.SenderAlt.IsEmpty() {
+		jids = append(jids, source.SenderAlt.ToNonAD())
+	}
+	return jids
+}
+
+// senderPhone returns the phone number of a sender, or an empty string when
+// WhatsApp only revealed their LID
+func senderPhone(source types.MessageSource) string {
+	for _, jid := range senderJIDs(source) {
+		if jid.Server == types.DefaultUserServer {
+			return jid.User
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"whatsmeow-service/models"
+)
+
+// Logout unlinks the account's device from the phone, deletes its session
+// from the device store and resets the pairing fields of the account. It
+// reports whether WhatsApp confirmed the unlink; an offline account can
+// only be wiped locally.
+func (s *WhatsAppMeowService) Logout(organizationID, accountID string) (bool, error) {
+	account, err := s.getAccount(organizationID, accountID)
+	if err != nil {
+		return false, err
+	}
+
+	remoteUnlinked, err := s.unlinkDevice(account)
+	if err != nil {
+		return remoteUnlinked, err
+	}
+
+	if _, err := s.wipeAccount(account.ID); err != nil {
+		return remoteUnlinked, fmt.Errorf("failed to reset account: %w", err)
+	}
+
+	return remoteUnlinked, nil
+}
+
+// unlinkDevice logs the account's device out of WhatsApp when it is online,
+// deletes its session from the device store and closes its client
+func (s *WhatsAppMeowService) unlinkDevice(account *models.WhatsAppMeowAccount) (bool, error) {
+	ctx := context.Background()
+	remoteUnlinked := false
+	client := s.clientFor(account.ID)
+	if client != nil && client.IsLoggedIn() {
+		if err := client.Logout(ctx); err != nil {
+			log.Printf("Failed to unlink device of account %s, wiping it locally: %v", account.ID, err)
+		} else {
+			remoteUnlinked = true
+		}
+	}
+
+	// Without a live client, load the device just to delete it
+	if client == nil {
+		var err error
+		if client, err = s.newClient(account); err != nil {
+			return false, fmt.Errorf("failed to load device: %w", err)
+		}
+	}
+	if err := client.DeleteDevice(ctx); err != nil {
+		return remoteUnlinked, fmt.Errorf("failed to delete device: %w", err)
+	}
+	s.closeClient(account.ID)
+
+	return remoteUnlinked, nil
+}
+
+// wipeAccount clears everything tied to the unlinked device and publishes
+// an account.logged_out event
+func (s *WhatsAppMeowService) wipeAccount(accountID string) (*models.WhatsAppMeowAccount, error) {
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	account.QRCode = nil
+	account.SessionData = nil
+	account.PhoneNumber = nil
+	account.IsPaired = false
+	account.IsConnected = false
+	account.ConnectionStatus = models.ConnectionStatusLoggedOut
+	if err := s.accounts.UpdateAccount(account); err != nil {
+		return nil, err
+	}
+
+	s.publish(account, models.EventAccountLoggedOut, account)
+	return account, nil
+}
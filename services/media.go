@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// errMediaNotModified is returned by mediaFetcher.fetch when the source still has
+// the ETag we asked about
+var errMediaNotModified = errors.New("media not modified")
+
+// preparedMedia is a media file fetched from its source and uploaded to WhatsApp
+type preparedMedia struct {
+	data     []byte
+	mimeType string
+	fileName string
+	etag     string
+	upload   whatsmeow.UploadResponse
+
+	// seconds and waveform describe voice notes
+	seconds  uint32
+	waveform []byte
+	// thumbnail previews images
+	thumbnail *thumbnail
+}
+
+func (m *preparedMedia) withoutData() preparedMedia {
+	media := *m
+	media.data = nil
+	return media
+}
+
+// prepareMedia fetches mediaURL on behalf of an organization and uploads it
+// to WhatsApp as appInfo. mediaType overrides the MIME type reported by the
+// remote server.
+func (s *WhatsAppMeowService) prepareMedia(client WhatsAppClient, organizationID, mediaURL, mediaType string, appInfo whatsmeow.MediaType) (*preparedMedia, error) {
+	media, err := s.uploadCached(organizationID, mediaURL, string(appInfo), func(media *preparedMedia) error {
+		var err error
+		media.upload, err = client.Upload(context.Background(), media.data, appInfo)
+		if err != nil {
+			return fmt.Errorf("failed to upload media: %w", err)
+		}
+		// Images we cannot decode are still sent, just without a preview
+		if appInfo == whatsmeow.MediaImage {
+			media.thumbnail, _ = makeThumbnail(media.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "" {
+		media.mimeType = mediaType
+	}
+
+	return media, nil
+}
+
+// uploadCached fetches mediaURL and hands it to upload, unless the same file
+// was uploaded as the same kind recently. Cached media comes back without
+// its data.
+func (s *WhatsAppMeowService) uploadCached(organizationID, mediaURL, kind string, upload func(media *preparedMedia) error) (*preparedMedia, error) {
+	if mediaURL == "" {
+		return nil, fmt.Errorf("mediaUrl is required for media messages")
+	}
+
+	etag := s.mediaCache.etag(kind, mediaURL)
+	media, err := s.fetcher.fetch(organizationID, mediaURL, etag)
+	if errors.Is(err, errMediaNotModified) {
+		if cached, ok := s.mediaCache.byURL(kind, mediaURL, etag); ok {
+			return cached, nil
+		}
+		media, err = s.fetcher.fetch(organizationID, mediaURL, "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch media: %w", err)
+	}
+
+	key := mediaCacheKey(kind, media.data)
+	if cached, ok := s.mediaCache.get(key); ok {
+		cached.mimeType, cached.fileName, cached.etag = media.mimeType, media.fileName, media.etag
+		s.mediaCache.put(key, kind, mediaURL, cached)
+		return cached, nil
+	}
+
+	if err := upload(media); err != nil {
+		return nil, err
+	}
+	s.mediaCache.put(key, kind, mediaURL, media)
+	return media, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// mediaCache remembers what a media file was uploaded to WhatsApp as, so the
+// same file sent to many leads is only uploaded once. Entries are keyed by
+// the kind of upload and the SHA256 of the source file; source URLs map onto
+// them through their ETag so unchanged files need not be downloaded again.
+// The least recently used entries are evicted beyond maxEntries.
+type mediaCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	urls    map[string]cachedURL
+}
+
+type cachedMedia struct {
+	key       string
+	media     preparedMedia
+	expiresAt time.Time
+	urls      []string
+}
+
+// cachedURL is what a source URL last served
+type cachedURL struct {
+	etag string
+	key  string
+}
+
+// newMediaCache returns nil, which disables caching, when maxEntries is not
+// positive
+func newMediaCache(ttl time.Duration, maxEntries int) *mediaCache {
+	if maxEntries <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &mediaCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		urls:       make(map[string]cachedURL),
+	}
+}
+
+func mediaCacheKey(kind string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return kind + ":" + hex.EncodeToString(sum[:])
+}
+
+// etag returns the ETag mediaURL served when it was cached for kind
+func (c *mediaCache) etag(kind, mediaURL string) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.urls[kind+" "+mediaURL]
+	if !ok {
+		return ""
+	}
+	return cached.etag
+}
+
+// byURL returns the media cached for mediaURL as long as it still has etag
+func (c *mediaCache) byURL(kind, mediaURL, etag string) (*preparedMedia, bool) {
+	if c == nil || etag == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.urls[kind+" "+mediaURL]
+	if !ok || cached.etag != etag {
+		return nil, false
+	}
+	return c.getLocked(cached.key)
+}
+
+// get returns the upload cached under key
+func (c *mediaCache) get(key string) (*preparedMedia, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.getLocked(key)
+}
+
+func (c *mediaCache) getLocked(key string) (*preparedMedia, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cachedMedia)
+	if time.Now().After(entry.expiresAt) {
+		c.removeLocked(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	media := entry.media
+	return &media, true
+}
+
+// put caches the upload of media under key and links mediaURL to it when the
+// server gave an ETag. The file contents themselves are not kept.
+func (c *mediaCache) put(key, kind, mediaURL string, media *preparedMedia) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		cached := media.withoutData()
+		element = c.lru.PushFront(&cachedMedia{key: key, media: cached, expiresAt: time.Now().Add(c.ttl)})
+		c.entries[key] = element
+	}
+	c.lru.MoveToFront(element)
+
+	if media.etag != "" {
+		urlKey := kind + " " + mediaURL
+		c.urls[urlKey] = cachedURL{etag: media.etag, key: key}
+		entry := element.Value.(*cachedMedia)
+		entry.urls = append(entry.urls, urlKey)
+	}
+
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *mediaCache) removeLocked(element *list.Element) {
+	entry := c.lru.Remove(element).(*cachedMedia)
+	delete(c.entries, entry.key)
+	for _, urlKey := range entry.urls {
+		if c.urls[urlKey].key == entry.key {
+			delete(c.urls, urlKey)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"whatsmeow-service/config"
+)
+
+const (
+	// defaultMaxMediaSize caps how much we download from a caller-supplied
+	// media URL unless configured otherwise
+	defaultMaxMediaSize = 100 << 20
+	// defaultMediaFetchTimeout bounds a whole media download unless
+	// configured otherwise
+	defaultMediaFetchTimeout = 60 * time.Second
+)
+
+// Codes of MediaFetchError
+const (
+	MediaErrInvalidURL       = "media_url_invalid"
+	MediaErrSchemeNotAllowed = "media_scheme_not_allowed"
+	MediaErrHostNotAllowed   = "media_host_not_allowed"
+	MediaErrAddressBlocked   = "media_address_blocked"
+	MediaErrTooManyRedirects = "media_too_many_redirects"
+	MediaErrTooLarge         = "media_too_large"
+	MediaErrTimeout          = "media_timeout"
+	MediaErrUnavailable      = "media_unavailable"
+)
+
+// MediaFetchError explains why a media URL was refused or could not be
+// fetched
+type MediaFetchError struct {
+	Code   string
+	Reason string
+}
+
+func (e *MediaFetchError) Error() string {
+	return e.Reason
+}
+
+// ErrorCode lets API responses carry the machine-readable code
+func (e *MediaFetchError) ErrorCode() string {
+	return e.Code
+}
+
+func mediaFetchError(code, format string, args ...interface{}) *MediaFetchError {
+	return &MediaFetchError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// blockedPrefixes are special-purpose ranges not covered by the netip
+// predicates in blockedAddress
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// blockedAddress reports whether addr is not on the public internet
+func blockedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaFetcher downloads caller-supplied media URLs without letting callers
+// reach internal services. Addresses are checked when dialing, after DNS
+// resolution, so a hostname cannot resolve to a private address and every
+// redirect hop is checked the same way.
+type mediaFetcher struct {
+	transport    *http.Transport
+	schemes      map[string]bool
+	maxBytes     int64
+	timeout      time.Duration
+	maxRedirects int
+	allowlists   map[string][]string
+}
+
+func newMediaFetcher(cfg *config.Config) *mediaFetcher {
+	f := &mediaFetcher{
+		schemes:      make(map[string]bool),
+		maxBytes:     int64(cfg.MediaMaxBytes),
+		timeout:      time.Duration(cfg.MediaFetchTimeout) * time.Second,
+		maxRedirects: cfg.MediaMaxRedirects,
+		allowlists:   cfg.MediaDomainAllowlist,
+	}
+	for _, scheme := range cfg.MediaAllowedSchemes {
+		f.schemes[strings.ToLower(scheme)] = true
+	}
+	if len(f.schemes) == 0 {
+		f.schemes["http"], f.schemes["https"] = true, true
+	}
+	if f.maxBytes <= 0 {
+		f.maxBytes = defaultMaxMediaSize
+	}
+	if f.timeout <= 0 {
+		f.timeout = defaultMediaFetchTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !cfg.MediaAllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || blockedAddress(addr) {
+				return mediaFetchError(MediaErrAddressBlocked, "media host resolves to blocked address %s", host)
+			}
+			return nil
+		}
+	}
+	f.transport = &http.Transport{
+		// A proxy would hide the address we actually connect to
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return f
+}
+
+// checkURL validates the scheme and host of a media URL for an organization
+func (f *mediaFetcher) checkURL(organizationID string, target *url.URL) error {
+	if !f.schemes[strings.ToLower(target.Scheme)] {
+		return mediaFetchError(MediaErrSchemeNotAllowed, "media URL scheme %q is not allowed", target.Scheme)
+	}
+	host := strings.ToLower(target.Hostname())
+	if host == "" {
+		return mediaFetchError(MediaErrInvalidURL, "media URL has no host")
+	}
+
+	allowed, ok := f.allowlists[organizationID]
+	if !ok {
+		return nil
+	}
+	for _, domain := range allowed {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasPrefix(domain, "*.") && strings.HasSuffix(host, domain[1:]) {
+			return nil
+		}
+	}
+	return mediaFetchError(MediaErrHostNotAllowed, "media host %s is not on the organization's allowlist", host)
+}
+
+// fetch downloads mediaURL for an organization. With an etag it asks the
+// server to answer errMediaNotModified if the file has not changed.
+func (f *mediaFetcher) fetch(organizationID, mediaURL, etag string) (*preparedMedia, error) {
+	parsed, err := url.Parse(mediaURL)
+	if err != nil {
+		return nil, mediaFetchError(MediaErrInvalidURL, "invalid media URL: %v", err)
+	}
+	if err := f.checkURL(organizationID, parsed); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, mediaFetchError(MediaErrInvalidURL, "invalid media URL: %v", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	client := &http.Client{
+		Transport: f.transport,
+		Timeout:   f.timeout,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if len(via) > f.maxRedirects {
+				return mediaFetchError(MediaErrTooManyRedirects, "media URL redirected more than %d times", f.maxRedirects)
+			}
+			return f.checkURL(organizationID, next.URL)
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, classifyFetchError(err)
+	}
+	defer resp.Body.Close()
+
+	if etag != "" && resp.StatusCode == http.StatusNotModified {
+		return nil, errMediaNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, mediaFetchError(MediaErrUnavailable, "unexpected status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, mediaFetchError(MediaErrTooLarge, "media is larger than %d bytes", f.maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, classifyFetchError(err)
+	}
+	if int64(len(data)) > f.maxBytes {
+		return nil, mediaFetchError(MediaErrTooLarge, "media is larger than %d bytes", f.maxBytes)
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+		mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	}
+
+	fileName := path.Base(parsed.Path)
+	if fileName == "." || fileName == "/" {
+		fileName = "file"
+	}
+
+	return &preparedMedia{
+		data:     data,
+		mimeType: mimeType,
+		fileName: fileName,
+		etag:     resp.Header.Get("ETag"),
+	}, nil
+}
+
+// classifyFetchError turns transport failures into MediaFetchErrors
+func classifyFetchError(err error) error {
+	var fetchErr *MediaFetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return mediaFetchError(MediaErrTimeout, "media download timed out")
+	}
+	return mediaFetchError(MediaErrUnavailable, "media download failed: %v", err)
+}
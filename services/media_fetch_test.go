@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"whatsmeow-service/config"
+)
+
+func TestBlockedAddress(t *testing.T) {
+	for addr, blocked := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.10":     true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"::ffff:127.0.0.1": true,
+		"8.8.8.8":          false,
+		"2606:4700::1111":  false,
+	} {
+		if got := blockedAddress(netip.MustParseAddr(addr)); got != blocked {
+			t.Errorf("blockedAddress(%s) = %v, want %v", addr, got, blocked)
+		}
+	}
+}
+
+func TestMediaFetcherRefusesUnsafeURLs(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, server.URL+"/loop", http.StatusFound)
+		case "/to-file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		default:
+			w.Write([]byte(strings.Repeat("x", 64)))
+		}
+	}))
+	defer server.Close()
+
+	strict := newMediaFetcher(&config.Config{MediaDomainAllowlist: map[string][]string{"org_2": {"*.example.com"}}})
+	local := newMediaFetcher(&config.Config{MediaAllowPrivateNetworks: true, MediaMaxBytes: 32, MediaMaxRedirects: 2})
+
+	tests := []struct {
+		name    string
+		fetcher *mediaFetcher
+		org     string
+		url     string
+		code    string
+	}{
+		{"loopback", strict, "org_1", server.URL + "/file", MediaErrAddressBlocked},
+		{"localhost name", strict, "org_1", strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/file", MediaErrAddressBlocked},
+		{"scheme", strict, "org_1", "ftp://files.example.com/a.pdf", MediaErrSchemeNotAllowed},
+		{"allowlist", strict, "org_2", "https://evil.test/a.pdf", MediaErrHostNotAllowed},
+		{"too large", local, "org_1", server.URL + "/file", MediaErrTooLarge},
+		{"redirect loop", local, "org_1", server.URL + "/loop", MediaErrTooManyRedirects},
+		{"redirect scheme", local, "org_1", server.URL + "/to-file", MediaErrSchemeNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.fetcher.fetch(tt.org, tt.url, "")
+			var fetchErr *MediaFetchError
+			if !errors.As(err, &fetchErr) || fetchErr.Code != tt.code {
+				t.Fatalf("expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	allowed := newMediaFetcher(&config.Config{MediaAllowPrivateNetworks: true, MediaDomainAllowlist: map[string][]string{"org_2": {"127.0.0.1"}}})
+	if media, err := allowed.fetch("org_2", server.URL+"/file", ""); err != nil || len(media.data) != 64 {
+		t.Fatalf("expected an allowed host to be fetched, got %v", err)
+	}
+}
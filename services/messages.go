@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// ErrInvalidMessage is returned when a send request's payload is incomplete
+var ErrInvalidMessage = errors.New("invalid message")
+
+// describeMessage extracts the stored type, text and MIME type of a message
+func describeMessage(message *waE2E.Message) (models.WhatsAppMeowMessageType, string, string) {
+	switch {
+	case message.GetConversation() != "":
+		return models.MessageTypeText, message.GetConversation(), ""
+	case message.GetExtendedTextMessage() != nil:
+		return models.MessageTypeText, message.GetExtendedTextMessage().GetText(), ""
+	case message.GetImageMessage() != nil:
+		image := message.GetImageMessage()
+		return models.MessageTypeImage, image.GetCaption(), image.GetMimetype()
+	case message.GetVideoMessage() != nil:
+		video := message.GetVideoMessage()
+		return models.MessageTypeVideo, video.GetCaption(), video.GetMimetype()
+	case message.GetAudioMessage() != nil:
+		return models.MessageTypeAudio, "", message.GetAudioMessage().GetMimetype()
+	case message.GetDocumentMessage() != nil:
+		document := message.GetDocumentMessage()
+		return models.MessageTypeDocument, document.GetCaption(), document.GetMimetype()
+	case message.GetStickerMessage() != nil:
+		return models.MessageTypeSticker, "", message.GetStickerMessage().GetMimetype()
+	case message.GetLocationMessage() != nil:
+		location := message.GetLocationMessage()
+		return models.MessageTypeLocation, joinNonEmpty(location.GetName(), location.GetAddress()), ""
+	case message.GetLiveLocationMessage() != nil:
+		return models.MessageTypeLocation, message.GetLiveLocationMessage().GetCaption(), ""
+	case message.GetContactMessage() != nil:
+		return models.MessageTypeContact, message.GetContactMessage().GetVcard(), ""
+	case message.GetContactsArrayMessage() != nil:
+		var vcards []string
+		for _, contact := range message.GetContactsArrayMessage().GetContacts() {
+			vcards = append(vcards, contact.GetVcard())
+		}
+		return models.MessageTypeContact, joinNonEmpty(vcards...), ""
+	default:
+		return models.MessageTypeSystem, "", ""
+	}
+}
+
+// buildLocationMessage turns a location payload into a location message
+func buildLocationMessage(location *models.Location) (*waE2E.Message, error) {
+	if location == nil {
+		return nil, fmt.Errorf("%w: location is required", ErrInvalidMessage)
+	}
+	if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+		return nil, fmt.Errorf("%w: coordinates out of range", ErrInvalidMessage)
+	}
+
+	return &waE2E.Message{
+		LocationMessage: &waE2E.LocationMessage{
+			DegreesLatitude:  proto.Float64(location.Latitude),
+			DegreesLongitude: proto.Float64(location.Longitude),
+			Name:             optionalString(location.Name),
+			Address:          optionalString(location.Address),
+		},
+	}, nil
+}
+
+// messageLocation returns the coordinates of a location message
+func messageLocation(message *waE2E.Message) (latitude, longitude *float64) {
+	switch {
+	case message.GetLocationMessage() != nil:
+		location := message.GetLocationMessage()
+		return proto.Float64(location.GetDegreesLatitude()), proto.Float64(location.GetDegreesLongitude())
+	case message.GetLiveLocationMessage() != nil:
+		location := message.GetLiveLocationMessage()
+		return proto.Float64(location.GetDegreesLatitude()), proto.Float64(location.GetDegreesLongitude())
+	default:
+		return nil, nil
+	}
+}
+
+func joinNonEmpty(values ...string) string {
+	var parts []string
+	for _, value := range values {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// GetMessage retrieves a stored message of an organization by its WhatsApp ID
+func (s *WhatsAppMeowService) GetMessage(organizationID, accountID, messageID string) (*models.WhatsAppMeowMessage, error) {
+	message, _, err := s.getMessage(organizationID, accountID, messageID)
+	return message, err
+}
+
+// getMessage loads a stored message together with the account holding it.
+// WhatsApp message IDs are only unique per account, so without an account ID
+// the message ID must not be held by more than one of the organization's
+// accounts.
+func (s *WhatsAppMeowService) getMessage(organizationID, accountID, messageID string) (*models.WhatsAppMeowMessage, *models.WhatsAppMeowAccount, error) {
+	if accountID != "" {
+		account, err := s.getAccount(organizationID, accountID)
+		if err != nil {
+			return nil, nil, err
+		}
+		message, err := s.messages.GetMessage(account.ID, messageID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("message %s: %w", messageID, err)
+		}
+		return message, account, nil
+	}
+
+	messages, err := s.messages.ListMessages(store.MessageFilter{OrganizationID: organizationID, MessageID: messageID, Limit: 2})
+	if err != nil {
+		return nil, nil, err
+	}
+	switch len(messages) {
+	case 0:
+		return nil, nil, fmt.Errorf("message %s: %w", messageID, store.ErrNotFound)
+	case 1:
+	default:
+		return nil, nil, fmt.Errorf("%w: message %s is held by several accounts", ErrAccountRequired, messageID)
+	}
+
+	account, err := s.accounts.GetAccount(messages[0].WhatsAppMeowAccountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return messages[0], account, nil
+}
+
+// messageChat works out the chat a stored message belongs to and who sent it
+func messageChat(message *models.WhatsAppMeowMessage) (chat, sender types.JID, err error) {
+	from, err := types.ParseJID(message.FromJID)
+	if err != nil {
+		return types.EmptyJID, types.EmptyJID, fmt.Errorf("invalid sender JID: %w", err)
+	}
+	to, err := types.ParseJID(message.ToJID)
+	if err != nil {
+		return types.EmptyJID, types.EmptyJID, fmt.Errorf("invalid recipient JID: %w", err)
+	}
+
+	// Inbound direct messages are addressed to us, so the chat is the sender
+	if message.IsFromMe || to.Server == types.GroupServer {
+		return to, from, nil
+	}
+	return from, from, nil
+}
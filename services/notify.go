@@ -0,0 +1,75 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"whatsmeow-service/models"
+)
+
+// Notifier receives every event the service publishes. Notify must not
+// block; slow deliveries belong in a goroutine.
+type Notifier interface {
+	Notify(event models.Event)
+}
+
+// AddNotifier registers a receiver for published events
+func (s *WhatsAppMeowService) AddNotifier(notifier Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notifiers = append(s.notifiers, notifier)
+}
+
+func (s *WhatsAppMeowService) publish(account *models.WhatsAppMeowAccount, eventType models.EventType, data interface{}) {
+	event := models.Event{
+		ID:             newEventID(),
+		Type:           eventType,
+		OrganizationID: account.OrganizationID,
+		AccountID:      account.ID,
+		Timestamp:      time.Now().UTC(),
+		Data:           data,
+	}
+
+	s.mu.Lock()
+	notifiers := append([]Notifier(nil), s.notifiers...)
+	s.mu.Unlock()
+
+	for _, notifier := range notifiers {
+		notifier.Notify(event)
+	}
+}
+
+// publishAccount publishes an account event with the account as it is
+// stored
+func (s *WhatsAppMeowService) publishAccount(accountID string, eventType models.EventType) {
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+	s.publish(account, eventType, account)
+}
+
+// publishMessage publishes a message received from WhatsApp. The account is
+// loaded unless the caller already has it. Later changes such as the stored
+// media link are not part of the event.
+func (s *WhatsAppMeowService) publishMessage(accountID string, account *models.WhatsAppMeowAccount, message *models.WhatsAppMeowMessage) {
+	if account == nil {
+		var err error
+		if account, err = s.accounts.GetAccount(accountID); err != nil {
+			log.Printf("Failed to load account %s: %v", accountID, err)
+			return
+		}
+	}
+	received := *message
+	s.publish(account, models.EventMessageReceived, &received)
+}
+
+func newEventID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsmeow-service/config"
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// ErrCodeRecipientOptedOut is the API error code of OptedOutError
+const ErrCodeRecipientOptedOut = "recipient_opted_out"
+
+// Keywords used unless configured otherwise, in English, Spanish,
+// Portuguese, French, German and Italian
+var (
+	defaultOptOutKeywords = []string{
+		"STOP", "STOP ALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT", "OPT OUT", "OPTOUT",
+		"BAJA", "DETENER", "PARAR", "SAIR", "CANCELAR",
+		"ARRET", "ARRÊT", "DESABONNER", "DÉSABONNER",
+		"STOPP", "ABMELDEN", "BASTA", "DISISCRIVI",
+	}
+	defaultOptInKeywords = []string{
+		"START", "UNSTOP", "SUBSCRIBE", "OPT IN", "OPTIN",
+		"ALTA", "SUSCRIBIR", "INICIAR", "VOLTAR",
+		"DEMARRER", "DÉMARRER", "ANMELDEN", "ISCRIVI",
+	}
+)
+
+// OptedOutError refuses a message to a contact on the organization's
+// suppression list
+type OptedOutError struct {
+	JID string
+}
+
+func (e *OptedOutError) Error() string {
+	return fmt.Sprintf("%s has opted out of messages", e.JID)
+}
+
+// ErrorCode lets API responses carry the machine-readable code
+func (e *OptedOutError) ErrorCode() string {
+	return ErrCodeRecipientOptedOut
+}
+
+// optKeywords holds the normalized opt-out and opt-in keywords
+type optKeywords struct {
+	optOut map[string]bool
+	optIn  map[string]bool
+}
+
+func newOptKeywords(cfg *config.Config) optKeywords {
+	optOut, optIn := cfg.OptOutKeywords, cfg.OptInKeywords
+	if len(optOut) == 0 {
+		optOut = defaultOptOutKeywords
+	}
+	if len(optIn) == 0 {
+		optIn = defaultOptInKeywords
+	}
+
+	keywords := optKeywords{optOut: make(map[string]bool), optIn: make(map[string]bool)}
+	for _, keyword := range optOut {
+		keywords.optOut[normalizeKeyword(keyword)] = true
+	}
+	for _, keyword := range optIn {
+		keywords.optIn[normalizeKeyword(keyword)] = true
+	}
+	return keywords
+}
+
+// normalizeKeyword uppercases a message and drops surrounding punctuation
+// and extra spaces, so "Stop!" and " stop " both read as "STOP"
+func normalizeKeyword(text string) string {
+	text = strings.TrimFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.ToUpper(strings.Join(strings.Fields(text), " "))
+}
+
+// checkSuppression refuses sends to contacts who opted out
+func (s *WhatsAppMeowService) checkSuppression(organizationID string, to types.JID) error {
+	jid := to.ToNonAD().String()
+	suppressed, err := s.optOuts.IsSuppressed(organizationID, jid)
+	if err != nil {
+		return fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	if suppressed {
+		return &OptedOutError{JID: jid}
+	}
+	return nil
+}
+
+// handleOptKeyword updates the suppression list when the whole of an
+// inbound text message is an opt-out or opt-in keyword. It reports whether
+// the message was one.
+func (s *WhatsAppMeowService) handleOptKeyword(account *models.WhatsAppMeowAccount, msg *events.Message, message *models.WhatsAppMeowMessage) bool {
+	if message.MessageType != models.MessageTypeText || message.MessageText == nil {
+		return false
+	}
+	keyword := normalizeKeyword(*message.MessageText)
+
+	var eventType models.EventType
+	var reply string
+	switch {
+	case s.keywords.optOut[keyword]:
+		for _, jid := range senderJIDs(msg.Info.MessageSource) {
+			err := s.optOuts.AddSuppression(&models.WhatsAppMeowSuppression{
+				OrganizationID: account.OrganizationID,
+				JID:            jid.String(),
+				Keyword:        keyword,
+				MessageID:      &message.MessageID,
+			})
+			if err != nil {
+				log.Printf("Failed to suppress %s: %v", jid, err)
+				return true
+			}
+		}
+		eventType, reply = models.EventContactOptedOut, s.config.OptOutReply
+	case s.keywords.optIn[keyword]:
+		removed := false
+		for _, jid := range senderJIDs(msg.Info.MessageSource) {
+			err := s.optOuts.RemoveSuppression(account.OrganizationID, jid.String())
+			if err == nil {
+				removed = true
+			} else if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Failed to lift suppression of %s: %v", jid, err)
+				return true
+			}
+		}
+		// Opting in without having opted out changes nothing
+		if !removed {
+			return true
+		}
+		eventType, reply = models.EventContactOptedIn, s.config.OptInReply
+	default:
+		return false
+	}
+
+	s.publish(account, eventType, map[string]interface{}{
+		"jid":         message.FromJID,
+		"phoneNumber": senderPhone(msg.Info.MessageSource),
+		"leadId":      message.LeadID,
+		"keyword":     keyword,
+		"messageId":   message.MessageID,
+	})
+	if reply != "" {
+		go s.sendConfirmation(account, msg.Info.Chat, message.LeadID, reply)
+	}
+	return true
+}
+
+// sendConfirmation answers an opt-out or opt-in keyword. It bypasses the
+// suppression list, which the contact has just joined.
+func (s *WhatsAppMeowService) sendConfirmation(account *models.WhatsAppMeowAccount, chat types.JID, leadID *string, text string) {
+	client := s.clientFor(account.ID)
+	if client == nil {
+		return
+	}
+
+	req := models.SendMessageRequest{
+		OrganizationID: account.OrganizationID,
+		AccountID:      account.ID,
+		ToJID:          chat.String(),
+		MessageType:    "text",
+		MessageText:    text,
+	}
+	if leadID != nil {
+		req.LeadID = *leadID
+	}
+	message := s.buildTextMessage(text)
+	messageID, err := s.send(client, chat, message)
+	if err != nil {
+		log.Printf("Failed to send confirmation to %s: %v", chat, err)
+		return
+	}
+	if err := s.saveMessage(client, account.ID, chat, req, message, messageID); err != nil {
+		log.Printf("Failed to save message: %v", err)
+	}
+}
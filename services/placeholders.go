@@ -0,0 +1,40 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+)
+
+// placeholderPattern matches placeholders such as {{contact.name}}, with an
+// optional inline default as in {{contact.name|there}}
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z][\w.]*)\s*(?:\|([^{}]*))?\}\}`)
+
+// renderPlaceholders fills in the placeholders of a text. Placeholders
+// without a value fall back to their inline default, or are left out.
+func renderPlaceholders(text string, values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		if value := values[match[1]]; value != "" {
+			return value
+		}
+		return match[2]
+	})
+}
+
+// missingPlaceholders lists the placeholders of a text that have neither a
+// value nor an inline default
+func missingPlaceholders(text string, values map[string]string) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(text, -1) {
+		name := text[match[2]:match[3]]
+		hasDefault := match[4] >= 0 && match[5] > match[4]
+		if values[name] != "" || hasDefault || seen[name] {
+			continue
+		}
+		seen[name] = true
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	return missing
+}
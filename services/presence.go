@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsmeow-service/models"
+)
+
+// ErrInvalidPresence is returned when a presence request cannot be sent as
+// given
+var ErrInvalidPresence = errors.New("invalid presence")
+
+// SendChatPresence shows or clears a typing or recording indicator in a chat.
+// It returns the ID of the account that sent it.
+func (s *WhatsAppMeowService) SendChatPresence(req models.ChatPresenceRequest) (string, error) {
+	var state types.ChatPresence
+	var media types.ChatPresenceMedia
+	switch req.State {
+	case models.ChatPresenceComposing:
+		state, media = types.ChatPresenceComposing, types.ChatPresenceMediaText
+	case models.ChatPresenceRecording:
+		state, media = types.ChatPresenceComposing, types.ChatPresenceMediaAudio
+	case models.ChatPresencePaused:
+		state, media = types.ChatPresencePaused, types.ChatPresenceMediaText
+	default:
+		return "", fmt.Errorf("%w: state must be composing, recording or paused", ErrInvalidPresence)
+	}
+
+	toJID, err := types.ParseJID(req.ToJID)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid JID: %v", ErrInvalidPresence, err)
+	}
+
+	// Typing shows on the number the next message will come from
+	account, err := s.selectAccount(models.SendMessageRequest{
+		OrganizationID: req.OrganizationID,
+		AccountID:      req.AccountID,
+		ToJID:          req.ToJID,
+		LeadID:         req.LeadID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get account: %w", err)
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	if err := client.SendChatPresence(toJID.ToNonAD(), state, media); err != nil {
+		return "", fmt.Errorf("failed to send chat presence: %w", err)
+	}
+	return account.ID, nil
+}
+
+// SetPresence marks an account as online or offline. Phones stop getting
+// notifications while an account shows as online.
+func (s *WhatsAppMeowService) SetPresence(req models.PresenceRequest) (string, error) {
+	account, err := s.getAccount(req.OrganizationID, req.AccountID)
+	if err != nil {
+		return "", err
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	presence := types.PresenceUnavailable
+	if req.Available {
+		presence = types.PresenceAvailable
+	}
+	if err := client.SendPresence(presence); err != nil {
+		return "", fmt.Errorf("failed to send presence: %w", err)
+	}
+	return account.ID, nil
+}
+
+// SubscribePresence asks WhatsApp for a contact's presence updates, which
+// are then published as contact.presence events. WhatsApp only sends them
+// while the account shows as online.
+func (s *WhatsAppMeowService) SubscribePresence(req models.SubscribePresenceRequest) (string, error) {
+	jid, err := types.ParseJID(req.JID)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid JID: %v", ErrInvalidPresence, err)
+	}
+
+	account, err := s.selectAccount(models.SendMessageRequest{
+		OrganizationID: req.OrganizationID,
+		AccountID:      req.AccountID,
+		ToJID:          req.JID,
+		LeadID:         req.LeadID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get account: %w", err)
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	if err := client.SubscribePresence(jid.ToNonAD()); err != nil {
+		return "", fmt.Errorf("failed to subscribe to presence: %w", err)
+	}
+	return account.ID, nil
+}
+
+// handleChatPresence publishes contacts typing or recording in a chat
+func (s *WhatsAppMeowService) handleChatPresence(accountID string, presence *events.ChatPresence) {
+	if presence.IsFromMe {
+		return
+	}
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+
+	state := models.ChatPresenceComposing
+	switch {
+	case presence.State == types.ChatPresencePaused:
+		state = models.ChatPresencePaused
+	case presence.Media == types.ChatPresenceMediaAudio:
+		state = models.ChatPresenceRecording
+	}
+
+	s.publish(account, models.EventChatPresence, map[string]interface{}{
+		"chatJid":     presence.Chat.ToNonAD().String(),
+		"senderJid":   presence.Sender.ToNonAD().String(),
+		"phoneNumber": senderPhone(presence.MessageSource),
+		"isGroup":     presence.IsGroup,
+		"state":       state,
+	})
+}
+
+// handlePresence publishes subscribed contacts coming online or going
+// offline
+func (s *WhatsAppMeowService) handlePresence(accountID string, presence *events.Presence) {
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"jid":       presence.From.ToNonAD().String(),
+		"available": !presence.Unavailable,
+	}
+	if presence.From.Server == types.DefaultUserServer {
+		data["phoneNumber"] = presence.From.User
+	}
+	if !presence.LastSeen.IsZero() {
+		data["lastSeen"] = presence.LastSeen.UTC()
+	}
+	s.publish(account, models.EventContactPresence, data)
+}
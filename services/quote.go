@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// ErrQuotedMessageAccount is returned when a reply names an account other
+// than the one that holds the quoted message
+var ErrQuotedMessageAccount = errors.New("quoted message belongs to a different account")
+
+// resolveQuotedMessage loads the stored message a send request replies to
+func (s *WhatsAppMeowService) resolveQuotedMessage(req models.SendMessageRequest) (*models.WhatsAppMeowMessage, error) {
+	quoted, _, err := s.getMessage(req.OrganizationID, req.AccountID, req.QuotedMessageID)
+	if errors.Is(err, store.ErrNotFound) && req.AccountID != "" {
+		// Tell the caller when another of the organization's accounts holds it
+		if _, _, other := s.getMessage(req.OrganizationID, "", req.QuotedMessageID); other == nil || errors.Is(other, ErrAccountRequired) {
+			return nil, ErrQuotedMessageAccount
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quoted message: %w", err)
+	}
+
+	return quoted, nil
+}
+
+// quotedContextInfo builds the reply context pointing at a stored message
+func quotedContextInfo(quoted *models.WhatsAppMeowMessage) *waE2E.ContextInfo {
+	participant := quoted.FromJID
+	if jid, err := types.ParseJID(quoted.FromJID); err == nil {
+		participant = jid.ToNonAD().String()
+	}
+
+	return &waE2E.ContextInfo{
+		StanzaID:      proto.String(quoted.MessageID),
+		Participant:   proto.String(participant),
+		QuotedMessage: quotedMessage(quoted),
+	}
+}
+
+// quotedMessage rebuilds the preview WhatsApp shows above a reply. Only the
+// stored type, text and MIME type are known, which is all the preview needs.
+func quotedMessage(quoted *models.WhatsAppMeowMessage) *waE2E.Message {
+	var mimeType *string
+	text := quoted.MessageText
+	if quoted.MediaType != nil && *quoted.MediaType != "" {
+		mimeType = proto.String(*quoted.MediaType)
+	}
+
+	switch quoted.MessageType {
+	case models.MessageTypeImage:
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: text, Mimetype: mimeType}}
+	case models.MessageTypeVideo:
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Caption: text, Mimetype: mimeType}}
+	case models.MessageTypeAudio:
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{Mimetype: mimeType}}
+	case models.MessageTypeDocument:
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{Caption: text, Mimetype: mimeType}}
+	case models.MessageTypeSticker:
+		return &waE2E.Message{StickerMessage: &waE2E.StickerMessage{Mimetype: mimeType}}
+	case models.MessageTypeLocation:
+		return &waE2E.Message{LocationMessage: &waE2E.LocationMessage{
+			DegreesLatitude:  quoted.Latitude,
+			DegreesLongitude: quoted.Longitude,
+			Name:             text,
+		}}
+	case models.MessageTypeContact:
+		return &waE2E.Message{ContactMessage: &waE2E.ContactMessage{Vcard: text}}
+	default:
+		if text == nil {
+			text = proto.String("")
+		}
+		return &waE2E.Message{Conversation: text}
+	}
+}
+
+// attachContextInfo sets the context of whichever submessage message holds.
+// Plain conversation messages cannot carry a context, so they are turned
+// into extended text messages.
+func attachContextInfo(message *waE2E.Message, contextInfo *waE2E.ContextInfo) {
+	switch {
+	case message.Conversation != nil:
+		message.ExtendedTextMessage = &waE2E.ExtendedTextMessage{
+			Text:        message.Conversation,
+			ContextInfo: contextInfo,
+		}
+		message.Conversation = nil
+	case message.ExtendedTextMessage != nil:
+		message.ExtendedTextMessage.ContextInfo = contextInfo
+	case message.ImageMessage != nil:
+		message.ImageMessage.ContextInfo = contextInfo
+	case message.VideoMessage != nil:
+		message.VideoMessage.ContextInfo = contextInfo
+	case message.AudioMessage != nil:
+		message.AudioMessage.ContextInfo = contextInfo
+	case message.DocumentMessage != nil:
+		message.DocumentMessage.ContextInfo = contextInfo
+	case message.StickerMessage != nil:
+		message.StickerMessage.ContextInfo = contextInfo
+	case message.LocationMessage != nil:
+		message.LocationMessage.ContextInfo = contextInfo
+	case message.ContactMessage != nil:
+		message.ContactMessage.ContextInfo = contextInfo
+	case message.ContactsArrayMessage != nil:
+		message.ContactsArrayMessage.ContextInfo = contextInfo
+	}
+}
+
+// messageContextInfo returns the context of whichever submessage message
+// holds, or nil when it has none
+func messageContextInfo(message *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case message.GetExtendedTextMessage() != nil:
+		return message.GetExtendedTextMessage().GetContextInfo()
+	case message.GetImageMessage() != nil:
+		return message.GetImageMessage().GetContextInfo()
+	case message.GetVideoMessage() != nil:
+		return message.GetVideoMessage().GetContextInfo()
+	case message.GetAudioMessage() != nil:
+		return message.GetAudioMessage().GetContextInfo()
+	case message.GetDocumentMessage() != nil:
+		return message.GetDocumentMessage().GetContextInfo()
+	case message.GetStickerMessage() != nil:
+		return message.GetStickerMessage().GetContextInfo()
+	case message.GetLocationMessage() != nil:
+		return message.GetLocationMessage().GetContextInfo()
+	case message.GetContactMessage() != nil:
+		return message.GetContactMessage().GetContextInfo()
+	case message.GetContactsArrayMessage() != nil:
+		return message.GetContactsArrayMessage().GetContextInfo()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// SendReaction reacts to a stored message from the account that holds it.
+// An empty reaction removes the one sent earlier.
+func (s *WhatsAppMeowService) SendReaction(req models.SendReactionRequest) (string, error) {
+	message, account, err := s.getMessage(req.OrganizationID, req.AccountID, req.MessageID)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	chat, sender, err := messageChat(message)
+	if err != nil {
+		return "", err
+	}
+
+	reactionID, err := s.send(client, chat, client.BuildReaction(chat, sender, message.MessageID, req.Reaction))
+	if err != nil {
+		return "", fmt.Errorf("failed to send reaction: %w", err)
+	}
+
+	s.recordReaction(account, &models.WhatsAppMeowReaction{
+		WhatsAppMeowAccountID: account.ID,
+		MessageID:             message.MessageID,
+		SenderJID:             client.OwnJID().ToNonAD().String(),
+		Reaction:              req.Reaction,
+		IsFromMe:              true,
+		Timestamp:             time.Now(),
+	})
+
+	return reactionID, nil
+}
+
+// handleReaction attaches an incoming reaction to the message it targets
+// instead of storing it as a message of its own
+func (s *WhatsAppMeowService) handleReaction(accountID string, msg *events.Message, reaction *waE2E.ReactionMessage) {
+	target, err := s.messages.GetMessage(accountID, reaction.GetKey().GetID())
+	if errors.Is(err, store.ErrNotFound) {
+		// Reactions to messages sent before the service tracked them
+		return
+	} else if err != nil {
+		log.Printf("Failed to load message %s: %v", reaction.GetKey().GetID(), err)
+		return
+	}
+
+	// The reaction must come from the target's chat, and its key says
+	// whether the reacting party sent the target
+	sender := msg.Info.Sender.ToNonAD().String()
+	chat, _, err := messageChat(target)
+	sentByReactor := target.IsFromMe
+	if !msg.Info.IsFromMe {
+		sentByReactor = !target.IsFromMe && target.FromJID == sender
+	}
+	if err != nil || chat != msg.Info.Chat.ToNonAD() || sentByReactor != reaction.GetKey().GetFromMe() {
+		log.Printf("Ignoring reaction to message %s from %s", target.MessageID, sender)
+		return
+	}
+
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+
+	timestamp := msg.Info.Timestamp
+	if ms := reaction.GetSenderTimestampMS(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	s.recordReaction(account, &models.WhatsAppMeowReaction{
+		WhatsAppMeowAccountID: account.ID,
+		MessageID:             target.MessageID,
+		SenderJID:             sender,
+		Reaction:              reaction.GetText(),
+		IsFromMe:              msg.Info.IsFromMe,
+		Timestamp:             timestamp,
+	})
+}
+
+func (s *WhatsAppMeowService) recordReaction(account *models.WhatsAppMeowAccount, reaction *models.WhatsAppMeowReaction) {
+	err := s.messages.SetReaction(reaction)
+	if errors.Is(err, store.ErrNotFound) {
+		// Reactions to messages sent before the service tracked them
+		return
+	} else if err != nil {
+		log.Printf("Failed to save reaction to message %s: %v", reaction.MessageID, err)
+		return
+	}
+
+	s.publish(account, models.EventMessageReaction, reaction)
+}
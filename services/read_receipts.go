@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// ErrInvalidReadReceiptPolicy is returned for unknown read receipt policies
+var ErrInvalidReadReceiptPolicy = errors.New("readReceipts must be NEVER, ON_FETCH or IMMEDIATE")
+
+func parseReadReceiptPolicy(policy models.ReadReceiptPolicy) (models.ReadReceiptPolicy, error) {
+	policy = models.ReadReceiptPolicy(strings.ToUpper(string(policy)))
+	switch policy {
+	case models.ReadReceiptsNever, models.ReadReceiptsOnFetch, models.ReadReceiptsImmediately:
+		return policy, nil
+	default:
+		return "", ErrInvalidReadReceiptPolicy
+	}
+}
+
+// MarkRead sends read receipts for inbound messages, either the given ones
+// or every unread message of a chat. It returns how many were unread.
+func (s *WhatsAppMeowService) MarkRead(req models.MarkReadRequest) (int, error) {
+	var accountID string
+	if req.AccountID != "" {
+		account, err := s.getAccount(req.OrganizationID, req.AccountID)
+		if err != nil {
+			return 0, err
+		}
+		accountID = account.ID
+	}
+
+	var messages []*models.WhatsAppMeowMessage
+	switch {
+	case len(req.MessageIDs) > 0:
+		for _, messageID := range req.MessageIDs {
+			message, _, err := s.getMessage(req.OrganizationID, accountID, messageID)
+			if err != nil {
+				return 0, err
+			}
+			if message.IsFromMe {
+				return 0, fmt.Errorf("%w: message %s was sent by us", ErrInvalidMessage, messageID)
+			}
+			if !message.IsRead {
+				messages = append(messages, message)
+			}
+		}
+	case req.ChatJID != "":
+		chat, err := types.ParseJID(req.ChatJID)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid chatJID: %v", ErrInvalidMessage, err)
+		}
+		messages, err = s.messages.ListMessages(store.MessageFilter{
+			OrganizationID: req.OrganizationID,
+			AccountID:      accountID,
+			ChatJID:        chat.ToNonAD().String(),
+			FromMe:         new(bool),
+			Status:         models.MessageStatusDelivered,
+		})
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("%w: chatJID or messageIds are required", ErrInvalidMessage)
+	}
+
+	marked := 0
+	for accountID, unread := range groupByAccount(messages) {
+		account, err := s.getAccount(req.OrganizationID, accountID)
+		if err != nil {
+			return marked, err
+		}
+		if err := s.markMessagesRead(account, unread); err != nil {
+			return marked, err
+		}
+		marked += len(unread)
+	}
+	return marked, nil
+}
+
+// readOnFetch marks the fetched history of a chat as read for accounts that
+// read messages when they are fetched
+func (s *WhatsAppMeowService) readOnFetch(organizationID string, messages []*models.WhatsAppMeowMessage) {
+	var unread []*models.WhatsAppMeowMessage
+	for _, message := range messages {
+		if !message.IsFromMe && !message.IsRead {
+			unread = append(unread, message)
+		}
+	}
+
+	for accountID, messages := range groupByAccount(unread) {
+		account, err := s.getAccount(organizationID, accountID)
+		if err != nil || account.ReadReceipts != models.ReadReceiptsOnFetch {
+			continue
+		}
+		if err := s.markMessagesRead(account, messages); err != nil {
+			log.Printf("Failed to mark messages of account %s as read: %v", accountID, err)
+		}
+	}
+}
+
+// readOnReceipt marks an inbound message as read as soon as it arrives for
+// accounts that read messages immediately. The account is loaded unless
+// the caller already has it.
+func (s *WhatsAppMeowService) readOnReceipt(accountID string, account *models.WhatsAppMeowAccount, message *models.WhatsAppMeowMessage) {
+	if account == nil {
+		var err error
+		if account, err = s.accounts.GetAccount(accountID); err != nil {
+			log.Printf("Failed to load account %s: %v", accountID, err)
+			return
+		}
+	}
+	if account.ReadReceipts != models.ReadReceiptsImmediately {
+		return
+	}
+
+	received := *message
+	go func() {
+		if err := s.markMessagesRead(account, []*models.WhatsAppMeowMessage{&received}); err != nil {
+			log.Printf("Failed to mark message %s as read: %v", received.MessageID, err)
+		}
+	}()
+}
+
+// markMessagesRead sends read receipts for messages of one account and
+// records them as read
+func (s *WhatsAppMeowService) markMessagesRead(account *models.WhatsAppMeowAccount, messages []*models.WhatsAppMeowMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return err
+	}
+
+	// A receipt covers messages of one sender in one chat
+	type target struct{ chat, sender types.JID }
+	var order []target
+	batches := make(map[target][]*models.WhatsAppMeowMessage)
+	for _, message := range messages {
+		chat, sender, err := readReceiptTarget(message)
+		if err != nil {
+			return err
+		}
+		key := target{chat, sender}
+		if batches[key] == nil {
+			order = append(order, key)
+		}
+		batches[key] = append(batches[key], message)
+	}
+
+	now := time.Now()
+	for _, key := range order {
+		var ids []types.MessageID
+		for _, message := range batches[key] {
+			ids = append(ids, message.MessageID)
+		}
+		if err := client.MarkRead(ids, now, key.chat, key.sender); err != nil {
+			return fmt.Errorf("failed to mark messages as read: %w", err)
+		}
+
+		for _, message := range batches[key] {
+			if err := s.messages.UpdateMessageStatus(message.WhatsAppMeowAccountID, message.MessageID, models.MessageStatusRead, now); err != nil {
+				log.Printf("Failed to update status of message %s: %v", message.MessageID, err)
+				continue
+			}
+			message.IsRead = true
+			message.ReadAt = &now
+		}
+	}
+	return nil
+}
+
+// readReceiptTarget returns the chat of an inbound message and, in groups,
+// its sender
+func readReceiptTarget(message *models.WhatsAppMeowMessage) (types.JID, types.JID, error) {
+	sender, err := types.ParseJID(message.FromJID)
+	if err != nil {
+		return types.EmptyJID, types.EmptyJID, fmt.Errorf("message %s has an invalid sender: %w", message.MessageID, err)
+	}
+	if chat, err := types.ParseJID(message.ToJID); err == nil && chat.Server == types.GroupServer {
+		return chat, sender, nil
+	}
+	return sender, types.EmptyJID, nil
+}
+
+func groupByAccount(messages []*models.WhatsAppMeowMessage) map[string][]*models.WhatsAppMeowMessage {
+	grouped := make(map[string][]*models.WhatsAppMeowMessage)
+	for _, message := range messages {
+		grouped[message.WhatsAppMeowAccountID] = append(grouped[message.WhatsAppMeowAccountID], message)
+	}
+	return grouped
+}
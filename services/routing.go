@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// leastLoadedWindow is how far back least-loaded routing counts sends
+const leastLoadedWindow = 24 * time.Hour
+
+// ErrNoAccountAvailable is returned when a lead's number can no longer be
+// used and none of the organization's other accounts is connected
+var ErrNoAccountAvailable = errors.New("no connected account available")
+
+// ErrInvalidRoutingPolicy is returned for unknown routing policies
+var ErrInvalidRoutingPolicy = errors.New("routingPolicy must be round-robin, sticky-per-lead or least-loaded")
+
+// ValidateRoutingPolicy checks a routing policy; empty means the configured
+// default
+func ValidateRoutingPolicy(policy models.RoutingPolicy) error {
+	switch policy {
+	case "", models.RoutingRoundRobin, models.RoutingStickyPerLead, models.RoutingLeastLoaded:
+		return nil
+	default:
+		return fmt.Errorf("%w, got %q", ErrInvalidRoutingPolicy, policy)
+	}
+}
+
+// selectAccount picks the account a message is sent from. An explicit
+// accountId always wins. Otherwise a lead that has already talked to one of
+// the organization's numbers stays pinned to it; only when that number is
+// logged out or banned does the routing policy pick among the connected
+// accounts (the request's policy, falling back to the configured default).
+func (s *WhatsAppMeowService) selectAccount(req models.SendMessageRequest) (*models.WhatsAppMeowAccount, error) {
+	// A bad policy is rejected even when it would not be consulted
+	if err := ValidateRoutingPolicy(req.RoutingPolicy); err != nil {
+		return nil, err
+	}
+	if req.AccountID != "" {
+		return s.getAccount(req.OrganizationID, req.AccountID)
+	}
+
+	pinned, err := s.pinnedAccount(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation history: %w", err)
+	}
+	if pinned != nil {
+		if routable(pinned) {
+			return pinned, nil
+		}
+		log.Printf("Account %s pinned for %s is %s, choosing another account", pinned.ID, stickyKey(req), pinned.ConnectionStatus)
+	}
+
+	accounts, err := s.accounts.ListAccounts(req.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*models.WhatsAppMeowAccount
+	for _, account := range accounts {
+		if account.IsConnected && routable(account) {
+			candidates = append(candidates, account)
+		}
+	}
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case len(candidates) == 0 && pinned != nil:
+		return nil, fmt.Errorf("%w: account %s is %s", ErrNoAccountAvailable, pinned.ID, pinned.ConnectionStatus)
+	case len(candidates) == 0 && len(accounts) > 0:
+		// Let the send path report why the account cannot be used
+		return accounts[0], nil
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("organization %s has no account", req.OrganizationID)
+	}
+
+	policy := req.RoutingPolicy
+	if policy == "" {
+		policy = models.RoutingPolicy(s.config.RoutingPolicy)
+	}
+
+	switch policy {
+	case models.RoutingStickyPerLead:
+		return hashedAccount(stickyKey(req), candidates), nil
+	case models.RoutingLeastLoaded:
+		return s.leastLoadedAccount(candidates)
+	default:
+		return s.roundRobinAccount(req.OrganizationID, candidates), nil
+	}
+}
+
+// pinnedAccount returns the account of the most recent message exchanged
+// with the lead, or with the recipient JID when the lead has no history
+func (s *WhatsAppMeowService) pinnedAccount(req models.SendMessageRequest) (*models.WhatsAppMeowAccount, error) {
+	filters := make([]store.MessageFilter, 0, 2)
+	if req.LeadID != "" {
+		filters = append(filters, store.MessageFilter{OrganizationID: req.OrganizationID, LeadID: req.LeadID, Limit: 1})
+	}
+	if jid, err := types.ParseJID(req.ToJID); err == nil && !jid.IsEmpty() {
+		filters = append(filters, store.MessageFilter{OrganizationID: req.OrganizationID, ChatJID: jid.ToNonAD().String(), Limit: 1})
+	}
+
+	for _, filter := range filters {
+		messages, err := s.messages.ListMessages(filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		account, err := s.accounts.GetAccount(messages[0].WhatsAppMeowAccountID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return account, nil
+	}
+
+	return nil, nil
+}
+
+// routable reports whether an account may still be used for sending. A
+// number that is merely offline keeps its conversations; it has to be
+// unlinked or banned before they move elsewhere.
+func routable(account *models.WhatsAppMeowAccount) bool {
+	switch account.ConnectionStatus {
+	case models.ConnectionStatusLoggedOut, models.ConnectionStatusBanned:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *WhatsAppMeowService) roundRobinAccount(organizationID string, candidates []*models.WhatsAppMeowAccount) *models.WhatsAppMeowAccount {
+	s.mu.Lock()
+	next := s.roundRobin[organizationID]
+	s.roundRobin[organizationID] = next + 1
+	s.mu.Unlock()
+
+	return candidates[next%uint64(len(candidates))]
+}
+
+func (s *WhatsAppMeowService) leastLoadedAccount(candidates []*models.WhatsAppMeowAccount) (*models.WhatsAppMeowAccount, error) {
+	since := time.Now().Add(-leastLoadedWindow)
+
+	var best *models.WhatsAppMeowAccount
+	bestCount := -1
+	for _, account := range candidates {
+		count, err := s.messages.CountOutboundMessages(account.ID, since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count messages of account %s: %w", account.ID, err)
+		}
+		if bestCount < 0 || count < bestCount {
+			best, bestCount = account, count
+		}
+	}
+
+	return best, nil
+}
+
+// stickyKey identifies the conversation partner a sticky route is kept for
+func stickyKey(req models.SendMessageRequest) string {
+	if req.LeadID != "" {
+		return "lead:" + req.LeadID
+	}
+	return "jid:" + req.ToJID
+}
+
+// hashedAccount maps a key onto the candidates so the same key keeps
+// landing on the same account while the candidate set is unchanged
+func hashedAccount(key string, candidates []*models.WhatsAppMeowAccount) *models.WhatsAppMeowAccount {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return candidates[hash.Sum32()%uint32(len(candidates))]
+}
@@ -2,50 +2,101 @@ package services
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"go.mau.fi/whatsmeow"
-	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
 
 	"whatsmeow-service/config"
 	"whatsmeow-service/models"
+	"whatsmeow-service/store"
 )
 
 type WhatsAppMeowService struct {
-	config *config.Config
-	db     *sql.DB
-	client *whatsmeow.Client
+	config    *config.Config
+	accounts  store.AccountStore
+	messages  store.MessageStore
+	leads     store.LeadStore
+	newClient ClientFactory
+
+	mu         sync.Mutex
+	clients    map[string]WhatsAppClient
+	roundRobin map[string]uint64
+	notifiers  []Notifier
+
+	blobs      store.BlobStore
+	mediaKey   []byte
+	fetcher    *mediaFetcher
+	mediaCache *mediaCache
+
+	// optOuts and keywords implement the suppression list
+	optOuts  store.SuppressionStore
+	keywords optKeywords
+	// rules holds auto-reply rules
+	rules store.AutoReplyStore
+	// templates holds message templates
+	templates store.TemplateStore
+	// groups holds the local copies of the groups accounts are in
+	groups store.GroupStore
+	// stream serves events to clients holding a token signed with streamKey
+	stream    *EventStream
+	streamKey []byte
 }
 
-func NewWhatsAppMeowService(cfg *config.Config, db *sql.DB) *WhatsAppMeowService {
+func NewWhatsAppMeowService(cfg *config.Config, st store.Store, newClient ClientFactory) *WhatsAppMeowService {
 	return &WhatsAppMeowService{
-		config: cfg,
-		db:     db,
+		config:     cfg,
+		accounts:   st,
+		messages:   st,
+		leads:      st,
+		newClient:  newClient,
+		clients:    make(map[string]WhatsAppClient),
+		roundRobin: make(map[string]uint64),
+		fetcher:    newMediaFetcher(cfg),
+		mediaCache: newMediaCache(time.Duration(cfg.MediaCacheTTL)*time.Second, cfg.MediaCacheEntries),
+		optOuts:    st,
+		keywords:   newOptKeywords(cfg),
+		rules:      st,
+		templates:  st,
+		groups:     st,
 	}
 }
 
 // SendMessage sends a WhatsApp message
 func (s *WhatsAppMeowService) SendMessage(req models.SendMessageRequest) (string, error) {
-	// Get account for organization
-	account, err := s.getAccount(req.OrganizationID)
-	if err != nil {
-		return "", fmt.Errorf("failed to get account: %w", err)
+	if req.TemplateID != "" {
+		if err := s.applyTemplate(&req); err != nil {
+			return "", err
+		}
 	}
 
-	if !account.IsConnected {
-		return "", fmt.Errorf("account is not connected")
+	// A reply has to come from the number that holds the quoted message
+	var quoted *models.WhatsAppMeowMessage
+	if req.QuotedMessageID != "" {
+		var err error
+		if quoted, err = s.resolveQuotedMessage(req); err != nil {
+			return "", err
+		}
+		req.AccountID = quoted.WhatsAppMeowAccountID
 	}
 
-	// Initialize client if needed
-	if s.client == nil {
-		if err := s.initializeClient(account); err != nil {
-			return "", fmt.Errorf("failed to initialize client: %w", err)
-		}
+	// Pick the account to send from
+	account, err := s.selectAccount(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get account: %w", err)
+	}
+
+	client, err := s.connectedClient(account)
+	if err != nil {
+		return "", err
 	}
 
 	// Parse JID
@@ -53,20 +104,33 @@ func (s *WhatsAppMeowService) SendMessage(req models.SendMessageRequest) (string
 	if err != nil {
 		return "", fmt.Errorf("invalid JID: %w", err)
 	}
+	if err := s.checkSuppression(req.OrganizationID, toJID); err != nil {
+		return "", err
+	}
 
-	// Send message based on type
-	var messageID string
+	// Build message based on type
+	var message *waE2E.Message
 	switch req.MessageType {
 	case "text":
-		messageID, err = s.sendTextMessage(toJID, req.MessageText)
+		message = s.buildTextMessage(req.MessageText)
 	case "image":
-		messageID, err = s.sendImageMessage(toJID, req.MessageText, req.MediaURL)
+		message, err = s.buildImageMessage(client, req.OrganizationID, req.MessageText, req.MediaURL, req.MediaType)
 	case "video":
-		messageID, err = s.sendVideoMessage(toJID, req.MessageText, req.MediaURL)
+		message, err = s.buildVideoMessage(client, req.OrganizationID, req.MessageText, req.MediaURL, req.MediaType, req.ThumbnailURL)
 	case "audio":
-		messageID, err = s.sendAudioMessage(toJID, req.MediaURL)
+		if req.PTT {
+			message, err = s.buildVoiceNoteMessage(client, req.OrganizationID, req.MediaURL)
+			break
+		}
+		message, err = s.buildAudioMessage(client, req.OrganizationID, req.MediaURL, req.MediaType)
 	case "document":
-		messageID, err = s.sendDocumentMessage(toJID, req.MessageText, req.MediaURL)
+		message, err = s.buildDocumentMessage(client, req.OrganizationID, req.MessageText, req.MediaURL, req.MediaType, req.ThumbnailURL)
+	case "sticker":
+		message, err = s.buildStickerMessage(client, req.OrganizationID, req.MediaURL)
+	case "location":
+		message, err = buildLocationMessage(req.Location)
+	case "contact":
+		message, err = buildContactMessage(req.Contacts)
 	default:
 		return "", fmt.Errorf("unsupported message type: %s", req.MessageType)
 	}
@@ -75,22 +139,32 @@ func (s *WhatsAppMeowService) SendMessage(req models.SendMessageRequest) (string
 		return "", fmt.Errorf("failed to send message: %w", err)
 	}
 
+	if quoted != nil {
+		attachContextInfo(message, quotedContextInfo(quoted))
+	}
+
+	messageID, err := s.send(client, toJID, message)
+	if err != nil {
+		return "", fmt.Errorf("failed to send message: %w", err)
+	}
+
 	// Save message to database
-	if err := s.saveMessage(account.ID, req, messageID); err != nil {
+	if err := s.saveMessage(client, account.ID, toJID, req, message, messageID); err != nil {
 		log.Printf("Failed to save message: %v", err)
 	}
 
 	return messageID, nil
 }
 
-// GetAccount retrieves account information
-func (s *WhatsAppMeowService) GetAccount(organizationID string) (*models.WhatsAppMeowAccount, error) {
-	return s.getAccount(organizationID)
+// GetAccount retrieves account information. accountID may be empty when
+// the organization owns a single account.
+func (s *WhatsAppMeowService) GetAccount(organizationID, accountID string) (*models.WhatsAppMeowAccount, error) {
+	return s.getAccount(organizationID, accountID)
 }
 
 // GetQRCode retrieves QR code for pairing
-func (s *WhatsAppMeowService) GetQRCode(organizationID string) (string, error) {
-	account, err := s.getAccount(organizationID)
+func (s *WhatsAppMeowService) GetQRCode(organizationID, accountID string) (string, error) {
+	account, err := s.getAccount(organizationID, accountID)
 	if err != nil {
 		return "", err
 	}
@@ -102,221 +176,553 @@ func (s *WhatsAppMeowService) GetQRCode(organizationID string) (string, error) {
 	return *account.QRCode, nil
 }
 
-// Connect initiates connection
-func (s *WhatsAppMeowService) Connect(organizationID, deviceID string) error {
-	// Update account status
-	_, err := s.db.Exec(`
-		UPDATE "WhatsAppMeowAccount" 
-		SET connection_status = 'CONNECTING', updated_at = $1 
-		WHERE organization_id = $2
-	`, time.Now(), organizationID)
-	
+// Connect initiates connection. The account is addressed by accountID, or
+// by deviceID when accountID is empty.
+func (s *WhatsAppMeowService) Connect(organizationID, accountID, deviceID string) error {
+	account, err := s.getAccountByDevice(organizationID, accountID, deviceID)
 	if err != nil {
-		return fmt.Errorf("failed to update connection status: %w", err)
+		return err
 	}
 
-	// Initialize client and start connection process
-	account, err := s.getAccount(organizationID)
-	if err != nil {
-		return err
+	// Update account status
+	if err := s.accounts.UpdateConnectionStatus(account.ID, models.ConnectionStatusConnecting, account.IsConnected); err != nil {
+		return fmt.Errorf("failed to update connection status: %w", err)
 	}
 
+	// Initialize client and start connection process
 	return s.initializeClient(account)
 }
 
 // Disconnect disconnects the client
-func (s *WhatsAppMeowService) Disconnect(organizationID string) error {
-	if s.client != nil {
-		s.client.Disconnect()
-		s.client = nil
+func (s *WhatsAppMeowService) Disconnect(organizationID, accountID string) error {
+	account, err := s.getAccount(organizationID, accountID)
+	if err != nil {
+		return err
 	}
 
+	s.closeClient(account.ID)
+
 	// Update account status
-	_, err := s.db.Exec(`
-		UPDATE "WhatsAppMeowAccount" 
-		SET connection_status = 'DISCONNECTED', is_connected = false, updated_at = $1 
-		WHERE organization_id = $2
-	`, time.Now(), organizationID)
-	
-	return err
+	return s.accounts.UpdateConnectionStatus(account.ID, models.ConnectionStatusDisconnected, false)
 }
 
 // Private methods
-func (s *WhatsAppMeowService) getAccount(organizationID string) (*models.WhatsAppMeowAccount, error) {
-	query := `
-		SELECT id, organization_id, device_id, session_data, qr_code, is_connected, is_paired, 
-		       phone_number, display_name, profile_picture, last_seen, connection_status, 
-		       created_at, updated_at
-		FROM "WhatsAppMeowAccount" 
-		WHERE organization_id = $1
-	`
-	
-	var account models.WhatsAppMeowAccount
-	var sessionDataJSON sql.NullString
-	var qrCode sql.NullString
-	var phoneNumber sql.NullString
-	var displayName sql.NullString
-	var profilePicture sql.NullString
-	var lastSeen sql.NullTime
-	
-	err := s.db.QueryRow(query, organizationID).Scan(
-		&account.ID,
-		&account.OrganizationID,
-		&account.DeviceID,
-		&sessionDataJSON,
-		&qrCode,
-		&account.IsConnected,
-		&account.IsPaired,
-		&phoneNumber,
-		&displayName,
-		&profilePicture,
-		&lastSeen,
-		&account.ConnectionStatus,
-		&account.CreatedAt,
-		&account.UpdatedAt,
-	)
-	
+func (s *WhatsAppMeowService) getAccount(organizationID, accountID string) (*models.WhatsAppMeowAccount, error) {
+	if accountID != "" {
+		account, err := s.accounts.GetAccount(accountID)
+		if err != nil {
+			return nil, err
+		}
+		// Never leak another organization's account
+		if account.OrganizationID != organizationID {
+			return nil, fmt.Errorf("account %s: %w", accountID, store.ErrNotFound)
+		}
+		return account, nil
+	}
+
+	accounts, err := s.accounts.ListAccounts(organizationID)
 	if err != nil {
 		return nil, err
 	}
+	switch len(accounts) {
+	case 0:
+		return nil, fmt.Errorf("organization %s has no account: %w", organizationID, store.ErrNotFound)
+	case 1:
+		return accounts[0], nil
+	default:
+		return nil, ErrAccountRequired
+	}
+}
 
-	// Handle nullable fields
-	if sessionDataJSON.Valid {
-		account.SessionData = &models.SessionData{}
-		// Parse session data JSON here
+func (s *WhatsAppMeowService) getAccountByDevice(organizationID, accountID, deviceID string) (*models.WhatsAppMeowAccount, error) {
+	if accountID != "" || deviceID == "" {
+		return s.getAccount(organizationID, accountID)
 	}
-	if qrCode.Valid {
-		account.QRCode = &qrCode.String
+
+	accounts, err := s.accounts.ListAccounts(organizationID)
+	if err != nil {
+		return nil, err
 	}
-	if phoneNumber.Valid {
-		account.PhoneNumber = &phoneNumber.String
+	for _, account := range accounts {
+		if account.DeviceID == deviceID {
+			return account, nil
+		}
 	}
-	if displayName.Valid {
-		account.DisplayName = &displayName.String
+	return nil, fmt.Errorf("device %s: %w", deviceID, store.ErrNotFound)
+}
+
+// connectedClient returns the client of a connected account, starting one
+// if the service was restarted since the account connected
+func (s *WhatsAppMeowService) connectedClient(account *models.WhatsAppMeowAccount) (WhatsAppClient, error) {
+	if !account.IsConnected {
+		return nil, fmt.Errorf("account is not connected")
 	}
-	if profilePicture.Valid {
-		account.ProfilePicture = &profilePicture.String
+
+	// Initialize client if needed
+	if client := s.clientFor(account.ID); client != nil {
+		return client, nil
 	}
-	if lastSeen.Valid {
-		account.LastSeen = &lastSeen.Time
+	if err := s.initializeClient(account); err != nil {
+		return nil, fmt.Errorf("failed to initialize client: %w", err)
 	}
 
-	return &account, nil
+	return s.clientFor(account.ID), nil
 }
 
-func (s *WhatsAppMeowService) initializeClient(account *models.WhatsAppMeowAccount) error {
-	// Initialize device store
-	deviceStore, err := sqlstore.New(context.Background(), "postgres", s.config.DatabaseURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create device store: %w", err)
+func (s *WhatsAppMeowService) clientFor(accountID string) WhatsAppClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clients[accountID]
+}
+
+func (s *WhatsAppMeowService) closeClient(accountID string) {
+	s.mu.Lock()
+	client := s.clients[accountID]
+	delete(s.clients, accountID)
+	s.mu.Unlock()
+
+	if client != nil {
+		client.Disconnect()
 	}
+}
 
-	// Get or create device
-	deviceJID := types.JID{User: account.DeviceID, Server: "s.whatsapp.net"}
-	device, err := deviceStore.GetDevice(context.Background(), deviceJID)
+func (s *WhatsAppMeowService) initializeClient(account *models.WhatsAppMeowAccount) error {
+	// Create client
+	client, err := s.newClient(account)
 	if err != nil {
-		return fmt.Errorf("failed to get device: %w", err)
+		return err
 	}
 
-	// Create client
-	client := whatsmeow.NewClient(device, nil)
-	
 	// Set up event handlers
-	client.AddEventHandler(s.eventHandler)
+	accountID := account.ID
+	client.AddEventHandler(func(evt interface{}) {
+		s.eventHandler(accountID, evt)
+	})
+
+	// Unpaired devices need a QR code scanned before they can log in
+	qrChannel, err := client.GetQRChannel(context.Background())
+	if err == nil {
+		go s.consumeQRChannel(accountID, qrChannel)
+	} else if !errors.Is(err, whatsmeow.ErrQRStoreContainsID) {
+		return fmt.Errorf("failed to get QR channel: %w", err)
+	}
+
+	// Register the client before connecting so that event handlers
+	// fired during the handshake can already see it
+	s.closeClient(accountID)
+	s.mu.Lock()
+	s.clients[accountID] = client
+	s.mu.Unlock()
 
 	// Connect
 	if err := client.Connect(); err != nil {
+		s.mu.Lock()
+		delete(s.clients, accountID)
+		s.mu.Unlock()
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
-	s.client = client
 	return nil
 }
 
-func (s *WhatsAppMeowService) eventHandler(evt interface{}) {
+func (s *WhatsAppMeowService) consumeQRChannel(accountID string, qrChannel <-chan whatsmeow.QRChannelItem) {
+	for item := range qrChannel {
+		switch item.Event {
+		case whatsmeow.QRChannelEventCode:
+			s.saveQRCode(accountID, item.Code)
+		case whatsmeow.QRChannelSuccess.Event:
+			log.Printf("Account %s paired successfully", accountID)
+		default:
+			log.Printf("Pairing for account %s ended: %s", accountID, item.Event)
+			if err := s.accounts.UpdateConnectionStatus(accountID, models.ConnectionStatusDisconnected, false); err != nil {
+				log.Printf("Failed to update connection status: %v", err)
+			}
+		}
+	}
+}
+
+func (s *WhatsAppMeowService) eventHandler(accountID string, evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Message:
-		s.handleIncomingMessage(v)
+		s.handleIncomingMessage(accountID, v)
+	case *events.Receipt:
+		s.handleReceipt(accountID, v)
 	case *events.Connected:
-		s.handleConnected()
+		s.handleConnected(accountID)
 	case *events.Disconnected:
-		s.handleDisconnected()
+		s.handleDisconnected(accountID)
 	case *events.LoggedOut:
-		s.handleLoggedOut()
+		s.handleLoggedOut(accountID)
+	case *events.TemporaryBan:
+		s.handleTemporaryBan(accountID, v)
 	case *events.QR:
-		s.handleQRCode(v)
+		s.handleQRCode(accountID, v)
+	case *events.ChatPresence:
+		s.handleChatPresence(accountID, v)
+	case *events.Presence:
+		s.handlePresence(accountID, v)
+	case *events.JoinedGroup:
+		s.handleJoinedGroup(accountID, v)
+	case *events.GroupInfo:
+		s.handleGroupInfo(accountID, v)
 	}
 }
 
-func (s *WhatsAppMeowService) handleIncomingMessage(msg *events.Message) {
-	log.Printf("Received message from %s: %s", msg.Info.Sender, msg.Message.GetConversation())
-	// Handle incoming message logic here
+func (s *WhatsAppMeowService) handleIncomingMessage(accountID string, msg *events.Message) {
+	log.Printf("Received message %s from %s", msg.Info.ID, msg.Info.Sender)
+
+	if reaction := msg.Message.GetReactionMessage(); reaction != nil {
+		s.handleReaction(accountID, msg, reaction)
+		return
+	}
+	if protocol := protocolMessage(msg.Message); protocol != nil {
+		s.handleProtocolMessage(accountID, msg, protocol)
+		return
+	}
+
+	messageType, text, mediaType := describeMessage(msg.Message)
+	latitude, longitude := messageLocation(msg.Message)
+	message := &models.WhatsAppMeowMessage{
+		WhatsAppMeowAccountID: accountID,
+		MessageID:             msg.Info.ID,
+		FromJID:               msg.Info.Sender.ToNonAD().String(),
+		ToJID:                 msg.Info.Chat.ToNonAD().String(),
+		MessageType:           messageType,
+		MessageText:           optionalString(text),
+		MediaType:             optionalString(mediaType),
+		MediaReference:        mediaReference(downloadableMedia(msg.Message)),
+		QuotedMessageID:       optionalString(messageContextInfo(msg.Message).GetStanzaID()),
+		Latitude:              latitude,
+		Longitude:             longitude,
+		IsFromMe:              msg.Info.IsFromMe,
+		IsSent:                true,
+		IsDelivered:           true,
+		Timestamp:             msg.Info.Timestamp,
+	}
+	// Direct messages from contacts are attributed to leads and may opt out
+	var contactAccount *models.WhatsAppMeowAccount
+	if !msg.Info.IsFromMe && !msg.Info.IsGroup {
+		if client := s.clientFor(accountID); client != nil && !client.OwnJID().IsEmpty() {
+			message.ToJID = client.OwnJID().ToNonAD().String()
+		}
+		account, err := s.accounts.GetAccount(accountID)
+		if err != nil {
+			log.Printf("Failed to load account %s: %v", accountID, err)
+		} else {
+			contactAccount = account
+			s.attributeLead(account, msg, message)
+		}
+	}
+
+	if err := s.messages.InsertMessage(message); err != nil {
+		log.Printf("Failed to save incoming message: %v", err)
+		return
+	}
+	s.publishMessage(accountID, contactAccount, message)
+
+	if media := downloadableMedia(msg.Message); media != nil && s.blobs != nil {
+		if client := s.clientFor(accountID); client != nil {
+			go s.storeInboundMedia(client, message, media)
+		}
+	}
+	// Protocol messages nobody typed are neither read nor answered
+	if message.MessageType == models.MessageTypeSystem {
+		return
+	}
+	if !message.IsFromMe {
+		s.readOnReceipt(accountID, contactAccount, message)
+	}
+	if contactAccount != nil && !s.handleOptKeyword(contactAccount, msg, message) {
+		s.autoReply(contactAccount, msg, message)
+	}
 }
 
-func (s *WhatsAppMeowService) handleConnected() {
+func (s *WhatsAppMeowService) handleReceipt(accountID string, receipt *events.Receipt) {
+	var status models.WhatsAppMeowMessageStatus
+	switch receipt.Type {
+	case types.ReceiptTypeDelivered:
+		status = models.MessageStatusDelivered
+	case types.ReceiptTypeRead, types.ReceiptTypeReadSelf, types.ReceiptTypePlayed, types.ReceiptTypePlayedSelf:
+		status = models.MessageStatusRead
+	default:
+		return
+	}
+
+	var updated []string
+	for _, messageID := range receipt.MessageIDs {
+		err := s.messages.UpdateMessageStatus(accountID, messageID, status, receipt.Timestamp)
+		switch {
+		case err == nil:
+			updated = append(updated, messageID)
+		case !errors.Is(err, store.ErrNotFound):
+			log.Printf("Failed to update status of message %s: %v", messageID, err)
+		}
+	}
+	if len(updated) == 0 {
+		return
+	}
+
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+	s.publish(account, models.EventMessageStatus, map[string]interface{}{
+		"messageIds": updated,
+		"status":     status,
+		"chatJid":    receipt.Chat.ToNonAD().String(),
+		"senderJid":  receipt.Sender.ToNonAD().String(),
+		"timestamp":  receipt.Timestamp.UTC(),
+	})
+}
+
+func (s *WhatsAppMeowService) handleConnected(accountID string) {
 	log.Println("Connected to WhatsApp")
+
 	// Update connection status in database
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+
+	now := time.Now()
+	account.IsConnected = true
+	account.IsPaired = true
+	account.QRCode = nil
+	account.LastSeen = &now
+	account.ConnectionStatus = models.ConnectionStatusConnected
+	if client := s.clientFor(accountID); client != nil && !client.OwnJID().IsEmpty() {
+		ownJID := client.OwnJID()
+		account.PhoneNumber = &ownJID.User
+		account.SessionData = &models.SessionData{DeviceID: ownJID.String()}
+	}
+
+	if err := s.accounts.UpdateAccount(account); err != nil {
+		log.Printf("Failed to update account %s: %v", accountID, err)
+		return
+	}
+	s.publish(account, models.EventAccountConnected, account)
 }
 
-func (s *WhatsAppMeowService) handleDisconnected() {
+func (s *WhatsAppMeowService) handleDisconnected(accountID string) {
 	log.Println("Disconnected from WhatsApp")
-	// Update connection status in database
+
+	// Update connection status in database. whatsmeow reconnects on its
+	// own and will emit Connected again once the socket is back.
+	if err := s.accounts.UpdateConnectionStatus(accountID, models.ConnectionStatusDisconnected, false); err != nil {
+		log.Printf("Failed to update connection status: %v", err)
+		return
+	}
+	s.publishAccount(accountID, models.EventAccountDisconnected)
 }
 
-func (s *WhatsAppMeowService) handleLoggedOut() {
+func (s *WhatsAppMeowService) handleLoggedOut(accountID string) {
 	log.Println("Logged out from WhatsApp")
-	// Handle logout logic
+
+	// The device was unlinked from the phone, so there is nothing left to
+	// tell the server; just forget the session locally
+	if client := s.clientFor(accountID); client != nil {
+		if err := client.DeleteDevice(context.Background()); err != nil {
+			log.Printf("Failed to delete device of account %s: %v", accountID, err)
+		}
+	}
+	s.closeClient(accountID)
+
+	if _, err := s.wipeAccount(accountID); err != nil {
+		log.Printf("Failed to reset account %s: %v", accountID, err)
+	}
 }
 
-func (s *WhatsAppMeowService) handleQRCode(qr *events.QR) {
+func (s *WhatsAppMeowService) handleTemporaryBan(accountID string, ban *events.TemporaryBan) {
+	log.Printf("Account %s banned: %s", accountID, ban)
+
+	if err := s.accounts.UpdateConnectionStatus(accountID, models.ConnectionStatusBanned, false); err != nil {
+		log.Printf("Failed to update connection status: %v", err)
+		return
+	}
+	s.publishAccount(accountID, models.EventAccountBanned)
+}
+
+func (s *WhatsAppMeowService) handleQRCode(accountID string, qr *events.QR) {
 	log.Println("QR code received")
-	// Save QR code to database
+
+	if len(qr.Codes) > 0 {
+		s.saveQRCode(accountID, qr.Codes[0])
+	}
+}
+
+func (s *WhatsAppMeowService) saveQRCode(accountID, code string) {
+	account, err := s.accounts.GetAccount(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %s: %v", accountID, err)
+		return
+	}
+
+	account.QRCode = &code
+	account.ConnectionStatus = models.ConnectionStatusPairing
+	if err := s.accounts.UpdateAccount(account); err != nil {
+		log.Printf("Failed to save QR code: %v", err)
+		return
+	}
+	s.publish(account, models.EventAccountQR, account)
+}
+
+func (s *WhatsAppMeowService) buildTextMessage(text string) *waE2E.Message {
+	return &waE2E.Message{
+		Conversation: proto.String(text),
+	}
 }
 
-func (s *WhatsAppMeowService) sendTextMessage(toJID types.JID, text string) (string, error) {
-	// For now, return a placeholder - this needs to be implemented with the correct whatsmeow API
-	return "", fmt.Errorf("text message sending not yet implemented - API needs to be updated")
+func (s *WhatsAppMeowService) buildImageMessage(client WhatsAppClient, organizationID, caption, mediaURL, mediaType string) (*waE2E.Message, error) {
+	media, err := s.prepareMedia(client, organizationID, mediaURL, mediaType, whatsmeow.MediaImage)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &waE2E.ImageMessage{
+		Caption:       optionalString(caption),
+		Mimetype:      proto.String(media.mimeType),
+		URL:           proto.String(media.upload.URL),
+		DirectPath:    proto.String(media.upload.DirectPath),
+		MediaKey:      media.upload.MediaKey,
+		FileEncSHA256: media.upload.FileEncSHA256,
+		FileSHA256:    media.upload.FileSHA256,
+		FileLength:    proto.Uint64(media.upload.FileLength),
+	}
+	if thumb := media.thumbnail; thumb != nil {
+		message.JPEGThumbnail = thumb.jpeg
+		message.Width = proto.Uint32(thumb.width)
+		message.Height = proto.Uint32(thumb.height)
+	}
+
+	return &waE2E.Message{ImageMessage: message}, nil
 }
 
-func (s *WhatsAppMeowService) sendImageMessage(toJID types.JID, caption, mediaURL string) (string, error) {
-	// Implementation for image messages
-	// This would involve downloading the media and uploading to WhatsApp
-	return "", fmt.Errorf("image messages not yet implemented")
+// buildVideoMessage sends a video. The optional thumbnail is taken to be a
+// frame of the video, so its dimensions are reported as the video's.
+func (s *WhatsAppMeowService) buildVideoMessage(client WhatsAppClient, organizationID, caption, mediaURL, mediaType, thumbnailURL string) (*waE2E.Message, error) {
+	thumb, err := s.fetchThumbnail(organizationID, thumbnailURL)
+	if err != nil {
+		return nil, err
+	}
+	media, err := s.prepareMedia(client, organizationID, mediaURL, mediaType, whatsmeow.MediaVideo)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &waE2E.VideoMessage{
+		Caption:       optionalString(caption),
+		Mimetype:      proto.String(media.mimeType),
+		URL:           proto.String(media.upload.URL),
+		DirectPath:    proto.String(media.upload.DirectPath),
+		MediaKey:      media.upload.MediaKey,
+		FileEncSHA256: media.upload.FileEncSHA256,
+		FileSHA256:    media.upload.FileSHA256,
+		FileLength:    proto.Uint64(media.upload.FileLength),
+	}
+	// The preview frame says nothing reliable about the video's own
+	// dimensions, so those are left for the recipient to read from the file
+	if thumb != nil {
+		message.JPEGThumbnail = thumb.jpeg
+	}
+
+	return &waE2E.Message{VideoMessage: message}, nil
 }
 
-func (s *WhatsAppMeowService) sendVideoMessage(toJID types.JID, caption, mediaURL string) (string, error) {
-	// Implementation for video messages
-	return "", fmt.Errorf("video messages not yet implemented")
+func (s *WhatsAppMeowService) buildAudioMessage(client WhatsAppClient, organizationID, mediaURL, mediaType string) (*waE2E.Message, error) {
+	media, err := s.prepareMedia(client, organizationID, mediaURL, mediaType, whatsmeow.MediaAudio)
+	if err != nil {
+		return nil, err
+	}
+
+	return &waE2E.Message{
+		AudioMessage: &waE2E.AudioMessage{
+			Mimetype:      proto.String(media.mimeType),
+			URL:           proto.String(media.upload.URL),
+			DirectPath:    proto.String(media.upload.DirectPath),
+			MediaKey:      media.upload.MediaKey,
+			FileEncSHA256: media.upload.FileEncSHA256,
+			FileSHA256:    media.upload.FileSHA256,
+			FileLength:    proto.Uint64(media.upload.FileLength),
+		},
+	}, nil
 }
 
-func (s *WhatsAppMeowService) sendAudioMessage(toJID types.JID, mediaURL string) (string, error) {
-	// Implementation for audio messages
-	return "", fmt.Errorf("audio messages not yet implemented")
+func (s *WhatsAppMeowService) buildDocumentMessage(client WhatsAppClient, organizationID, caption, mediaURL, mediaType, thumbnailURL string) (*waE2E.Message, error) {
+	thumb, err := s.fetchThumbnail(organizationID, thumbnailURL)
+	if err != nil {
+		return nil, err
+	}
+	media, err := s.prepareMedia(client, organizationID, mediaURL, mediaType, whatsmeow.MediaDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &waE2E.DocumentMessage{
+		Caption:       optionalString(caption),
+		FileName:      proto.String(media.fileName),
+		Title:         proto.String(media.fileName),
+		Mimetype:      proto.String(media.mimeType),
+		URL:           proto.String(media.upload.URL),
+		DirectPath:    proto.String(media.upload.DirectPath),
+		MediaKey:      media.upload.MediaKey,
+		FileEncSHA256: media.upload.FileEncSHA256,
+		FileSHA256:    media.upload.FileSHA256,
+		FileLength:    proto.Uint64(media.upload.FileLength),
+	}
+	if thumb != nil {
+		message.JPEGThumbnail = thumb.jpeg
+		message.ThumbnailWidth = proto.Uint32(thumb.thumbWidth)
+		message.ThumbnailHeight = proto.Uint32(thumb.thumbHeight)
+	}
+
+	return &waE2E.Message{DocumentMessage: message}, nil
 }
 
-func (s *WhatsAppMeowService) sendDocumentMessage(toJID types.JID, caption, mediaURL string) (string, error) {
-	// Implementation for document messages
-	return "", fmt.Errorf("document messages not yet implemented")
+func (s *WhatsAppMeowService) send(client WhatsAppClient, toJID types.JID, message *waE2E.Message) (string, error) {
+	resp, err := client.SendMessage(context.Background(), toJID, message)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+func (s *WhatsAppMeowService) saveMessage(client WhatsAppClient, accountID string, toJID types.JID, req models.SendMessageRequest, sent *waE2E.Message, messageID string) error {
+	now := time.Now()
+	_, text, mimeType := describeMessage(sent)
+	if mimeType == "" {
+		mimeType = req.MediaType
+	}
+	latitude, longitude := messageLocation(sent)
+	message := &models.WhatsAppMeowMessage{
+		WhatsAppMeowAccountID: accountID,
+		MessageID:             messageID,
+		FromJID:               client.OwnJID().ToNonAD().String(),
+		ToJID:                 toJID.ToNonAD().String(),
+		MessageType:           models.WhatsAppMeowMessageType(strings.ToUpper(req.MessageType)),
+		MessageText:           optionalString(text),
+		MediaURL:              optionalString(req.MediaURL),
+		MediaType:             optionalString(mimeType),
+		LeadID:                optionalString(req.LeadID),
+		QuotedMessageID:       optionalString(req.QuotedMessageID),
+		TemplateID:            optionalString(req.TemplateID),
+		Latitude:              latitude,
+		Longitude:             longitude,
+		IsSent:                true,
+		Timestamp:             now,
+		IsFromMe:              true,
+		SentAt:                &now,
+	}
+
+	return s.messages.InsertMessage(message)
 }
 
-func (s *WhatsAppMeowService) saveMessage(accountID string, req models.SendMessageRequest, messageID string) error {
-	query := `
-		INSERT INTO "WhatsAppMeowMessage" 
-		(whats_app_meow_account_id, message_id, lead_id, from_jid, to_jid, message_type, message_text, is_sent, timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`
-	
-	_, err := s.db.Exec(query, 
-		accountID, 
-		messageID, 
-		req.LeadID, 
-		"", // fromJID - will be set by the client
-		req.ToJID, 
-		req.MessageType, 
-		req.MessageText, 
-		true, 
-		time.Now(),
-	)
-	
-	return err
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
 }
@@ -0,0 +1,1808 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/config"
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+var (
+	testOwnJID  = types.NewJID("15550000001", types.DefaultUserServer)
+	testLeadJID = types.NewJID("15550000002", types.DefaultUserServer)
+)
+
+func newTestService(t *testing.T) (*WhatsAppMeowService, *store.Memory, *FakeClient, *models.WhatsAppMeowAccount) {
+	t.Helper()
+
+	st := store.NewMemory()
+	account := &models.WhatsAppMeowAccount{
+		OrganizationID:   "org_1",
+		DeviceID:         "device_1",
+		IsConnected:      true,
+		IsPaired:         true,
+		ConnectionStatus: models.ConnectionStatusConnected,
+	}
+	if err := st.CreateAccount(account); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	fake := NewFakeClient(testOwnJID)
+	svc := NewWhatsAppMeowService(&config.Config{MediaAllowPrivateNetworks: true}, st, fake.Factory())
+	return svc, st, fake, account
+}
+
+func TestSendTextMessage(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "text",
+		MessageText:    "Hello",
+		LeadID:         "lead_1",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	sent := fake.SentMessages()
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 sent message, got %d", len(sent))
+	}
+	if sent[0].To != testLeadJID || sent[0].Message.GetConversation() != "Hello" {
+		t.Errorf("unexpected sent message: %+v", sent[0])
+	}
+	if sent[0].ID != messageID {
+		t.Errorf("expected message ID %s, got %s", sent[0].ID, messageID)
+	}
+
+	stored, err := st.GetMessage(account.ID, messageID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if stored.WhatsAppMeowAccountID != account.ID || stored.MessageType != models.MessageTypeText {
+		t.Errorf("unexpected stored message: %+v", stored)
+	}
+	if stored.FromJID != testOwnJID.String() || stored.LeadID == nil || *stored.LeadID != "lead_1" {
+		t.Errorf("unexpected stored sender or lead: %+v", stored)
+	}
+}
+
+func TestSendImageMessageUploadsMedia(t *testing.T) {
+	svc, _, fake, _ := newTestService(t)
+
+	image := []byte("\x89PNG\r\n\x1a\nfake image")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image)
+	}))
+	defer server.Close()
+
+	_, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "image",
+		MessageText:    "Brochure",
+		MediaURL:       server.URL + "/brochure.png",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if uploads := fake.Uploads(); len(uploads) != 1 || string(uploads[0]) != string(image) {
+		t.Fatalf("expected the image to be uploaded once, got %d uploads", len(uploads))
+	}
+	imageMessage := fake.SentMessages()[0].Message.GetImageMessage()
+	if imageMessage.GetCaption() != "Brochure" || imageMessage.GetMimetype() != "image/png" {
+		t.Errorf("unexpected image message: %v", imageMessage)
+	}
+	if imageMessage.GetFileLength() != uint64(len(image)) {
+		t.Errorf("expected file length %d, got %d", len(image), imageMessage.GetFileLength())
+	}
+}
+
+func TestMediaMessagesCarryThumbnails(t *testing.T) {
+	svc, _, fake, _ := newTestService(t)
+
+	photo := image.NewNRGBA(image.Rect(0, 0, 640, 320))
+	draw.Draw(photo, photo.Rect, image.NewUniform(color.NRGBA{R: 200, A: 255}), image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, photo); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	files := map[string][]byte{
+		"/photo.png":    encoded.Bytes(),
+		"/brochure.pdf": []byte("%PDF-1.4 brochure"),
+		"/clip.mp4":     []byte("\x00\x00\x00\x18ftypmp42"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[r.URL.Path])
+	}))
+	defer server.Close()
+
+	send := func(req models.SendMessageRequest) (*waE2E.Message, error) {
+		t.Helper()
+		req.OrganizationID, req.ToJID = "org_1", testLeadJID.String()
+		if _, err := svc.SendMessage(req); err != nil {
+			return nil, err
+		}
+		sent := fake.SentMessages()
+		return sent[len(sent)-1].Message, nil
+	}
+
+	sent, err := send(models.SendMessageRequest{MessageType: "image", MediaURL: server.URL + "/photo.png"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	imageMessage := sent.GetImageMessage()
+	if imageMessage.GetWidth() != 640 || imageMessage.GetHeight() != 320 {
+		t.Errorf("expected 640x320, got %dx%d", imageMessage.GetWidth(), imageMessage.GetHeight())
+	}
+	preview, format, err := image.DecodeConfig(bytes.NewReader(imageMessage.GetJPEGThumbnail()))
+	if err != nil || format != "jpeg" || preview.Width != thumbnailSize || preview.Height != thumbnailSize/2 {
+		t.Errorf("unexpected thumbnail %s %dx%d (%v)", format, preview.Width, preview.Height, err)
+	}
+
+	sent, err = send(models.SendMessageRequest{MessageType: "document", MediaURL: server.URL + "/brochure.pdf", ThumbnailURL: server.URL + "/photo.png"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	document := sent.GetDocumentMessage()
+	if len(document.GetJPEGThumbnail()) == 0 || document.GetThumbnailWidth() != thumbnailSize || document.GetThumbnailHeight() != thumbnailSize/2 {
+		t.Errorf("unexpected document thumbnail: %dx%d", document.GetThumbnailWidth(), document.GetThumbnailHeight())
+	}
+
+	sent, err = send(models.SendMessageRequest{MessageType: "video", MediaURL: server.URL + "/clip.mp4", ThumbnailURL: server.URL + "/photo.png"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if video := sent.GetVideoMessage(); len(video.GetJPEGThumbnail()) == 0 || video.Width != nil || video.Height != nil {
+		t.Errorf("expected a video thumbnail without dimensions taken from it, got %dx%d", video.GetWidth(), video.GetHeight())
+	}
+
+	_, err = send(models.SendMessageRequest{MessageType: "document", MediaURL: server.URL + "/brochure.pdf", ThumbnailURL: server.URL + "/brochure.pdf"})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected a thumbnail that is not an image to be refused, got %v", err)
+	}
+}
+
+func TestMediaCacheReusesUploads(t *testing.T) {
+	svc, _, fake, _ := newTestService(t)
+	svc.mediaCache = newMediaCache(time.Hour, 2)
+
+	files := map[string][]byte{
+		"/brochure.pdf": []byte("%PDF-1.4 brochure"),
+		"/copy.pdf":     []byte("%PDF-1.4 brochure"),
+		"/price.pdf":    []byte("%PDF-1.4 price list"),
+		"/terms.pdf":    []byte("%PDF-1.4 terms"),
+	}
+	var mu sync.Mutex
+	bodies := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := files[r.URL.Path]
+		etag := fmt.Sprintf(`"%x"`, len(r.URL.Path))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		mu.Lock()
+		bodies++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	send := func(path string) *waE2E.DocumentMessage {
+		t.Helper()
+		_, err := svc.SendMessage(models.SendMessageRequest{
+			OrganizationID: "org_1",
+			ToJID:          testLeadJID.String(),
+			MessageType:    "document",
+			MediaURL:       server.URL + path,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		sent := fake.SentMessages()
+		return sent[len(sent)-1].Message.GetDocumentMessage()
+	}
+
+	first := send("/brochure.pdf")
+	second := send("/brochure.pdf")
+	if len(fake.Uploads()) != 1 || bodies != 1 {
+		t.Fatalf("expected one download and upload, got %d and %d", bodies, len(fake.Uploads()))
+	}
+	if second.GetDirectPath() != first.GetDirectPath() || !bytes.Equal(second.GetMediaKey(), first.GetMediaKey()) {
+		t.Errorf("expected the cached upload to be reused")
+	}
+
+	// The same content from another URL is matched by its hash
+	if copied := send("/copy.pdf"); copied.GetDirectPath() != first.GetDirectPath() || copied.GetFileName() != "copy.pdf" {
+		t.Errorf("expected the upload to be reused under the new name, got %v", copied)
+	}
+	if len(fake.Uploads()) != 1 {
+		t.Fatalf("expected identical content not to be uploaded again")
+	}
+
+	// Filling the cache evicts the least recently used upload
+	send("/price.pdf")
+	send("/terms.pdf")
+	send("/brochure.pdf")
+	if uploads := len(fake.Uploads()); uploads != 4 {
+		t.Errorf("expected the evicted brochure to be uploaded again, got %d uploads", uploads)
+	}
+}
+
+func TestSendStickerConvertsToWebP(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 300, 150))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{R: 200, A: 255}), image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, src); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "sticker",
+		MediaURL:       server.URL + "/logo.png",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	sticker := fake.SentMessages()[0].Message.GetStickerMessage()
+	if sticker.GetMimetype() != "image/webp" || sticker.GetWidth() != 512 || sticker.GetHeight() != 512 {
+		t.Fatalf("unexpected sticker message: %v", sticker)
+	}
+	upload := fake.Uploads()[0]
+	config, format, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil || format != "webp" || config.Width != 512 || config.Height != 512 {
+		t.Errorf("expected a 512x512 WebP upload, got %s %dx%d (%v)", format, config.Width, config.Height, err)
+	}
+	if len(upload) > maxStickerSize {
+		t.Errorf("sticker of %d bytes exceeds the limit", len(upload))
+	}
+	if stored, _ := st.GetMessage(account.ID, messageID); stored.MessageType != models.MessageTypeSticker || *stored.MediaType != "image/webp" {
+		t.Errorf("unexpected stored sticker: %+v", stored)
+	}
+
+	// A small PNG declaring a huge canvas is refused before it is decoded
+	huge := bytes.Clone(encoded.Bytes())
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err := convertSticker(huge); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected an oversized sticker to be refused, got %v", err)
+	} else if !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected the size check to refuse it, got %v", err)
+	}
+
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{
+		StickerMessage: &waE2E.StickerMessage{
+			Mimetype:   proto.String("image/webp"),
+			DirectPath: proto.String("/v/t62/sticker"),
+			MediaKey:   []byte("key"),
+		},
+	})
+	stored, _ := st.GetMessage(account.ID, "INBOUND1")
+	if stored.MessageType != models.MessageTypeSticker || stored.MediaReference == nil || stored.MediaReference.DirectPath != "/v/t62/sticker" {
+		t.Errorf("expected the inbound sticker with its media reference, got %+v", stored)
+	}
+}
+
+func TestInboundMediaIsDownloadedAndServedBySignedLinks(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	blobs, err := store.NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileBlobStore: %v", err)
+	}
+	svc.UseMediaStore(blobs, []byte("secret"))
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	photo := []byte("jpeg bytes")
+	fake.Downloads["/v/t62/photo"] = photo
+	fake.EmitMessage(testLeadJID, "INBOUND_PHOTO", &waE2E.Message{
+		ImageMessage: &waE2E.ImageMessage{
+			Mimetype:   proto.String("image/jpeg"),
+			DirectPath: proto.String("/v/t62/photo"),
+			MediaKey:   []byte("key"),
+		},
+	})
+	waitFor(t, func() bool {
+		stored, _ := st.GetMessage(account.ID, "INBOUND_PHOTO")
+		return stored != nil && stored.MediaURL != nil
+	})
+	stored, _ := st.GetMessage(account.ID, "INBOUND_PHOTO")
+	if *stored.MediaType != "image/jpeg" || stored.MediaReference == nil {
+		t.Errorf("unexpected stored media: %+v", stored)
+	}
+
+	// Attachments over the limit are not downloaded
+	svc.config.MediaInboundMaxBytes = 1 << 20
+	fake.Downloads["/v/t62/huge"] = []byte("pdf bytes")
+	document := &waE2E.DocumentMessage{
+		Mimetype:   proto.String("application/pdf"),
+		DirectPath: proto.String("/v/t62/huge"),
+		MediaKey:   []byte("key"),
+		FileLength: proto.Uint64(500 << 20),
+	}
+	fake.EmitMessage(testLeadJID, "INBOUND_HUGE", &waE2E.Message{DocumentMessage: document})
+	huge, err := st.GetMessage(account.ID, "INBOUND_HUGE")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	svc.storeInboundMedia(fake, huge, document)
+	if huge, err := st.GetMessage(account.ID, "INBOUND_HUGE"); err != nil || huge.MediaURL != nil || huge.MediaReference == nil || huge.MediaReference.FileLength != 500<<20 {
+		t.Errorf("expected the oversized document to keep only its reference, got %+v (%v)", huge, err)
+	}
+
+	if _, _, err := svc.MediaLink("org_2", "", "INBOUND_PHOTO"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected another organization to get not found, got %v", err)
+	}
+	link, expiresAt, err := svc.MediaLink("org_1", "", "INBOUND_PHOTO")
+	if err != nil {
+		t.Fatalf("MediaLink: %v", err)
+	}
+	if !expiresAt.After(time.Now()) {
+		t.Errorf("expected the link to expire in the future, got %v", expiresAt)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Path != MediaDownloadPath {
+		t.Fatalf("unexpected link %q", link)
+	}
+	query := parsed.Query()
+
+	data, mimeType, err := svc.OpenMedia(query.Get("accountId"), query.Get("messageId"), query.Get("expires"), query.Get("signature"))
+	if err != nil || !bytes.Equal(data, photo) || mimeType != "image/jpeg" {
+		t.Fatalf("OpenMedia = %q, %q, %v", data, mimeType, err)
+	}
+	if _, _, err := svc.OpenMedia(account.ID, "INBOUND_PHOTO", query.Get("expires"), "forged"); !errors.Is(err, ErrMediaLinkInvalid) {
+		t.Errorf("expected a forged signature to be refused, got %v", err)
+	}
+	expired := time.Now().Add(-time.Minute).Unix()
+	_, _, err = svc.OpenMedia(account.ID, "INBOUND_PHOTO", strconv.FormatInt(expired, 10), svc.signMediaLink(account.ID, "INBOUND_PHOTO", expired))
+	if !errors.Is(err, ErrMediaLinkInvalid) {
+		t.Errorf("expected an expired link to be refused, got %v", err)
+	}
+}
+
+func TestSendLocationAndContacts(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "location",
+		Location:       &models.Location{Latitude: 52.52, Longitude: 13.405, Name: "Office", Address: "Unter den Linden 1"},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	location := fake.SentMessages()[0].Message.GetLocationMessage()
+	if location.GetDegreesLatitude() != 52.52 || location.GetName() != "Office" {
+		t.Errorf("unexpected location message: %v", location)
+	}
+	stored, _ := st.GetMessage(account.ID, messageID)
+	if stored.MessageType != models.MessageTypeLocation || stored.Latitude == nil || *stored.Longitude != 13.405 {
+		t.Errorf("unexpected stored location: %+v", stored)
+	}
+
+	_, err = svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "contact",
+		Contacts: []models.ContactCard{
+			{Name: "Jane Doe", Phones: []string{"+1 555 010 0001"}},
+			{Name: "Sales; EU", Emails: []string{"eu@example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	contacts := fake.SentMessages()[1].Message.GetContactsArrayMessage().GetContacts()
+	if len(contacts) != 2 || !strings.Contains(contacts[0].GetVcard(), "waid=15550100001:+1 555 010 0001") {
+		t.Fatalf("unexpected contacts: %v", contacts)
+	}
+	if !strings.Contains(contacts[1].GetVcard(), `FN:Sales\; EU`) {
+		t.Errorf("expected vCard values to be escaped: %s", contacts[1].GetVcard())
+	}
+
+	_, err = svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "contact",
+		Contacts:       []models.ContactCard{{Name: "Nobody"}},
+	})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected ErrInvalidMessage, got %v", err)
+	}
+
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{
+		LocationMessage: &waE2E.LocationMessage{
+			DegreesLatitude:  proto.Float64(48.85),
+			DegreesLongitude: proto.Float64(2.35),
+			Name:             proto.String("Cafe"),
+		},
+	})
+	if stored, _ := st.GetMessage(account.ID, "INBOUND1"); stored.Latitude == nil || *stored.Latitude != 48.85 || *stored.MessageText != "Cafe" {
+		t.Errorf("unexpected inbound location: %+v", stored)
+	}
+}
+
+func TestSendMessageFailures(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	fake.SendErr = errors.New("boom")
+	_, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "text",
+		MessageText:    "Hello",
+	})
+	if err == nil {
+		t.Fatal("expected send error")
+	}
+
+	if err := st.UpdateConnectionStatus(account.ID, models.ConnectionStatusDisconnected, false); err != nil {
+		t.Fatalf("UpdateConnectionStatus: %v", err)
+	}
+	fake.SendErr = nil
+	_, err = svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "text",
+		MessageText:    "Hello",
+	})
+	if err == nil {
+		t.Fatal("expected error for disconnected account")
+	}
+	if len(fake.SentMessages()) != 0 {
+		t.Errorf("expected nothing to be sent")
+	}
+}
+
+func TestReceiptsAdvanceMessageStatus(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "text",
+		MessageText:    "Hello",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	fake.EmitReceipt(testLeadJID, types.ReceiptTypeRead, messageID)
+	fake.EmitReceipt(testLeadJID, types.ReceiptTypeDelivered, messageID)
+
+	stored, err := st.GetMessage(account.ID, messageID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if !stored.IsDelivered || !stored.IsRead || stored.ReadAt == nil {
+		t.Errorf("expected message to be delivered and read: %+v", stored)
+	}
+}
+
+func TestIncomingMessageIsStored(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{
+		Conversation: proto.String("Is this still available?"),
+	})
+
+	stored, err := st.GetMessage(account.ID, "INBOUND1")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if stored.FromJID != testLeadJID.String() || stored.ToJID != testOwnJID.String() {
+		t.Errorf("unexpected direction: from %s to %s", stored.FromJID, stored.ToJID)
+	}
+	if stored.MessageText == nil || *stored.MessageText != "Is this still available?" {
+		t.Errorf("unexpected text: %v", stored.MessageText)
+	}
+}
+
+func TestInboundMessagesAreMatchedToLeads(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	notifier := &recordingNotifier{}
+	svc.AddNotifier(notifier)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	st.AddLead("org_1", "lead_by_phone", "+1 (555) 000-0003")
+	st.AddLead("org_2", "other_org", "15550000004")
+
+	if _, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "text",
+		MessageText:    "Hello",
+		LeadID:         "lead_contacted",
+	}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	phoneJID := types.NewJID("15550000003", types.DefaultUserServer)
+	fake.EmitMessage(testLeadJID, "FROM_CONTACTED", &waE2E.Message{Conversation: proto.String("Hi")})
+	fake.EmitMessage(phoneJID, "FROM_PHONE", &waE2E.Message{Conversation: proto.String("Hi")})
+	// Senders hidden behind a LID are matched by their alternate phone JID
+	lid := types.NewJID("90000000000001", types.HiddenUserServer)
+	fake.Emit(&events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: lid, Sender: lid, SenderAlt: phoneJID},
+			ID:            "FROM_LID",
+			Timestamp:     time.Now(),
+		},
+		Message: &waE2E.Message{Conversation: proto.String("Hi")},
+	})
+	fake.EmitMessage(types.NewJID("15550000004", types.DefaultUserServer), "FROM_STRANGER", &waE2E.Message{Conversation: proto.String("Hi")})
+
+	for messageID, want := range map[string]string{
+		"FROM_CONTACTED": "lead_contacted",
+		"FROM_PHONE":     "lead_by_phone",
+		"FROM_LID":       "lead_by_phone",
+		"FROM_STRANGER":  "",
+	} {
+		stored, err := st.GetMessage(account.ID, messageID)
+		if err != nil {
+			t.Fatalf("GetMessage %s: %v", messageID, err)
+		}
+		var got string
+		if stored.LeadID != nil {
+			got = *stored.LeadID
+		}
+		if got != want {
+			t.Errorf("%s: expected lead %q, got %q", messageID, want, got)
+		}
+	}
+
+	var unknown []models.Event
+	for _, event := range notifier.events {
+		if event.Type == models.EventContactUnknown {
+			unknown = append(unknown, event)
+		}
+	}
+	if len(unknown) != 1 {
+		t.Fatalf("expected one contact.unknown event, got %v", notifier.types())
+	}
+	data := unknown[0].Data.(map[string]interface{})
+	if data["phoneNumber"] != "15550000004" || data["messageId"] != types.MessageID("FROM_STRANGER") {
+		t.Errorf("unexpected contact.unknown data: %v", data)
+	}
+}
+
+func TestOptOutKeywordsSuppressContacts(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	svc.config.OptOutReply = "You will not hear from us again"
+	notifier := &recordingNotifier{}
+	svc.AddNotifier(notifier)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	st.AddLead("org_1", "lead_1", testLeadJID.User)
+	send := func() error {
+		_, err := svc.SendMessage(models.SendMessageRequest{
+			OrganizationID: "org_1",
+			ToJID:          testLeadJID.String(),
+			MessageType:    "text",
+			MessageText:    "Still interested?",
+		})
+		return err
+	}
+
+	// Keywords only count as the whole message
+	fake.EmitMessage(testLeadJID, "NOT_A_KEYWORD", &waE2E.Message{Conversation: proto.String("Please don't stop")})
+	if err := send(); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	fake.EmitMessage(testLeadJID, "OPT_OUT", &waE2E.Message{Conversation: proto.String(" Arrêt ! ")})
+	waitFor(t, func() bool { return len(fake.SentMessages()) == 2 })
+	if got := fake.SentMessages()[1].Message.GetConversation(); got != "You will not hear from us again" {
+		t.Errorf("expected a confirmation, got %q", got)
+	}
+
+	err := send()
+	var optedOut *OptedOutError
+	if !errors.As(err, &optedOut) || optedOut.ErrorCode() != ErrCodeRecipientOptedOut {
+		t.Fatalf("expected the send to be refused, got %v", err)
+	}
+	if suppressed, _ := st.IsSuppressed("org_2", testLeadJID.String()); suppressed {
+		t.Errorf("expected the opt-out to stay within the organization")
+	}
+
+	fake.EmitMessage(testLeadJID, "OPT_IN", &waE2E.Message{Conversation: proto.String("start")})
+	if err := send(); err != nil {
+		t.Fatalf("expected sends to resume after opting in, got %v", err)
+	}
+
+	var keywords []models.EventType
+	for _, event := range notifier.events {
+		if event.Type == models.EventContactOptedOut || event.Type == models.EventContactOptedIn {
+			keywords = append(keywords, event.Type)
+			if data := event.Data.(map[string]interface{}); *data["leadId"].(*string) != "lead_1" {
+				t.Errorf("expected %s to name the lead, got %v", event.Type, data)
+			}
+		}
+	}
+	if len(keywords) != 2 || keywords[0] != models.EventContactOptedOut || keywords[1] != models.EventContactOptedIn {
+		t.Errorf("expected opt-out and opt-in events, got %v", keywords)
+	}
+}
+
+func TestAutoReplyRules(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	st.AddLead("org_1", "lead_1", testLeadJID.User)
+
+	weekdays := &models.BusinessHours{
+		TimeZone: "Europe/Madrid",
+		Windows:  []models.BusinessHoursWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}},
+	}
+	for _, req := range []models.AutoReplyRuleRequest{
+		{Name: "Pricing", Priority: 1, MatchType: models.AutoReplyMatchRegex, Pattern: `(?i)\b(price|cost)s?\b`, CooldownSeconds: 3600,
+			Response: models.AutoReplyResponse{MessageType: "text", MessageText: "Hi {{contact.phone}}, see our pricing page"}},
+		{Name: "Welcome", Priority: 2, MatchType: models.AutoReplyMatchFirstMessage,
+			Response: models.AutoReplyResponse{MessageType: "text", MessageText: "Welcome!"}},
+		{Name: "Closed", Priority: 3, MatchType: models.AutoReplyMatchAny, Schedule: models.AutoReplyOutsideBusinessHours, BusinessHours: weekdays,
+			Response: models.AutoReplyResponse{MessageType: "text", MessageText: "We are closed"}},
+	} {
+		req.OrganizationID = "org_1"
+		if _, err := svc.CreateAutoReplyRule(req); err != nil {
+			t.Fatalf("CreateAutoReplyRule %s: %v", req.Name, err)
+		}
+	}
+
+	for name, req := range map[string]models.AutoReplyRuleRequest{
+		"bad regex":      {MatchType: models.AutoReplyMatchRegex, Pattern: "(", Response: models.AutoReplyResponse{MessageType: "text", MessageText: "Hi"}},
+		"no hours":       {MatchType: models.AutoReplyMatchAny, Schedule: models.AutoReplyDuringBusinessHours, Response: models.AutoReplyResponse{MessageType: "text", MessageText: "Hi"}},
+		"bad day":        {MatchType: models.AutoReplyMatchAny, Schedule: models.AutoReplyDuringBusinessHours, BusinessHours: &models.BusinessHours{Windows: []models.BusinessHoursWindow{{Days: []string{"someday"}, Start: "09:00", End: "17:00"}}}, Response: models.AutoReplyResponse{MessageType: "text", MessageText: "Hi"}},
+		"unsafe media":   {MatchType: models.AutoReplyMatchAny, Response: models.AutoReplyResponse{MessageType: "image", MediaURL: "file:///etc/passwd"}},
+		"empty response": {MatchType: models.AutoReplyMatchAny, Response: models.AutoReplyResponse{MessageType: "text"}},
+	} {
+		req.OrganizationID = "org_1"
+		if _, err := svc.CreateAutoReplyRule(req); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("%s: expected ErrInvalidRule, got %v", name, err)
+		}
+	}
+	if _, err := svc.ListAutoReplyRules("org_2", account.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected another organization's rules to be hidden, got %v", err)
+	}
+
+	// The pricing rule answers once per cooldown; the welcome rule only
+	// answers contacts writing for the first time
+	fake.EmitMessage(testLeadJID, "ASK1", &waE2E.Message{Conversation: proto.String("What are your prices?")})
+	waitFor(t, func() bool { return len(fake.SentMessages()) == 1 })
+	if got := fake.SentMessages()[0].Message.GetConversation(); got != "Hi 15550000002, see our pricing page" {
+		t.Errorf("unexpected pricing reply %q", got)
+	}
+	fromMe := true
+	replies, err := st.ListMessages(store.MessageFilter{ToJID: testLeadJID.String(), FromMe: &fromMe})
+	if err != nil || len(replies) != 1 || replies[0].LeadID == nil || *replies[0].LeadID != "lead_1" {
+		t.Errorf("expected the reply to be stored for the lead, got %v (%v)", replies, err)
+	}
+
+	dryRun, err := svc.TestAutoReply(models.AutoReplyTestRequest{OrganizationID: "org_1", MessageText: "Cost?", FromJID: testLeadJID.String()})
+	if err != nil {
+		t.Fatalf("TestAutoReply: %v", err)
+	}
+	// Whether the closed rule fires depends on when the test runs
+	if len(dryRun.Evaluations) < 2 || !strings.HasPrefix(dryRun.Evaluations[0].Reason, "cooling down") ||
+		dryRun.Evaluations[1].Reason != "contact has written before" {
+		t.Errorf("expected the cooldown and history to hold the rules back, got %+v", dryRun)
+	}
+
+	// A disappearing-messages change is not written by the contact, so it
+	// is not welcomed
+	silent := types.NewJID("15550000006", types.DefaultUserServer)
+	fake.EmitMessage(silent, "EPHEMERAL1", &waE2E.Message{ProtocolMessage: &waE2E.ProtocolMessage{
+		Type:                waE2E.ProtocolMessage_EPHEMERAL_SETTING.Enum(),
+		EphemeralExpiration: proto.Uint32(86400),
+	}})
+	newcomer := types.NewJID("15550000005", types.DefaultUserServer)
+	fake.EmitMessage(newcomer, "HELLO", &waE2E.Message{Conversation: proto.String("Hello")})
+	waitFor(t, func() bool { return len(fake.SentMessages()) >= 2 })
+	if sent := fake.SentMessages(); len(sent) != 2 || sent[1].To != newcomer || sent[1].Message.GetConversation() != "Welcome!" {
+		t.Errorf("expected only a welcome to the newcomer, got %d messages, the last %q to %s", len(sent), sent[len(sent)-1].Message.GetConversation(), sent[len(sent)-1].To)
+	}
+
+	// Business hours are kept in the rule's time zone
+	for at, want := range map[string]string{
+		"2026-10-17T10:00:00Z": "Closed", // Saturday
+		"2026-10-19T06:30:00Z": "Closed", // Monday 08:30 in Madrid
+		"2026-10-19T08:00:00Z": "",       // Monday 10:00 in Madrid
+	} {
+		timestamp, _ := time.Parse(time.RFC3339, at)
+		dryRun, err := svc.TestAutoReply(models.AutoReplyTestRequest{
+			OrganizationID: "org_1",
+			MessageText:    "Anyone there?",
+			FromJID:        testLeadJID.String(),
+			Timestamp:      &timestamp,
+		})
+		if err != nil {
+			t.Fatalf("TestAutoReply: %v", err)
+		}
+		var got string
+		if dryRun.Rule != nil {
+			got = dryRun.Rule.Name
+		}
+		if got != want {
+			t.Errorf("%s: expected rule %q, got %q (%+v)", at, want, got, dryRun.Evaluations)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	template, err := svc.CreateTemplate(models.TemplateRequest{
+		OrganizationID: "org_1",
+		Name:           "welcome",
+		Defaults:       map[string]string{"company": "Acme"},
+		Variants: []models.TemplateVariant{
+			{Language: "en", MessageType: "text", MessageText: "Hi {{lead.firstName|there}}, welcome to {{company}}!"},
+			{Language: "pt", MessageType: "text", MessageText: "Olá {{ lead.firstName }}, bem-vindo à {{company}}!"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTemplate: %v", err)
+	}
+	if template.DefaultLanguage != "en" {
+		t.Errorf("expected the first variant to be the default, got %q", template.DefaultLanguage)
+	}
+
+	text := func(variant models.TemplateVariant) models.TemplateRequest {
+		return models.TemplateRequest{OrganizationID: "org_1", Name: "other", Variants: []models.TemplateVariant{variant}}
+	}
+	for name, req := range map[string]models.TemplateRequest{
+		"taken name":   {OrganizationID: "org_1", Name: "Welcome", Variants: []models.TemplateVariant{{Language: "en", MessageType: "text", MessageText: "Hi"}}},
+		"no variants":  {OrganizationID: "org_1", Name: "other"},
+		"bad language": text(models.TemplateVariant{Language: "english!", MessageType: "text", MessageText: "Hi"}),
+		"malformed":    text(models.TemplateVariant{Language: "en", MessageType: "text", MessageText: "Hi {{lead.first name}}"}),
+		"unsafe media": text(models.TemplateVariant{Language: "en", MessageType: "image", MediaURL: "file:///etc/passwd"}),
+		"unknown type": text(models.TemplateVariant{Language: "en", MessageType: "poll", MessageText: "Hi"}),
+		"default":      {OrganizationID: "org_1", Name: "other", DefaultLanguage: "fr", Variants: []models.TemplateVariant{{Language: "en", MessageType: "text", MessageText: "Hi"}}},
+		"duplicate":    {OrganizationID: "org_1", Name: "other", Variants: []models.TemplateVariant{{Language: "en", MessageType: "text", MessageText: "Hi"}, {Language: "EN", MessageType: "text", MessageText: "Hey"}}},
+	} {
+		if _, err := svc.CreateTemplate(req); !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("%s: expected ErrInvalidTemplate, got %v", name, err)
+		}
+	}
+
+	// Variables override the defaults, and languages fall back to their base
+	// and then to the default language
+	for i, tc := range []struct {
+		language  string
+		variables map[string]string
+		want      string
+	}{
+		{"", nil, "Hi there, welcome to Acme!"},
+		{"pt-BR", map[string]string{"lead.firstName": "Ana"}, "Olá Ana, bem-vindo à Acme!"},
+		{"de", map[string]string{"lead.firstName": "Max", "company": "Initech"}, "Hi Max, welcome to Initech!"},
+	} {
+		messageID, err := svc.SendMessage(models.SendMessageRequest{
+			OrganizationID: "org_1",
+			ToJID:          testLeadJID.String(),
+			TemplateID:     template.ID,
+			Variables:      tc.variables,
+			Language:       tc.language,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage %q: %v", tc.language, err)
+		}
+		if got := fake.SentMessages()[i].Message.GetConversation(); got != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.language, tc.want, got)
+		}
+		if stored, _ := st.GetMessage(account.ID, messageID); stored.TemplateID == nil || *stored.TemplateID != template.ID {
+			t.Errorf("expected the template to be recorded, got %+v", stored)
+		}
+	}
+
+	// The Portuguese variant has no fallback for the name
+	_, err = svc.SendMessage(models.SendMessageRequest{OrganizationID: "org_1", ToJID: testLeadJID.String(), TemplateID: template.ID, Language: "pt"})
+	if !errors.Is(err, ErrInvalidMessage) || !strings.Contains(err.Error(), "lead.firstName") {
+		t.Errorf("expected the missing variable to be named, got %v", err)
+	}
+	_, err = svc.SendMessage(models.SendMessageRequest{OrganizationID: "org_2", ToJID: testLeadJID.String(), TemplateID: template.ID})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected another organization's template to be hidden, got %v", err)
+	}
+
+	sent, _, err := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1", TemplateID: template.ID})
+	if err != nil || len(sent) != 3 {
+		t.Errorf("expected the template's messages to be listed, got %v (%v)", sent, err)
+	}
+
+	// Previews render exactly what a send to the recipient gets
+	spun, err := svc.CreateTemplate(models.TemplateRequest{
+		OrganizationID: "org_1",
+		Name:           "follow-up",
+		Variation:      models.TemplateVariation{Emojis: []string{"🙂", "👋"}, Whitespace: true},
+		Variants:       []models.TemplateVariant{{Language: "en", MessageType: "text", MessageText: "{Hi|Hello|Hey} {{lead.firstName}}, {any news|how is it going}?"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTemplate: %v", err)
+	}
+	variables := map[string]string{"lead.firstName": "Ana"}
+	if _, err := svc.SendMessage(models.SendMessageRequest{OrganizationID: "org_1", ToJID: testLeadJID.String(), TemplateID: spun.ID, Variables: variables}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	preview, err := svc.PreviewTemplate(models.TemplatePreviewRequest{OrganizationID: "org_1", TemplateID: spun.ID, Variables: variables, ToJIDs: []string{testLeadJID.String()}})
+	if err != nil {
+		t.Fatalf("PreviewTemplate: %v", err)
+	}
+	if got := fake.SentMessages()[3].Message.GetConversation(); len(preview.Previews) != 1 || preview.Previews[0].MessageText != got {
+		t.Errorf("expected the preview to match the sent %q, got %+v", got, preview.Previews)
+	}
+	if preview.Combinations != 6 {
+		t.Errorf("expected 6 combinations, got %d", preview.Combinations)
+	}
+	samples, err := svc.PreviewTemplate(models.TemplatePreviewRequest{OrganizationID: "org_1", TemplateID: spun.ID, Variables: variables, Count: 100})
+	if err != nil || len(samples.Previews) != maxPreviewCount {
+		t.Errorf("expected %d samples, got %+v (%v)", maxPreviewCount, samples, err)
+	}
+
+	updated, err := svc.UpdateTemplate(models.TemplateRequest{
+		OrganizationID: "org_1",
+		TemplateID:     template.ID,
+		Name:           "welcome",
+		Variants:       []models.TemplateVariant{{Language: "en", MessageType: "text", MessageText: "Hello!"}},
+	})
+	if err != nil || updated.CreatedAt != template.CreatedAt || len(updated.Defaults) != 0 {
+		t.Errorf("expected the template to be replaced, got %+v (%v)", updated, err)
+	}
+	if err := svc.DeleteTemplate("org_1", template.ID); err != nil {
+		t.Fatalf("DeleteTemplate: %v", err)
+	}
+	if templates, _ := svc.ListTemplates("org_1"); len(templates) != 1 || templates[0].ID != spun.ID {
+		t.Errorf("expected only the follow-up template left, got %v", templates)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	svc, _, fake, account := newTestService(t)
+	notifier := &recordingNotifier{}
+	svc.AddNotifier(notifier)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	for _, state := range []models.ChatPresenceState{models.ChatPresenceComposing, models.ChatPresenceRecording, models.ChatPresencePaused} {
+		accountID, err := svc.SendChatPresence(models.ChatPresenceRequest{OrganizationID: "org_1", ToJID: testLeadJID.String(), State: state})
+		if err != nil || accountID != account.ID {
+			t.Fatalf("SendChatPresence %s: %q, %v", state, accountID, err)
+		}
+	}
+	want := []FakeChatPresence{
+		{JID: testLeadJID, State: types.ChatPresenceComposing},
+		{JID: testLeadJID, State: types.ChatPresenceComposing, Media: types.ChatPresenceMediaAudio},
+		{JID: testLeadJID, State: types.ChatPresencePaused},
+	}
+	if got := fake.ChatPresences(); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if _, err := svc.SendChatPresence(models.ChatPresenceRequest{OrganizationID: "org_1", ToJID: testLeadJID.String(), State: "dancing"}); !errors.Is(err, ErrInvalidPresence) {
+		t.Errorf("expected ErrInvalidPresence, got %v", err)
+	}
+
+	if _, err := svc.SetPresence(models.PresenceRequest{OrganizationID: "org_1", Available: true}); err != nil {
+		t.Fatalf("SetPresence: %v", err)
+	}
+	if _, err := svc.SubscribePresence(models.SubscribePresenceRequest{OrganizationID: "org_1", JID: testLeadJID.String()}); err != nil {
+		t.Fatalf("SubscribePresence: %v", err)
+	}
+	if presences, subscriptions := fake.Presences(), fake.Subscriptions(); len(presences) != 1 || presences[0] != types.PresenceAvailable ||
+		len(subscriptions) != 1 || subscriptions[0] != testLeadJID {
+		t.Errorf("unexpected presences %v and subscriptions %v", presences, subscriptions)
+	}
+
+	// Contacts typing and coming online are published
+	source := types.MessageSource{Chat: testLeadJID, Sender: testLeadJID}
+	fake.Emit(&events.ChatPresence{MessageSource: source, State: types.ChatPresenceComposing, Media: types.ChatPresenceMediaAudio})
+	fake.Emit(&events.ChatPresence{MessageSource: types.MessageSource{Chat: testLeadJID, Sender: testOwnJID, IsFromMe: true}, State: types.ChatPresenceComposing})
+	lastSeen := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	fake.Emit(&events.Presence{From: testLeadJID, Unavailable: true, LastSeen: lastSeen})
+
+	if got := notifier.types(); !slices.Equal(got, []models.EventType{models.EventChatPresence, models.EventContactPresence}) {
+		t.Fatalf("unexpected events %v", got)
+	}
+	typing := notifier.events[0].Data.(map[string]interface{})
+	if typing["state"] != models.ChatPresenceRecording || typing["phoneNumber"] != testLeadJID.User {
+		t.Errorf("unexpected chat presence %v", typing)
+	}
+	online := notifier.events[1].Data.(map[string]interface{})
+	if online["available"] != false || online["lastSeen"] != lastSeen || online["jid"] != testLeadJID.String() {
+		t.Errorf("unexpected presence %v", online)
+	}
+}
+
+func TestReadReceipts(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	isRead := func(messageID string) bool {
+		message, err := st.GetMessage(account.ID, messageID)
+		return err == nil && message.IsRead
+	}
+
+	fake.EmitMessage(testLeadJID, "IN1", &waE2E.Message{Conversation: proto.String("Hi")})
+	fake.EmitMessage(testLeadJID, "IN2", &waE2E.Message{Conversation: proto.String("Anyone?")})
+	outbound, err := svc.SendMessage(models.SendMessageRequest{OrganizationID: "org_1", ToJID: testLeadJID.String(), MessageType: "text", MessageText: "Hello"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	marked, err := svc.MarkRead(models.MarkReadRequest{OrganizationID: "org_1", MessageIDs: []string{"IN1"}})
+	if err != nil || marked != 1 || !isRead("IN1") || isRead("IN2") {
+		t.Fatalf("expected IN1 to be marked, got %d (%v)", marked, err)
+	}
+	marked, err = svc.MarkRead(models.MarkReadRequest{OrganizationID: "org_1", ChatJID: testLeadJID.String()})
+	if err != nil || marked != 1 || !isRead("IN2") {
+		t.Fatalf("expected the rest of the chat to be marked, got %d (%v)", marked, err)
+	}
+	want := []FakeRead{{IDs: []types.MessageID{"IN1"}, Chat: testLeadJID}, {IDs: []types.MessageID{"IN2"}, Chat: testLeadJID}}
+	if got := fake.Reads(); !slices.EqualFunc(got, want, func(a, b FakeRead) bool {
+		return slices.Equal(a.IDs, b.IDs) && a.Chat == b.Chat && a.Sender == b.Sender
+	}) {
+		t.Errorf("expected receipts %v, got %v", want, got)
+	}
+	if _, err := svc.MarkRead(models.MarkReadRequest{OrganizationID: "org_1", MessageIDs: []string{outbound}}); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected our own message to be refused, got %v", err)
+	}
+	if _, err := svc.MarkRead(models.MarkReadRequest{OrganizationID: "org_2", MessageIDs: []string{"IN1"}}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected another organization's message to be hidden, got %v", err)
+	}
+
+	// On fetch, only opening the chat reads it
+	policy := models.ReadReceiptPolicy("on_fetch")
+	if _, err := svc.UpdateAccount(models.UpdateAccountRequest{OrganizationID: "org_1", AccountID: account.ID, ReadReceipts: &policy}); err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	fake.EmitMessage(testLeadJID, "IN3", &waE2E.Message{Conversation: proto.String("Still there?")})
+	if _, _, err := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1"}); err != nil || isRead("IN3") {
+		t.Fatalf("expected listing every chat to leave IN3 unread (%v)", err)
+	}
+	messages, _, err := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1", ChatJID: testLeadJID.String()})
+	if err != nil || !messages[0].IsRead || !isRead("IN3") {
+		t.Fatalf("expected fetching the chat to read IN3, got %+v (%v)", messages[0], err)
+	}
+
+	policy = models.ReadReceiptsImmediately
+	if _, err := svc.UpdateAccount(models.UpdateAccountRequest{OrganizationID: "org_1", AccountID: account.ID, ReadReceipts: &policy}); err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	fake.EmitMessage(testLeadJID, "IN4", &waE2E.Message{Conversation: proto.String("Hello?")})
+	waitFor(t, func() bool { return isRead("IN4") })
+	if reads := fake.Reads(); len(reads) != 4 || reads[3].IDs[0] != "IN4" {
+		t.Errorf("expected IN4 to be read on receipt, got %v", reads)
+	}
+	fake.EmitMessage(testLeadJID, "EPHEMERAL1", &waE2E.Message{ProtocolMessage: &waE2E.ProtocolMessage{
+		Type:                waE2E.ProtocolMessage_EPHEMERAL_SETTING.Enum(),
+		EphemeralExpiration: proto.Uint32(86400),
+	}})
+	fake.EmitMessage(testLeadJID, "IN5", &waE2E.Message{Conversation: proto.String("Anyone?")})
+	waitFor(t, func() bool { return isRead("IN5") })
+	if isRead("EPHEMERAL1") || slices.ContainsFunc(fake.Reads(), func(read FakeRead) bool { return slices.Contains(read.IDs, "EPHEMERAL1") }) {
+		t.Errorf("expected the protocol message to stay unread, got %v", fake.Reads())
+	}
+
+	policy = "sometimes"
+	if _, err := svc.UpdateAccount(models.UpdateAccountRequest{OrganizationID: "org_1", AccountID: account.ID, ReadReceipts: &policy}); !errors.Is(err, ErrInvalidReadReceiptPolicy) {
+		t.Errorf("expected ErrInvalidReadReceiptPolicy, got %v", err)
+	}
+}
+
+func TestRepliesQuoteStoredMessages(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{
+		Conversation: proto.String("What does it cost?"),
+	})
+
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID:  "org_1",
+		ToJID:           testLeadJID.String(),
+		MessageType:     "text",
+		MessageText:     "$10",
+		QuotedMessageID: "INBOUND1",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	reply := fake.SentMessages()[0].Message.GetExtendedTextMessage()
+	if reply.GetText() != "$10" {
+		t.Fatalf("expected the reply as extended text, got %v", fake.SentMessages()[0].Message)
+	}
+	contextInfo := reply.GetContextInfo()
+	if contextInfo.GetStanzaID() != "INBOUND1" || contextInfo.GetParticipant() != testLeadJID.String() {
+		t.Errorf("unexpected context: %v", contextInfo)
+	}
+	if contextInfo.GetQuotedMessage().GetConversation() != "What does it cost?" {
+		t.Errorf("unexpected quoted message: %v", contextInfo.GetQuotedMessage())
+	}
+	if stored, _ := st.GetMessage(account.ID, messageID); stored.QuotedMessageID == nil || *stored.QuotedMessageID != "INBOUND1" {
+		t.Errorf("expected the reply to be recorded, got %+v", stored)
+	}
+
+	// The lead replies to our message
+	fake.EmitMessage(testLeadJID, "INBOUND2", &waE2E.Message{
+		ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:        proto.String("Deal"),
+			ContextInfo: &waE2E.ContextInfo{StanzaID: proto.String(messageID)},
+		},
+	})
+	if stored, _ := st.GetMessage(account.ID, "INBOUND2"); stored.QuotedMessageID == nil || *stored.QuotedMessageID != messageID {
+		t.Errorf("expected the inbound reply to be recorded, got %+v", stored)
+	}
+
+	_, err = svc.SendMessage(models.SendMessageRequest{
+		OrganizationID:  "org_2",
+		ToJID:           testLeadJID.String(),
+		MessageType:     "text",
+		MessageText:     "Hi",
+		QuotedMessageID: "INBOUND1",
+	})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected another organization's message to be hidden, got %v", err)
+	}
+}
+
+func TestReactionsAttachToStoredMessages(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	notifier := &recordingNotifier{}
+	svc.AddNotifier(notifier)
+	st.AddLead("org_1", "lead_1", testLeadJID.User)
+
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{
+		Conversation: proto.String("Thanks!"),
+	})
+	if _, err := svc.SendReaction(models.SendReactionRequest{
+		OrganizationID: "org_1",
+		MessageID:      "INBOUND1",
+		Reaction:       "👍",
+	}); err != nil {
+		t.Fatalf("SendReaction: %v", err)
+	}
+
+	sent := fake.SentMessages()[0]
+	key := sent.Message.GetReactionMessage().GetKey()
+	if sent.To != testLeadJID || key.GetID() != "INBOUND1" || key.GetFromMe() {
+		t.Errorf("unexpected reaction: to %s, key %v", sent.To, key)
+	}
+
+	// The lead reacts to our reply and then swaps the emoji. Reaction keys
+	// are from the reacting side, so our message is not FromMe to the lead.
+	messageID := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: testLeadJID.String()}).MessageID
+	for _, emoji := range []string{"❤️", "😂"} {
+		fake.EmitMessage(testLeadJID, types.MessageID("REACTION"+emoji), &waE2E.Message{
+			ReactionMessage: &waE2E.ReactionMessage{
+				Key:  &waCommon.MessageKey{ID: proto.String(messageID), FromMe: proto.Bool(false)},
+				Text: proto.String(emoji),
+			},
+		})
+	}
+
+	message, err := svc.GetMessage("org_1", "", messageID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if len(message.Reactions) != 1 || message.Reactions[0].Reaction != "😂" || message.Reactions[0].SenderJID != testLeadJID.String() {
+		t.Errorf("expected the lead's latest reaction, got %+v", message.Reactions)
+	}
+	if _, err := st.GetMessage(account.ID, "REACTION😂"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected reactions not to be stored as messages, got %v", err)
+	}
+
+	// Reactions naming messages of another account, or claiming the wrong
+	// sender, are dropped
+	other := &models.WhatsAppMeowAccount{OrganizationID: "org_2", DeviceID: "device_2"}
+	if err := st.CreateAccount(other); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	if err := st.InsertMessage(&models.WhatsAppMeowMessage{
+		WhatsAppMeowAccountID: other.ID,
+		MessageID:             "OTHER1",
+		FromJID:               testLeadJID.String(),
+		ToJID:                 types.NewJID("15550000009", types.DefaultUserServer).String(),
+		MessageType:           models.MessageTypeText,
+		Timestamp:             time.Now(),
+	}); err != nil {
+		t.Fatalf("InsertMessage: %v", err)
+	}
+	for id, fromMe := range map[string]bool{"OTHER1": true, "INBOUND1": false, messageID: true} {
+		fake.EmitMessage(testLeadJID, types.MessageID("FORGED"+id), &waE2E.Message{
+			ReactionMessage: &waE2E.ReactionMessage{
+				Key:  &waCommon.MessageKey{ID: proto.String(id), FromMe: proto.Bool(fromMe)},
+				Text: proto.String("🔥"),
+			},
+		})
+	}
+	for id, accountID := range map[string]string{"OTHER1": other.ID, "INBOUND1": account.ID, messageID: account.ID} {
+		stored, err := st.GetMessage(accountID, id)
+		if err != nil {
+			t.Fatalf("GetMessage: %v", err)
+		}
+		if slices.ContainsFunc(stored.Reactions, func(r models.WhatsAppMeowReaction) bool { return r.Reaction == "🔥" }) {
+			t.Errorf("expected the forged reaction to %s to be dropped, got %+v", id, stored.Reactions)
+		}
+	}
+	if got := notifier.types(); len(got) != 4 || got[0] != models.EventMessageReceived || got[3] != models.EventMessageReaction {
+		t.Errorf("expected the received message and three reaction events, got %v", got)
+	}
+}
+
+func TestEditAndRevokeMessages(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	messageID := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: testLeadJID.String()}).MessageID
+	if _, err := svc.EditMessage(models.EditMessageRequest{
+		OrganizationID: "org_1",
+		MessageID:      messageID,
+		MessageText:    "Hello there",
+	}); err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+
+	sent := fake.SentMessages()
+	edit := sent[len(sent)-1].Message.GetEditedMessage().GetMessage().GetProtocolMessage()
+	if edit.GetKey().GetID() != messageID || edit.GetEditedMessage().GetConversation() != "Hello there" {
+		t.Errorf("unexpected edit: %v", edit)
+	}
+	stored, _ := st.GetMessage(account.ID, messageID)
+	if *stored.MessageText != "Hello there" || len(stored.EditHistory) != 1 || stored.EditHistory[0].PreviousText != "Hello" {
+		t.Errorf("expected the edit to be recorded, got %+v", stored)
+	}
+
+	if _, err := svc.RevokeMessage(models.RevokeMessageRequest{OrganizationID: "org_1", MessageID: messageID}); err != nil {
+		t.Fatalf("RevokeMessage: %v", err)
+	}
+	if stored, _ := st.GetMessage(account.ID, messageID); !stored.IsRevoked {
+		t.Errorf("expected the message to be revoked")
+	}
+
+	// The lead fixes a typo and then deletes the message
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{Conversation: proto.String("Cal me")})
+	_, err := svc.EditMessage(models.EditMessageRequest{OrganizationID: "org_1", MessageID: "INBOUND1", MessageText: "Call me"})
+	if !errors.Is(err, ErrMessageNotFromMe) {
+		t.Errorf("expected ErrMessageNotFromMe, got %v", err)
+	}
+	fake.EmitMessage(testLeadJID, "EDIT1", &waE2E.Message{
+		ProtocolMessage: &waE2E.ProtocolMessage{
+			Type:          waE2E.ProtocolMessage_MESSAGE_EDIT.Enum(),
+			Key:           &waCommon.MessageKey{ID: proto.String("INBOUND1")},
+			EditedMessage: &waE2E.Message{Conversation: proto.String("Call me")},
+		},
+	})
+	if stored, _ := st.GetMessage(account.ID, "INBOUND1"); *stored.MessageText != "Call me" || len(stored.EditHistory) != 1 {
+		t.Errorf("expected the inbound edit to be recorded, got %+v", stored)
+	}
+
+	revoke := &waE2E.Message{
+		ProtocolMessage: &waE2E.ProtocolMessage{
+			Type: waE2E.ProtocolMessage_REVOKE.Enum(),
+			Key:  &waCommon.MessageKey{ID: proto.String("INBOUND1")},
+		},
+	}
+	fake.EmitMessage(types.NewJID("15550000009", types.DefaultUserServer), "REVOKE0", revoke)
+	if stored, _ := st.GetMessage(account.ID, "INBOUND1"); stored.IsRevoked {
+		t.Errorf("expected a revoke from someone else to be ignored")
+	}
+	fake.EmitMessage(testLeadJID, "REVOKE1", revoke)
+	if stored, _ := st.GetMessage(account.ID, "INBOUND1"); !stored.IsRevoked {
+		t.Errorf("expected the inbound revoke to be recorded")
+	}
+	if _, err := st.GetMessage(account.ID, "REVOKE1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected protocol messages not to be stored, got %v", err)
+	}
+}
+
+func TestMessageHistoryAndConversations(t *testing.T) {
+	svc, st, _, account := newTestService(t)
+
+	other := types.NewJID("15550000003", types.DefaultUserServer).String()
+	own, lead := testOwnJID.String(), testLeadJID.String()
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		message := &models.WhatsAppMeowMessage{
+			WhatsAppMeowAccountID: account.ID,
+			MessageID:             fmt.Sprintf("HIST%d", i),
+			FromJID:               lead,
+			ToJID:                 own,
+			MessageType:           models.MessageTypeText,
+			IsSent:                true,
+			Timestamp:             start.Add(time.Duration(i) * time.Minute),
+		}
+		if i%2 == 1 {
+			message.FromJID, message.ToJID, message.IsFromMe = own, lead, true
+		}
+		if i == 6 {
+			message.FromJID = other
+			message.MessageType = models.MessageTypeImage
+		}
+		if err := st.InsertMessage(message); err != nil {
+			t.Fatalf("InsertMessage: %v", err)
+		}
+	}
+	if err := st.UpdateMessageStatus(account.ID, "HIST0", models.MessageStatusRead, start); err != nil {
+		t.Fatalf("UpdateMessageStatus: %v", err)
+	}
+
+	var ids []string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		messages, next, err := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1", ChatJID: lead, Cursor: cursor, Limit: 4})
+		if err != nil {
+			t.Fatalf("ListMessages: %v", err)
+		}
+		for _, message := range messages {
+			ids = append(ids, message.MessageID)
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "HIST5,HIST4,HIST3,HIST2,HIST1,HIST0" {
+		t.Errorf("unexpected pages: %v", ids)
+	}
+
+	filtered, _, err := svc.ListMessages(models.MessageHistoryQuery{
+		OrganizationID: "org_1",
+		Direction:      "inbound",
+		Status:         "sent",
+		Since:          start.Add(time.Minute),
+		Until:          start.Add(6 * time.Minute),
+	})
+	if err != nil || len(filtered) != 2 || filtered[0].MessageID != "HIST4" || filtered[1].MessageID != "HIST2" {
+		t.Errorf("unexpected filtered messages: %v %v", filtered, err)
+	}
+	if images, _, _ := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1", MessageType: "image"}); len(images) != 1 {
+		t.Errorf("expected one image, got %d", len(images))
+	}
+	if others, _, _ := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_2"}); len(others) != 0 {
+		t.Errorf("expected another organization to see nothing, got %d", len(others))
+	}
+	if _, _, err := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1", Cursor: "%%%"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected a bad cursor to be refused, got %v", err)
+	}
+	if _, _, err := svc.ListMessages(models.MessageHistoryQuery{OrganizationID: "org_1", Direction: "sideways"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected a bad direction to be refused, got %v", err)
+	}
+
+	conversations, next, err := svc.ListConversations("org_1", "", "", 1)
+	if err != nil || len(conversations) != 1 || next == "" {
+		t.Fatalf("ListConversations: %v %v", conversations, err)
+	}
+	if conversations[0].ChatJID != other || conversations[0].UnreadCount != 1 {
+		t.Errorf("unexpected first conversation: %+v", conversations[0])
+	}
+	conversations, next, err = svc.ListConversations("org_1", account.ID, next, 1)
+	if err != nil || len(conversations) != 1 || next != "" {
+		t.Fatalf("ListConversations: %v %v", conversations, err)
+	}
+	chat := conversations[0]
+	if chat.ChatJID != lead || chat.LastMessage.MessageID != "HIST5" || chat.UnreadCount != 2 || !chat.LastActivity.Equal(start.Add(5*time.Minute)) {
+		t.Errorf("unexpected lead conversation: %+v", chat)
+	}
+}
+
+func TestPairingAndReconnect(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	fake.EmitQR("qr-code-1", "qr-code-2")
+
+	qrCode, err := svc.GetQRCode("org_1", "")
+	if err != nil || qrCode != "qr-code-1" {
+		t.Fatalf("expected first QR code, got %q (%v)", qrCode, err)
+	}
+
+	fake.EmitQRItem(whatsmeow.QRChannelItem{Event: whatsmeow.QRChannelEventCode, Code: "qr-code-rotated"})
+	waitFor(t, func() bool {
+		qrCode, _ := svc.GetQRCode("org_1", "")
+		return qrCode == "qr-code-rotated"
+	})
+
+	fake.Emit(&events.Connected{})
+	connected, _ := st.GetAccount(account.ID)
+	if !connected.IsConnected || connected.QRCode != nil || connected.PhoneNumber == nil {
+		t.Fatalf("expected connected account without QR code: %+v", connected)
+	}
+
+	fake.EmitDisconnected()
+	disconnected, _ := st.GetAccount(account.ID)
+	if disconnected.IsConnected || disconnected.ConnectionStatus != models.ConnectionStatusDisconnected {
+		t.Fatalf("expected disconnected account: %+v", disconnected)
+	}
+
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	if !fake.IsConnected() {
+		t.Error("expected client to be connected again")
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newMultiAccountService(t *testing.T, connected ...bool) (*WhatsAppMeowService, *store.Memory, []*models.WhatsAppMeowAccount) {
+	t.Helper()
+
+	st := store.NewMemory()
+	fakes := make(map[string]*FakeClient)
+	var accounts []*models.WhatsAppMeowAccount
+	for i, isConnected := range connected {
+		account := &models.WhatsAppMeowAccount{
+			OrganizationID:   "org_1",
+			DeviceID:         fmt.Sprintf("device_%d", i),
+			IsConnected:      isConnected,
+			IsPaired:         true,
+			ConnectionStatus: models.ConnectionStatusConnected,
+		}
+		if err := st.CreateAccount(account); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+		fakes[account.ID] = NewFakeClient(types.NewJID(fmt.Sprintf("1555000010%d", i), types.DefaultUserServer))
+		accounts = append(accounts, account)
+	}
+	factory := func(account *models.WhatsAppMeowAccount) (WhatsAppClient, error) {
+		return fakes[account.ID], nil
+	}
+
+	svc := NewWhatsAppMeowService(&config.Config{RoutingPolicy: string(models.RoutingRoundRobin)}, st, factory)
+	return svc, st, accounts
+}
+
+func sendFrom(t *testing.T, svc *WhatsAppMeowService, st *store.Memory, req models.SendMessageRequest) *models.WhatsAppMeowMessage {
+	t.Helper()
+
+	req.OrganizationID = "org_1"
+	req.MessageType = "text"
+	req.MessageText = "Hello"
+	messageID, err := svc.SendMessage(req)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	messages, err := st.ListMessages(store.MessageFilter{OrganizationID: "org_1", MessageID: messageID})
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ListMessages: %v (%v)", messages, err)
+	}
+	return messages[0]
+}
+
+func TestRoutingAcrossAccounts(t *testing.T) {
+	svc, st, _ := newMultiAccountService(t, true, true, false)
+	lead := func(n int) string {
+		return types.NewJID(fmt.Sprintf("1555000020%d", n), types.DefaultUserServer).String()
+	}
+
+	first := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: lead(1)})
+	second := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: lead(2)})
+	if first.WhatsAppMeowAccountID == second.WhatsAppMeowAccountID {
+		t.Errorf("expected round-robin to alternate accounts")
+	}
+
+	busy := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: lead(3), RoutingPolicy: models.RoutingStickyPerLead})
+	for i := 0; i < 3; i++ {
+		again := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: lead(3)})
+		if again.WhatsAppMeowAccountID != busy.WhatsAppMeowAccountID {
+			t.Fatalf("expected the conversation to stay on account %s, got %s", busy.WhatsAppMeowAccountID, again.WhatsAppMeowAccountID)
+		}
+	}
+
+	leastLoaded := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: lead(4), RoutingPolicy: models.RoutingLeastLoaded})
+	if leastLoaded.WhatsAppMeowAccountID == busy.WhatsAppMeowAccountID {
+		t.Errorf("expected least-loaded routing to avoid the busier account %s", busy.WhatsAppMeowAccountID)
+	}
+
+	// An unknown policy is rejected even where it would not be consulted
+	for _, req := range []models.SendMessageRequest{
+		{ToJID: lead(5)},
+		{ToJID: lead(3)},
+		{ToJID: lead(5), AccountID: busy.WhatsAppMeowAccountID},
+	} {
+		req.OrganizationID, req.MessageType, req.MessageText, req.RoutingPolicy = "org_1", "text", "Hello", "random"
+		if _, err := svc.SendMessage(req); !errors.Is(err, ErrInvalidRoutingPolicy) {
+			t.Errorf("expected an unknown routing policy to be invalid for %+v, got %v", req, err)
+		}
+	}
+
+	if _, err := svc.GetAccount("org_1", ""); !errors.Is(err, ErrAccountRequired) {
+		t.Errorf("expected ErrAccountRequired, got %v", err)
+	}
+	if _, err := svc.GetAccount("org_2", first.WhatsAppMeowAccountID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected other organizations to be denied, got %v", err)
+	}
+}
+
+func TestStickyRoutingFollowsConversationHistory(t *testing.T) {
+	svc, st, accounts := newMultiAccountService(t, true, true)
+	pinned, other := accounts[1], accounts[0]
+
+	// The lead first talked to the second number
+	first := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: testLeadJID.String(), AccountID: pinned.ID, LeadID: "lead_1"})
+	if first.WhatsAppMeowAccountID != pinned.ID {
+		t.Fatalf("expected explicit account %s, got %s", pinned.ID, first.WhatsAppMeowAccountID)
+	}
+
+	for i := 0; i < 3; i++ {
+		next := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: testLeadJID.String(), LeadID: "lead_1"})
+		if next.WhatsAppMeowAccountID != pinned.ID {
+			t.Fatalf("expected lead to stay on %s, got %s", pinned.ID, next.WhatsAppMeowAccountID)
+		}
+	}
+
+	// A number that is merely offline keeps the conversation
+	if err := st.UpdateConnectionStatus(pinned.ID, models.ConnectionStatusDisconnected, false); err != nil {
+		t.Fatalf("UpdateConnectionStatus: %v", err)
+	}
+	_, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		LeadID:         "lead_1",
+		MessageType:    "text",
+		MessageText:    "Hello",
+	})
+	if err == nil {
+		t.Fatal("expected send to fail while the pinned account is offline")
+	}
+
+	// A banned number hands the conversation over
+	if err := st.UpdateConnectionStatus(pinned.ID, models.ConnectionStatusBanned, false); err != nil {
+		t.Fatalf("UpdateConnectionStatus: %v", err)
+	}
+	fallback := sendFrom(t, svc, st, models.SendMessageRequest{ToJID: testLeadJID.String(), LeadID: "lead_1"})
+	if fallback.WhatsAppMeowAccountID != other.ID {
+		t.Fatalf("expected fallback to %s, got %s", other.ID, fallback.WhatsAppMeowAccountID)
+	}
+
+	// With every number banned there is nothing left to send from
+	if err := st.UpdateConnectionStatus(other.ID, models.ConnectionStatusBanned, false); err != nil {
+		t.Fatalf("UpdateConnectionStatus: %v", err)
+	}
+	_, err = svc.SendMessage(models.SendMessageRequest{OrganizationID: "org_1", ToJID: testLeadJID.String(), LeadID: "lead_1", MessageType: "text", MessageText: "Hello"})
+	if !errors.Is(err, ErrNoAccountAvailable) {
+		t.Errorf("expected ErrNoAccountAvailable, got %v", err)
+	}
+}
+
+func TestMessageIDsAreScopedToAccounts(t *testing.T) {
+	svc, st, accounts := newMultiAccountService(t, true, true)
+
+	// Both numbers received a message that happens to have the same ID
+	for i, account := range accounts {
+		if err := st.InsertMessage(&models.WhatsAppMeowMessage{
+			WhatsAppMeowAccountID: account.ID,
+			MessageID:             "SHARED1",
+			FromJID:               testLeadJID.String(),
+			ToJID:                 types.NewJID(fmt.Sprintf("1555000010%d", i), types.DefaultUserServer).String(),
+			MessageType:           models.MessageTypeText,
+			MessageText:           proto.String(fmt.Sprintf("Hello %d", i)),
+			Timestamp:             time.Now(),
+		}); err != nil {
+			t.Fatalf("InsertMessage: %v", err)
+		}
+	}
+	if err := st.InsertMessage(&models.WhatsAppMeowMessage{WhatsAppMeowAccountID: accounts[0].ID, MessageID: "SHARED1"}); err == nil {
+		t.Errorf("expected message IDs to stay unique within an account")
+	}
+
+	if _, err := svc.GetMessage("org_1", "", "SHARED1"); !errors.Is(err, ErrAccountRequired) {
+		t.Errorf("expected ErrAccountRequired, got %v", err)
+	}
+	message, err := svc.GetMessage("org_1", accounts[1].ID, "SHARED1")
+	if err != nil || message.WhatsAppMeowAccountID != accounts[1].ID || *message.MessageText != "Hello 1" {
+		t.Fatalf("expected the second account's message, got %+v (%v)", message, err)
+	}
+
+	// Reading and reacting on one number leaves the other's message alone
+	if _, err := svc.MarkRead(models.MarkReadRequest{OrganizationID: "org_1", AccountID: accounts[1].ID, MessageIDs: []string{"SHARED1"}}); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if err := st.SetReaction(&models.WhatsAppMeowReaction{
+		WhatsAppMeowAccountID: accounts[0].ID,
+		MessageID:             "SHARED1",
+		SenderJID:             testLeadJID.String(),
+		Reaction:              "👍",
+		Timestamp:             time.Now(),
+	}); err != nil {
+		t.Fatalf("SetReaction: %v", err)
+	}
+	for i, account := range accounts {
+		stored, err := st.GetMessage(account.ID, "SHARED1")
+		if err != nil || stored.IsRead != (i == 1) || len(stored.Reactions) != 1-i {
+			t.Errorf("unexpected message of account %d: read %v, reactions %v (%v)", i, stored.IsRead, stored.Reactions, err)
+		}
+	}
+}
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+func (n *recordingNotifier) Notify(event models.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.events = append(n.events, event)
+}
+
+func (n *recordingNotifier) types() []models.EventType {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var eventTypes []models.EventType
+	for _, event := range n.events {
+		eventTypes = append(eventTypes, event.Type)
+	}
+	return eventTypes
+}
+
+func TestLogoutUnlinksAndWipesAccount(t *testing.T) {
+	for _, fromPhone := range []bool{false, true} {
+		svc, st, fake, account := newTestService(t)
+		notifier := &recordingNotifier{}
+		svc.AddNotifier(notifier)
+
+		if err := svc.Connect("org_1", account.ID, ""); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		fake.Emit(&events.Connected{})
+
+		if fromPhone {
+			fake.EmitLoggedOut()
+		} else {
+			remoteUnlinked, err := svc.Logout("org_1", account.ID)
+			if err != nil || !remoteUnlinked {
+				t.Fatalf("Logout: unlinked=%v err=%v", remoteUnlinked, err)
+			}
+		}
+
+		if !fake.DeviceDeleted() || fake.IsConnected() {
+			t.Errorf("expected device to be deleted and disconnected (fromPhone=%v)", fromPhone)
+		}
+		wiped, _ := st.GetAccount(account.ID)
+		if wiped.IsPaired || wiped.IsConnected || wiped.PhoneNumber != nil || wiped.SessionData != nil || wiped.QRCode != nil {
+			t.Errorf("expected pairing fields to be cleared (fromPhone=%v): %+v", fromPhone, wiped)
+		}
+		if wiped.ConnectionStatus != models.ConnectionStatusLoggedOut {
+			t.Errorf("expected LOGGED_OUT status, got %s", wiped.ConnectionStatus)
+		}
+		want := []models.EventType{models.EventAccountConnected, models.EventAccountLoggedOut}
+		if eventTypes := notifier.types(); !slices.Equal(eventTypes, want) {
+			t.Errorf("expected connected and logged out events, got %v", eventTypes)
+		}
+	}
+}
+
+func TestDeleteAccountUnlinksDevice(t *testing.T) {
+	for _, online := range []bool{true, false} {
+		svc, st, fake, account := newTestService(t)
+		if online {
+			if err := svc.Connect("org_1", account.ID, ""); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+		}
+
+		if err := svc.DeleteAccount("org_1", account.ID); err != nil {
+			t.Fatalf("DeleteAccount: %v", err)
+		}
+		// Offline devices cannot be logged out, but their session is still
+		// deleted
+		if !fake.DeviceDeleted() || fake.IsLoggedIn() != !online || fake.IsConnected() {
+			t.Errorf("expected the device to be unlinked (online=%v)", online)
+		}
+		if _, err := st.GetAccount(account.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected the account to be deleted (online=%v), got %v", online, err)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if err := svc.Connect("org_1", "", account.DeviceID); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	refused := types.NewJID("15550000003", types.DefaultUserServer)
+	fake.RefusedParticipants[refused] = 403
+
+	if _, _, err := svc.CreateGroup(models.CreateGroupRequest{OrganizationID: "org_1", Name: strings.Repeat("x", 26)}); !errors.Is(err, ErrInvalidGroup) {
+		t.Errorf("expected a long name to be refused, got %v", err)
+	}
+	group, failed, err := svc.CreateGroup(models.CreateGroupRequest{
+		OrganizationID: "org_1",
+		Name:           " Spring launch ",
+		Participants:   []string{"+1 (555) 000-0002", refused.String()},
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	if group.Name != "Spring launch" || group.OwnerJID != testOwnJID.String() || len(group.Participants) != 2 ||
+		group.Participants[1].PhoneNumber != testLeadJID.User || group.GroupCreatedAt == nil {
+		t.Errorf("unexpected group %+v", group)
+	}
+	if len(failed) != 1 || failed[0].JID != refused.String() || failed[0].Error != 403 {
+		t.Errorf("expected the refused participant to fail, got %+v", failed)
+	}
+	groupReq := models.GroupRequest{OrganizationID: "org_1", GroupJID: group.JID}
+	stored := func() *models.WhatsAppMeowGroup {
+		t.Helper()
+		group, err := st.GetGroup(account.ID, groupReq.GroupJID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		return group
+	}
+
+	group, _, err = svc.UpdateGroupParticipants(models.GroupParticipantsRequest{GroupRequest: groupReq, Action: models.GroupParticipantPromote, Participants: []string{testLeadJID.User}})
+	if err != nil || !group.Participants[1].IsAdmin || !stored().Participants[1].IsAdmin {
+		t.Fatalf("expected the lead to be promoted, got %+v (%v)", group, err)
+	}
+	group, failed, err = svc.UpdateGroupParticipants(models.GroupParticipantsRequest{GroupRequest: groupReq, Action: models.GroupParticipantRemove, Participants: []string{testLeadJID.User, refused.User}})
+	if err != nil || len(group.Participants) != 1 || len(failed) != 1 || failed[0].JID != refused.String() {
+		t.Fatalf("expected the lead to be removed and the stranger to fail, got %+v, %+v (%v)", group, failed, err)
+	}
+	if _, _, err := svc.UpdateGroupParticipants(models.GroupParticipantsRequest{GroupRequest: groupReq, Action: "ban", Participants: []string{testLeadJID.User}}); !errors.Is(err, ErrInvalidGroup) {
+		t.Errorf("expected an unknown action to be refused, got %v", err)
+	}
+
+	name, description := "Spring launch 2026", "Questions welcome"
+	if group, err = svc.UpdateGroup(models.UpdateGroupRequest{GroupRequest: groupReq, Name: &name, Description: &description}); err != nil {
+		t.Fatalf("UpdateGroup: %v", err)
+	}
+	if saved := stored(); saved.Name != name || saved.Description != description {
+		t.Errorf("expected the new name and description to be stored, got %+v", saved)
+	}
+
+	// Pictures are converted to the square JPEGs WhatsApp takes
+	photo := image.NewNRGBA(image.Rect(0, 0, 800, 400))
+	draw.Draw(photo, photo.Rect, image.NewUniform(color.NRGBA{B: 200, A: 255}), image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, photo); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+	if group, err = svc.SetGroupPhoto(models.GroupPhotoRequest{GroupRequest: groupReq, PhotoURL: server.URL + "/logo.png"}); err != nil || group.PhotoID == nil {
+		t.Fatalf("expected the photo to be set, got %+v (%v)", group, err)
+	}
+	converted, err := makeGroupPhoto(encoded.Bytes())
+	if err != nil {
+		t.Fatalf("makeGroupPhoto: %v", err)
+	}
+	if config, format, err := image.DecodeConfig(bytes.NewReader(converted)); err != nil || format != "jpeg" || config.Width != 400 || config.Height != 400 {
+		t.Errorf("expected a 400x400 JPEG, got %s %+v (%v)", format, config, err)
+	}
+
+	link, err := svc.GroupInviteLink(groupReq, false)
+	if err != nil || !strings.HasPrefix(link, whatsmeow.InviteLinkPrefix) {
+		t.Fatalf("GroupInviteLink: %q, %v", link, err)
+	}
+	revoked, err := svc.GroupInviteLink(groupReq, true)
+	if err != nil || revoked == link {
+		t.Fatalf("expected a new invite link, got %q (%v)", revoked, err)
+	}
+	if saved := stored(); saved.InviteLink == nil || *saved.InviteLink != revoked || saved.PhotoID == nil || *saved.PhotoID != *group.PhotoID {
+		t.Errorf("expected the link and photo to be kept, got %+v", saved)
+	}
+
+	// Joining takes codes and whole links; revoked ones no longer work
+	partners := types.NewJID("120363999999999999", types.GroupServer)
+	fake.Invites["PARTNERS"] = &types.GroupInfo{JID: partners, GroupName: types.GroupName{Name: "Partners"}, Participants: []types.GroupParticipant{{JID: testLeadJID}}}
+	if _, err := svc.JoinGroup(models.JoinGroupRequest{OrganizationID: "org_1", InviteCode: link}); !errors.Is(err, ErrInvalidGroup) {
+		t.Errorf("expected the revoked link to be refused, got %v", err)
+	}
+	joined, err := svc.JoinGroup(models.JoinGroupRequest{OrganizationID: "org_1", InviteCode: whatsmeow.InviteLinkPrefix + "PARTNERS"})
+	if err != nil || joined.JID != partners.String() || joined.Name != "Partners" || len(joined.Participants) != 2 {
+		t.Fatalf("expected to join Partners, got %+v (%v)", joined, err)
+	}
+
+	groups, err := svc.ListGroups("org_1", "", false)
+	if err != nil || len(groups) != 2 || groups[0].JID != partners.String() {
+		t.Fatalf("expected both groups by name, got %+v (%v)", groups, err)
+	}
+
+	// Changes made elsewhere are picked up from group events
+	fake.SetGroupName(partners, "Partners EU")
+	fake.Emit(&events.GroupInfo{JID: partners, Name: &types.GroupName{Name: "Partners EU"}})
+	waitFor(t, func() bool {
+		group, err := st.GetGroup(account.ID, partners.String())
+		return err == nil && group.Name == "Partners EU"
+	})
+
+	if err := svc.LeaveGroup(models.GroupRequest{OrganizationID: "org_1", GroupJID: partners.String()}); err != nil {
+		t.Fatalf("LeaveGroup: %v", err)
+	}
+	if err := svc.LeaveGroup(models.GroupRequest{OrganizationID: "org_1", GroupJID: partners.String()}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected leaving twice to fail with ErrNotFound, got %v", err)
+	}
+	if _, err := svc.GroupInviteLink(models.GroupRequest{OrganizationID: "org_1", GroupJID: testLeadJID.String()}, false); !errors.Is(err, ErrInvalidGroup) {
+		t.Errorf("expected a contact JID to be refused, got %v", err)
+	}
+
+	// Refreshing drops groups the account was removed from elsewhere
+	created, _ := types.ParseJID(groupReq.GroupJID)
+	fake.LeaveGroup(created)
+	if groups, err := svc.ListGroups("org_1", "", true); err != nil || len(groups) != 0 {
+		t.Errorf("expected no groups after refreshing, got %+v (%v)", groups, err)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+)
+
+// maxCombinations caps the count of distinct texts reported for a template
+const maxCombinations = 1_000_000_000
+
+// spinText is parsed spintax such as "{Hi|Hello} {{lead.firstName}}": a
+// sequence of literal text and groups picking one of their options.
+// Placeholders are kept as literal text and rendered afterwards.
+type spinText []spinPart
+
+type spinPart struct {
+	literal string
+	options []spinText
+}
+
+// parseSpintax parses a text with {a|b} groups, which may nest. A literal
+// brace or bar inside a group is written \{, \} or \|.
+func parseSpintax(text string) (spinText, error) {
+	parsed, rest, err := parseSpinSequence(text, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("unmatched }")
+	}
+	return parsed, nil
+}
+
+func parseSpinSequence(text string, nested bool) (spinText, string, error) {
+	var sequence spinText
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			sequence = append(sequence, spinPart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for text != "" {
+		switch {
+		case len(text) > 1 && text[0] == '\\' && strings.ContainsRune(`{}|\`, rune(text[1])):
+			literal.WriteByte(text[1])
+			text = text[2:]
+		case text[0] == '{':
+			if loc := placeholderPattern.FindStringIndex(text); loc != nil && loc[0] == 0 {
+				literal.WriteString(text[:loc[1]])
+				text = text[loc[1]:]
+				continue
+			}
+			group, rest, err := parseSpinGroup(text[1:])
+			if err != nil {
+				return nil, "", err
+			}
+			flush()
+			sequence = append(sequence, group)
+			text = rest
+		case text[0] == '}' || (text[0] == '|' && nested):
+			flush()
+			return sequence, text, nil
+		default:
+			literal.WriteByte(text[0])
+			text = text[1:]
+		}
+	}
+	if nested {
+		return nil, "", errors.New("unclosed {")
+	}
+	flush()
+	return sequence, "", nil
+}
+
+// parseSpinGroup parses the options of a group up to its closing brace
+func parseSpinGroup(text string) (spinPart, string, error) {
+	var group spinPart
+	for {
+		option, rest, err := parseSpinSequence(text, true)
+		if err != nil {
+			return group, "", err
+		}
+		group.options = append(group.options, option)
+		if rest[0] == '}' {
+			if len(group.options) < 2 {
+				return group, "", errors.New("{...} needs at least two options separated by |")
+			}
+			return group, rest[1:], nil
+		}
+		text = rest[1:]
+	}
+}
+
+// render picks one option of every group
+func (t spinText) render(rng *rand.Rand) string {
+	var text strings.Builder
+	t.renderTo(&text, rng)
+	return text.String()
+}
+
+func (t spinText) renderTo(text *strings.Builder, rng *rand.Rand) {
+	for _, part := range t {
+		if part.options == nil {
+			text.WriteString(part.literal)
+			continue
+		}
+		part.options[rng.IntN(len(part.options))].renderTo(text, rng)
+	}
+}
+
+// combinations counts the distinct texts the spintax can produce, up to
+// maxCombinations
+func (t spinText) combinations() int {
+	total := 1
+	for _, part := range t {
+		if part.options == nil {
+			continue
+		}
+		sum := 0
+		for _, option := range part.options {
+			sum = min(sum+option.combinations(), maxCombinations)
+		}
+		total = min(total*sum, maxCombinations)
+	}
+	return total
+}
+
+// variationRand returns the random source of one recipient of a template,
+// so a retried send renders the same text
+func variationRand(templateID, recipient string) *rand.Rand {
+	hash := fnv.New64a()
+	hash.Write([]byte(templateID))
+	hash.Write([]byte{0})
+	hash.Write([]byte(recipient))
+	return rand.New(rand.NewPCG(hash.Sum64(), 0))
+}
+
+// varyWhitespace doubles one of the spaces of a text, or none of them
+func varyWhitespace(text string, rng *rand.Rand) string {
+	spaces := strings.Count(text, " ")
+	pick := rng.IntN(spaces + 1)
+	if pick == spaces {
+		return text
+	}
+
+	offset := 0
+	for i := 0; i < pick; i++ {
+		offset += strings.IndexByte(text[offset:], ' ') + 1
+	}
+	offset += strings.IndexByte(text[offset:], ' ')
+	return text[:offset] + " " + text[offset:]
+}
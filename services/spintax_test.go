@@ -0,0 +1,70 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSpintax(t *testing.T) {
+	for text, want := range map[string]int{
+		"Hello":                              1,
+		"{Hi|Hello|Hey} there":               3,
+		"{Hi|Hello} {{lead.firstName|you}}!": 2,
+		"{Hi|{Good morning|Good evening}}":   3,
+		"{a|b} {c|d|e}":                      6,
+		`Braces \{ and \| stay`:              1,
+		`{a\|b|c}`:                           2,
+	} {
+		parsed, err := parseSpintax(text)
+		if err != nil {
+			t.Errorf("%q: %v", text, err)
+			continue
+		}
+		if got := parsed.combinations(); got != want {
+			t.Errorf("%q: expected %d combinations, got %d", text, want, got)
+		}
+	}
+
+	for _, text := range []string{"{Hi|Hello", "Hi}", "{Hi}", "{{lead.first name}}", "{{lead.firstName}"} {
+		if _, err := parseSpintax(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+
+	if parsed, _ := parseSpintax(strings.Repeat("{a|b|c|d|e|f|g|h|i|j}", 12)); parsed.combinations() != maxCombinations {
+		t.Errorf("expected the combinations to be capped")
+	}
+}
+
+func TestSpintaxRenderingIsSeededPerRecipient(t *testing.T) {
+	parsed, err := parseSpintax("{Hi|Hello|Hey|Howdy} {{lead.firstName}}, {how are you|how is it going}?")
+	if err != nil {
+		t.Fatalf("parseSpintax: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, recipient := range []string{"1@s.whatsapp.net", "2@s.whatsapp.net", "3@s.whatsapp.net", "4@s.whatsapp.net", "5@s.whatsapp.net"} {
+		text := parsed.render(variationRand("tpl_1", recipient))
+		if again := parsed.render(variationRand("tpl_1", recipient)); again != text {
+			t.Errorf("%s: expected the same text on retry, got %q and %q", recipient, text, again)
+		}
+		if !strings.Contains(text, " {{lead.firstName}}, ") {
+			t.Errorf("expected the placeholder to be kept, got %q", text)
+		}
+		seen[text] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected recipients to get different texts, got %v", seen)
+	}
+
+	rng := variationRand("tpl_1", "1@s.whatsapp.net")
+	for i := 0; i < 20; i++ {
+		varied := varyWhitespace("a b c", rng)
+		if varied != "a b c" && varied != "a  b c" && varied != "a b  c" {
+			t.Fatalf("unexpected whitespace variation %q", varied)
+		}
+	}
+	if got := varyWhitespace("abc", rng); got != "abc" {
+		t.Errorf("expected a text without spaces to be kept, got %q", got)
+	}
+}
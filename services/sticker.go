@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register JPEG decoding
+	_ "image/png"  // register PNG decoding
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoding
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// stickerSize is the edge length WhatsApp renders stickers at
+	stickerSize = 512
+	// maxStickerSize is WhatsApp's limit for static stickers
+	maxStickerSize = 100 << 10
+	// maxStickerPosterize is how many low bits per color channel may be
+	// dropped to get a sticker under maxStickerSize
+	maxStickerPosterize = 4
+)
+
+func (s *WhatsAppMeowService) buildStickerMessage(client WhatsAppClient, organizationID, mediaURL string) (*waE2E.Message, error) {
+	media, err := s.uploadCached(organizationID, mediaURL, "sticker", func(media *preparedMedia) error {
+		sticker, err := convertSticker(media.data)
+		if err != nil {
+			return err
+		}
+		media.upload, err = client.Upload(context.Background(), sticker, whatsmeow.MediaImage)
+		if err != nil {
+			return fmt.Errorf("failed to upload media: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	upload := media.upload
+
+	return &waE2E.Message{
+		StickerMessage: &waE2E.StickerMessage{
+			Mimetype:      proto.String("image/webp"),
+			URL:           proto.String(upload.URL),
+			DirectPath:    proto.String(upload.DirectPath),
+			MediaKey:      upload.MediaKey,
+			FileEncSHA256: upload.FileEncSHA256,
+			FileSHA256:    upload.FileSHA256,
+			FileLength:    proto.Uint64(upload.FileLength),
+			Width:         proto.Uint32(stickerSize),
+			Height:        proto.Uint32(stickerSize),
+		},
+	}, nil
+}
+
+// convertSticker turns a PNG, JPEG or WebP image into a 512x512 WebP
+// sticker. The image is scaled to fit and centered on a transparent canvas.
+// Colors are reduced step by step if the result exceeds maxStickerSize.
+func convertSticker(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: sticker must be a PNG, JPEG or WebP image", ErrInvalidMessage)
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("%w: sticker image of %dx%d is too large", ErrInvalidMessage, config.Width, config.Height)
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: sticker must be a PNG, JPEG or WebP image", ErrInvalidMessage)
+	}
+	switch format {
+	case "png", "jpeg", "webp":
+	default:
+		return nil, fmt.Errorf("%w: unsupported sticker format %s", ErrInvalidMessage, format)
+	}
+
+	bounds := src.Bounds()
+	if bounds.Empty() {
+		return nil, fmt.Errorf("%w: sticker image is empty", ErrInvalidMessage)
+	}
+	width, height := stickerSize, stickerSize
+	if bounds.Dx() > bounds.Dy() {
+		height = max(1, bounds.Dy()*stickerSize/bounds.Dx())
+	} else {
+		width = max(1, bounds.Dx()*stickerSize/bounds.Dy())
+	}
+	offset := image.Pt((stickerSize-width)/2, (stickerSize-height)/2)
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, stickerSize, stickerSize))
+	draw.CatmullRom.Scale(canvas, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(width, height))}, src, bounds, draw.Over, nil)
+
+	for dropBits := 0; dropBits <= maxStickerPosterize; dropBits++ {
+		encoded, err := encodeWebP(posterize(canvas, dropBits))
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) <= maxStickerSize {
+			return encoded, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: sticker does not fit in %d KB", ErrInvalidMessage, maxStickerSize>>10)
+}
+
+// posterize clears the low bits of every color channel, which lengthens
+// runs and shrinks the lossless encoding. Fully transparent pixels are
+// blanked since their color is invisible anyway.
+func posterize(img *image.NRGBA, dropBits int) *image.NRGBA {
+	out := image.NewNRGBA(img.Rect)
+	mask := ^uint8(1<<dropBits - 1)
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] == 0 {
+			continue
+		}
+		out.Pix[i] = img.Pix[i] & mask
+		out.Pix[i+1] = img.Pix[i+1] & mask
+		out.Pix[i+2] = img.Pix[i+2] & mask
+		out.Pix[i+3] = img.Pix[i+3]
+	}
+	return out
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+const (
+	// EventStreamPath serves an organization's events as Server-Sent Events
+	// and EventSocketPath as WebSocket messages
+	EventStreamPath = "/api/whatsmeow/events"
+	EventSocketPath = "/api/whatsmeow/events/ws"
+
+	// defaultStreamTokenTTL applies when the configuration sets no lifetime
+	defaultStreamTokenTTL = time.Hour
+	// defaultStreamReplayEvents applies when the configuration sets no
+	// replay buffer size
+	defaultStreamReplayEvents = 1000
+	// streamSubscriberBuffer is how far a client may fall behind before it
+	// is dropped
+	streamSubscriberBuffer = 64
+	// streamIdleTimeout is how long an organization's replay buffer outlives
+	// its last client, which is how long that client has to resume
+	streamIdleTimeout = 10 * time.Minute
+)
+
+// ErrStreamTokenInvalid is returned for stream tokens with a wrong signature
+// or past their expiry
+var ErrStreamTokenInvalid = errors.New("invalid stream token")
+
+// EventStream fans published events out to the live clients of each
+// organization and keeps the latest ones so reconnecting clients can resume
+// where they left off
+type EventStream struct {
+	mu            sync.Mutex
+	replay        int
+	organizations map[string]*organizationStream
+	// prunedAt is when idle organizations were last forgotten
+	prunedAt time.Time
+}
+
+type organizationStream struct {
+	// buffered is a ring of the latest events; next is where the following
+	// event goes once it is full
+	buffered    []models.Event
+	next        int
+	subscribers map[*StreamSubscription]struct{}
+	// idleSince is when the last client left
+	idleSince time.Time
+}
+
+// StreamSubscription is one client's view of an organization's events
+type StreamSubscription struct {
+	// Replay holds the buffered events after the last one the client saw,
+	// led by a stream.reset event when that one is no longer buffered
+	Replay []models.Event
+	// Events delivers new events. It is closed when the subscription is
+	// closed, also when the client falls too far behind.
+	Events <-chan models.Event
+
+	events         chan models.Event
+	stream         *EventStream
+	organizationID string
+}
+
+// NewEventStream creates a stream keeping the latest replay events of each
+// organization
+func NewEventStream(replay int) *EventStream {
+	if replay <= 0 {
+		replay = defaultStreamReplayEvents
+	}
+	return &EventStream{
+		replay:        replay,
+		organizations: make(map[string]*organizationStream),
+	}
+}
+
+// Notify buffers an event and hands it to the organization's clients.
+// Clients whose queue is full are dropped rather than waited for; they can
+// reconnect and resume from the replay buffer. Events of organizations
+// nobody watched lately are not kept.
+func (e *EventStream) Notify(event models.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.prune(time.Now())
+	organization := e.organizations[event.OrganizationID]
+	if organization == nil {
+		return
+	}
+	if len(organization.buffered) < e.replay {
+		organization.buffered = append(organization.buffered, event)
+	} else {
+		organization.buffered[organization.next] = event
+		organization.next = (organization.next + 1) % e.replay
+	}
+
+	for subscription := range organization.subscribers {
+		select {
+		case subscription.events <- event:
+		default:
+			organization.unsubscribe(subscription)
+		}
+	}
+}
+
+// Subscribe starts delivering an organization's events. With a
+// lastEventID, the buffered events after it are replayed first.
+func (e *EventStream) Subscribe(organizationID, lastEventID string) *StreamSubscription {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.prune(time.Now())
+	events := make(chan models.Event, streamSubscriberBuffer)
+	subscription := &StreamSubscription{
+		Events:         events,
+		events:         events,
+		stream:         e,
+		organizationID: organizationID,
+	}
+
+	organization := e.organization(organizationID)
+	if lastEventID != "" {
+		subscription.Replay = organization.replayAfter(organizationID, lastEventID)
+	}
+	organization.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Close stops the subscription and closes its Events channel
+func (s *StreamSubscription) Close() {
+	s.stream.mu.Lock()
+	defer s.stream.mu.Unlock()
+
+	if organization := s.stream.organizations[s.organizationID]; organization != nil {
+		organization.unsubscribe(s)
+	}
+}
+
+func (o *organizationStream) unsubscribe(subscription *StreamSubscription) {
+	if _, ok := o.subscribers[subscription]; !ok {
+		return
+	}
+	delete(o.subscribers, subscription)
+	close(subscription.events)
+	if len(o.subscribers) == 0 {
+		o.idleSince = time.Now()
+	}
+}
+
+// prune forgets organizations, with their replay buffers, whose last client
+// left more than streamIdleTimeout ago. It scans at most once a minute.
+func (e *EventStream) prune(now time.Time) {
+	if now.Sub(e.prunedAt) < time.Minute {
+		return
+	}
+	e.prunedAt = now
+
+	for organizationID, organization := range e.organizations {
+		if len(organization.subscribers) == 0 && now.Sub(organization.idleSince) > streamIdleTimeout {
+			delete(e.organizations, organizationID)
+		}
+	}
+}
+
+func (e *EventStream) organization(organizationID string) *organizationStream {
+	organization := e.organizations[organizationID]
+	if organization == nil {
+		organization = &organizationStream{subscribers: make(map[*StreamSubscription]struct{})}
+		e.organizations[organizationID] = organization
+	}
+	return organization
+}
+
+// replayAfter returns the buffered events after lastEventID, oldest first.
+// When it is no longer buffered the client has missed events, so it gets a
+// stream.reset event followed by everything still buffered.
+func (o *organizationStream) replayAfter(organizationID, lastEventID string) []models.Event {
+	ordered := append(append([]models.Event(nil), o.buffered[o.next:]...), o.buffered[:o.next]...)
+	for i, event := range ordered {
+		if event.ID == lastEventID {
+			return ordered[i+1:]
+		}
+	}
+
+	reset := models.Event{
+		Type:           models.EventStreamReset,
+		OrganizationID: organizationID,
+		Timestamp:      time.Now().UTC(),
+		Data:           map[string]interface{}{"lastEventId": lastEventID},
+	}
+	return append([]models.Event{reset}, ordered...)
+}
+
+// UseEventStream publishes events to stream for clients holding a stream
+// token. signingKey signs the tokens; a random key is generated when it is
+// empty. Call it before serving requests.
+func (s *WhatsAppMeowService) UseEventStream(stream *EventStream, signingKey []byte) {
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		rand.Read(signingKey)
+	}
+	s.stream = stream
+	s.streamKey = signingKey
+
+	s.AddNotifier(stream)
+}
+
+// StreamToken returns a signed, time-limited token that lets a client, such
+// as a browser, read an organization's events
+func (s *WhatsAppMeowService) StreamToken(organizationID string) (string, time.Time, error) {
+	if s.stream == nil {
+		return "", time.Time{}, fmt.Errorf("event stream: %w", store.ErrNotFound)
+	}
+
+	ttl := time.Duration(s.config.StreamTokenTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultStreamTokenTTL
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	return strconv.FormatInt(expiresAt.Unix(), 10) + "." + s.signStreamToken(organizationID, expiresAt.Unix()), expiresAt, nil
+}
+
+// SubscribeEvents checks a stream token and subscribes to the events of its
+// organization, resuming after lastEventID when it is set
+func (s *WhatsAppMeowService) SubscribeEvents(organizationID, token, lastEventID string) (*StreamSubscription, error) {
+	if s.stream == nil {
+		return nil, fmt.Errorf("event stream: %w", store.ErrNotFound)
+	}
+
+	expires, signature, _ := strings.Cut(token, ".")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, ErrStreamTokenInvalid
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signStreamToken(organizationID, expiresAt))) {
+		return nil, ErrStreamTokenInvalid
+	}
+	if time.Now().Unix() > expiresAt {
+		return nil, fmt.Errorf("%w: token expired", ErrStreamTokenInvalid)
+	}
+	return s.stream.Subscribe(organizationID, lastEventID), nil
+}
+
+func (s *WhatsAppMeowService) signStreamToken(organizationID string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, s.streamKey)
+	fmt.Fprintf(mac, "stream\n%s\n%d", organizationID, expiresAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
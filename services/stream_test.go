@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/models"
+)
+
+func TestEventStreamReplay(t *testing.T) {
+	stream := NewEventStream(3)
+	ids := func(events []models.Event) []string {
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return ids
+	}
+
+	live := stream.Subscribe("org_1", "")
+	defer live.Close()
+	if len(live.Replay) != 0 {
+		t.Errorf("expected no replay without a last event ID, got %v", ids(live.Replay))
+	}
+
+	for i := 1; i <= 5; i++ {
+		stream.Notify(models.Event{ID: fmt.Sprintf("ev%d", i), Type: models.EventMessageReceived, OrganizationID: "org_1"})
+	}
+	stream.Notify(models.Event{ID: "other", Type: models.EventMessageReceived, OrganizationID: "org_2"})
+	for i := 1; i <= 5; i++ {
+		if event := <-live.Events; event.ID != fmt.Sprintf("ev%d", i) {
+			t.Fatalf("expected ev%d live, got %s", i, event.ID)
+		}
+	}
+
+	resumed := stream.Subscribe("org_1", "ev3")
+	if got := ids(resumed.Replay); !slices.Equal(got, []string{"ev4", "ev5"}) {
+		t.Errorf("expected the events after ev3, got %v", got)
+	}
+	resumed.Close()
+	resumed.Close()
+
+	// ev1 fell out of the buffer, so the client is told it missed events
+	gone := stream.Subscribe("org_1", "ev1")
+	gone.Close()
+	if len(gone.Replay) != 4 || gone.Replay[0].Type != models.EventStreamReset || gone.Replay[0].OrganizationID != "org_1" {
+		t.Fatalf("expected a reset followed by the buffer, got %+v", gone.Replay)
+	}
+	if got := ids(gone.Replay[1:]); !slices.Equal(got, []string{"ev3", "ev4", "ev5"}) {
+		t.Errorf("expected the buffered events oldest first, got %v", got)
+	}
+
+	stream.Notify(models.Event{ID: "ev6", OrganizationID: "org_1"})
+	if event := <-live.Events; event.ID != "ev6" {
+		t.Errorf("expected ev6 live, got %s", event.ID)
+	}
+	if _, ok := <-resumed.Events; ok {
+		t.Error("expected closed subscriptions to get nothing")
+	}
+
+	// A client that stops reading is dropped instead of blocking publishing
+	for i := 0; i <= streamSubscriberBuffer; i++ {
+		stream.Notify(models.Event{ID: fmt.Sprintf("flood%d", i), OrganizationID: "org_1"})
+	}
+	received := 0
+	for range live.Events {
+		received++
+	}
+	if received != streamSubscriberBuffer {
+		t.Errorf("expected the queued events before the drop, got %d", received)
+	}
+
+	// Organizations nobody watched lately are forgotten with their buffer
+	if _, ok := stream.organizations["org_2"]; ok {
+		t.Error("expected events of an unwatched organization not to be kept")
+	}
+	stream.organizations["org_1"].idleSince = time.Now().Add(-streamIdleTimeout - time.Second)
+	stream.prunedAt = time.Time{}
+	stream.Notify(models.Event{ID: "late", OrganizationID: "org_1"})
+	if len(stream.organizations) != 0 {
+		t.Fatalf("expected idle organizations to be pruned, got %d", len(stream.organizations))
+	}
+	resumed = stream.Subscribe("org_1", "flood0")
+	defer resumed.Close()
+	if len(resumed.Replay) != 1 || resumed.Replay[0].Type != models.EventStreamReset {
+		t.Errorf("expected only a reset after pruning, got %+v", resumed.Replay)
+	}
+}
+
+func TestStreamTokens(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+	if _, _, err := svc.StreamToken("org_1"); err == nil {
+		t.Fatal("expected an error without an event stream")
+	}
+	svc.UseEventStream(NewEventStream(10), nil)
+
+	token, expiresAt, err := svc.StreamToken("org_1")
+	if err != nil {
+		t.Fatalf("StreamToken: %v", err)
+	}
+	if time.Until(expiresAt) <= 0 {
+		t.Errorf("expected a token valid for a while, expires %v", expiresAt)
+	}
+
+	expires, signature, _ := strings.Cut(token, ".")
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	for name, check := range map[string]struct{ organizationID, token string }{
+		"other organization": {"org_2", token},
+		"tampered expiry":    {"org_1", expired + "." + signature},
+		"expired":            {"org_1", expired + "." + svc.signStreamToken("org_1", time.Now().Add(-time.Minute).Unix())},
+		"malformed":          {"org_1", expires},
+		"missing":            {"org_1", ""},
+	} {
+		if _, err := svc.SubscribeEvents(check.organizationID, check.token, ""); !errors.Is(err, ErrStreamTokenInvalid) {
+			t.Errorf("%s: expected an invalid token, got %v", name, err)
+		}
+	}
+
+	subscription, err := svc.SubscribeEvents("org_1", token, "")
+	if err != nil {
+		t.Fatalf("SubscribeEvents: %v", err)
+	}
+	defer subscription.Close()
+
+	// QR rotations, connection changes, messages and receipts all reach the
+	// stream
+	if err := svc.Connect("org_1", account.ID, ""); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	st.AddLead("org_1", "lead_1", testLeadJID.User)
+	fake.EmitQR("qr-code-1")
+	fake.Emit(&events.Connected{})
+	fake.EmitMessage(testLeadJID, "INBOUND1", &waE2E.Message{Conversation: proto.String("Hi")})
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "text",
+		MessageText:    "Hello",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	fake.EmitReceipt(testLeadJID, types.ReceiptTypeRead, messageID, "UNKNOWN")
+	fake.EmitDisconnected()
+
+	want := []models.EventType{
+		models.EventAccountQR,
+		models.EventAccountConnected,
+		models.EventMessageReceived,
+		models.EventMessageStatus,
+		models.EventAccountDisconnected,
+	}
+	var got []models.Event
+	for len(got) < len(want) {
+		select {
+		case event := <-subscription.Events:
+			got = append(got, event)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %v, got %+v", want, got)
+		}
+	}
+	for i, event := range got {
+		if event.Type != want[i] || event.OrganizationID != "org_1" || event.AccountID != account.ID || event.ID == "" {
+			t.Errorf("event %d: expected %s of account %s, got %+v", i, want[i], account.ID, event)
+		}
+	}
+	if message, ok := got[2].Data.(*models.WhatsAppMeowMessage); !ok || message.MessageID != "INBOUND1" {
+		t.Errorf("expected the received message, got %+v", got[2].Data)
+	}
+	status, _ := got[3].Data.(map[string]interface{})
+	if ids, _ := status["messageIds"].([]string); !slices.Equal(ids, []string{messageID}) || status["status"] != models.MessageStatusRead {
+		t.Errorf("expected the known message to be read, got %+v", status)
+	}
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsmeow-service/models"
+	"whatsmeow-service/store"
+)
+
+// ErrInvalidTemplate is returned when a template cannot be saved as given
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// languagePattern accepts language codes such as "en", "pt-BR" or "es_419"
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([_-][A-Za-z0-9]{2,8})*$`)
+
+// CreateTemplate adds a template to an organization
+func (s *WhatsAppMeowService) CreateTemplate(req models.TemplateRequest) (*models.WhatsAppMeowTemplate, error) {
+	template := templateFromRequest(req)
+	if err := s.validateTemplate(template); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemplateName(template); err != nil {
+		return nil, err
+	}
+	if err := s.templates.CreateTemplate(template); err != nil {
+		return nil, fmt.Errorf("failed to create template: %w", err)
+	}
+	return template, nil
+}
+
+// ListTemplates returns the templates of an organization by name
+func (s *WhatsAppMeowService) ListTemplates(organizationID string) ([]*models.WhatsAppMeowTemplate, error) {
+	templates, err := s.templates.ListTemplates(organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		templates = []*models.WhatsAppMeowTemplate{}
+	}
+	return templates, nil
+}
+
+// GetTemplate returns one of the organization's templates
+func (s *WhatsAppMeowService) GetTemplate(organizationID, templateID string) (*models.WhatsAppMeowTemplate, error) {
+	template, err := s.templates.GetTemplate(templateID)
+	if err != nil {
+		return nil, err
+	}
+	// Never leak another organization's template
+	if template.OrganizationID != organizationID {
+		return nil, fmt.Errorf("template %s: %w", templateID, store.ErrNotFound)
+	}
+	return template, nil
+}
+
+// UpdateTemplate replaces the name, variants and defaults of a template.
+// Messages already sent keep pointing at it.
+func (s *WhatsAppMeowService) UpdateTemplate(req models.TemplateRequest) (*models.WhatsAppMeowTemplate, error) {
+	existing, err := s.GetTemplate(req.OrganizationID, req.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	template := templateFromRequest(req)
+	template.ID = existing.ID
+	if err := s.validateTemplate(template); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemplateName(template); err != nil {
+		return nil, err
+	}
+	if err := s.templates.UpdateTemplate(template); err != nil {
+		return nil, fmt.Errorf("failed to update template: %w", err)
+	}
+	return template, nil
+}
+
+// DeleteTemplate removes a template
+func (s *WhatsAppMeowService) DeleteTemplate(organizationID, templateID string) error {
+	template, err := s.GetTemplate(organizationID, templateID)
+	if err != nil {
+		return err
+	}
+	return s.templates.DeleteTemplate(template.ID)
+}
+
+func templateFromRequest(req models.TemplateRequest) *models.WhatsAppMeowTemplate {
+	template := &models.WhatsAppMeowTemplate{
+		OrganizationID:  req.OrganizationID,
+		Name:            strings.TrimSpace(req.Name),
+		DefaultLanguage: normalizeLanguage(req.DefaultLanguage),
+		Defaults:        models.TemplateDefaults(req.Defaults),
+		Variation:       req.Variation,
+	}
+	for _, variant := range req.Variants {
+		variant.Language = normalizeLanguage(variant.Language)
+		variant.MessageType = strings.ToLower(variant.MessageType)
+		template.Variants = append(template.Variants, variant)
+	}
+	// The first variant is the default unless told otherwise
+	if template.DefaultLanguage == "" && len(template.Variants) > 0 {
+		template.DefaultLanguage = template.Variants[0].Language
+	}
+	return template
+}
+
+// normalizeLanguage writes language codes one way, so "pt_BR" and "pt-br"
+// name the same variant
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+}
+
+func (s *WhatsAppMeowService) validateTemplate(template *models.WhatsAppMeowTemplate) error {
+	if template.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTemplate)
+	}
+	if len(template.Variants) == 0 {
+		return fmt.Errorf("%w: at least one variant is required", ErrInvalidTemplate)
+	}
+
+	languages := make(map[string]bool)
+	for _, variant := range template.Variants {
+		if !languagePattern.MatchString(variant.Language) {
+			return fmt.Errorf("%w: invalid variant language %q", ErrInvalidTemplate, variant.Language)
+		}
+		if languages[variant.Language] {
+			return fmt.Errorf("%w: more than one variant for language %s", ErrInvalidTemplate, variant.Language)
+		}
+		languages[variant.Language] = true
+
+		if err := s.validateTemplateVariant(template.OrganizationID, variant); err != nil {
+			return err
+		}
+	}
+	if !languages[template.DefaultLanguage] {
+		return fmt.Errorf("%w: defaultLanguage %s has no variant", ErrInvalidTemplate, template.DefaultLanguage)
+	}
+
+	for name := range template.Defaults {
+		if match := placeholderPattern.FindStringSubmatch("{{" + name + "}}"); match == nil || match[1] != name {
+			return fmt.Errorf("%w: invalid default name %q", ErrInvalidTemplate, name)
+		}
+	}
+	for _, emoji := range template.Variation.Emojis {
+		if strings.TrimSpace(emoji) == "" {
+			return fmt.Errorf("%w: variation emojis cannot be blank", ErrInvalidTemplate)
+		}
+	}
+	return nil
+}
+
+func (s *WhatsAppMeowService) validateTemplateVariant(organizationID string, variant models.TemplateVariant) error {
+	// Malformed placeholders such as {{lead.first name}} fail as spintax
+	if _, err := parseSpintax(variant.MessageText); err != nil {
+		return fmt.Errorf("%w: %s variant: %v", ErrInvalidTemplate, variant.Language, err)
+	}
+
+	switch variant.MessageType {
+	case "text":
+		if strings.TrimSpace(variant.MessageText) == "" {
+			return fmt.Errorf("%w: %s text variant needs a messageText", ErrInvalidTemplate, variant.Language)
+		}
+	case "image", "video", "audio", "document":
+		parsed, err := url.Parse(variant.MediaURL)
+		if err != nil || variant.MediaURL == "" {
+			return fmt.Errorf("%w: %s %s variant needs a mediaUrl", ErrInvalidTemplate, variant.Language, variant.MessageType)
+		}
+		if err := s.fetcher.checkURL(organizationID, parsed); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported variant messageType %q", ErrInvalidTemplate, variant.MessageType)
+	}
+	return nil
+}
+
+// checkTemplateName keeps template names unique within an organization
+func (s *WhatsAppMeowService) checkTemplateName(template *models.WhatsAppMeowTemplate) error {
+	templates, err := s.templates.ListTemplates(template.OrganizationID)
+	if err != nil {
+		return err
+	}
+	for _, other := range templates {
+		if other.ID != template.ID && strings.EqualFold(other.Name, template.Name) {
+			return fmt.Errorf("%w: name %s is already in use", ErrInvalidTemplate, template.Name)
+		}
+	}
+	return nil
+}
+
+// applyTemplate fills in the message of a send request from its template
+func (s *WhatsAppMeowService) applyTemplate(req *models.SendMessageRequest) error {
+	template, err := s.GetTemplate(req.OrganizationID, req.TemplateID)
+	if err != nil {
+		return err
+	}
+
+	variant, _, err := renderTemplate(template, req.Language, req.Variables, recipientKey(req.ToJID))
+	if err != nil {
+		return err
+	}
+	req.MessageType = variant.MessageType
+	req.MessageText = variant.MessageText
+	req.MediaURL = variant.MediaURL
+	req.MediaType = variant.MediaType
+	return nil
+}
+
+// PreviewTemplate renders a template without sending it, for the given
+// recipients or as samples, to check its variables and variation
+func (s *WhatsAppMeowService) PreviewTemplate(req models.TemplatePreviewRequest) (*models.TemplatePreviewResponse, error) {
+	template, err := s.GetTemplate(req.OrganizationID, req.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := req.ToJIDs
+	if len(recipients) == 0 {
+		count := req.Count
+		if count <= 0 {
+			count = defaultPreviewCount
+		}
+		for i := 0; i < min(count, maxPreviewCount); i++ {
+			recipients = append(recipients, fmt.Sprintf("sample-%d", i))
+		}
+	} else if len(recipients) > maxPreviewCount {
+		return nil, fmt.Errorf("%w: at most %d toJIDs can be previewed", ErrInvalidMessage, maxPreviewCount)
+	}
+
+	response := &models.TemplatePreviewResponse{Success: true}
+	for _, recipient := range recipients {
+		variant, combinations, err := renderTemplate(template, req.Language, req.Variables, recipientKey(recipient))
+		if err != nil {
+			return nil, err
+		}
+		preview := models.TemplatePreview{
+			Language:    variant.Language,
+			MessageType: variant.MessageType,
+			MessageText: variant.MessageText,
+			MediaURL:    variant.MediaURL,
+		}
+		if len(req.ToJIDs) > 0 {
+			preview.ToJID = recipient
+		}
+		response.Combinations = combinations
+		response.Previews = append(response.Previews, preview)
+	}
+	return response, nil
+}
+
+const (
+	defaultPreviewCount = 5
+	maxPreviewCount     = 50
+)
+
+// renderTemplate renders the variant of a language for one recipient. The
+// same recipient always gets the same text, so retries are not new
+// variations. It also returns how many texts the spintax can produce.
+func renderTemplate(template *models.WhatsAppMeowTemplate, language string, variables map[string]string, recipient string) (models.TemplateVariant, int, error) {
+	variant := templateVariant(template, language)
+	values := make(map[string]string, len(template.Defaults)+len(variables))
+	for name, value := range template.Defaults {
+		values[name] = value
+	}
+	for name, value := range variables {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	if missing := missingPlaceholders(variant.MessageText, values); len(missing) > 0 {
+		return variant, 0, fmt.Errorf("%w: template %s is missing variables: %s", ErrInvalidMessage, template.Name, strings.Join(missing, ", "))
+	}
+
+	spun, err := parseSpintax(variant.MessageText)
+	if err != nil {
+		return variant, 0, fmt.Errorf("%w: template %s: %v", ErrInvalidMessage, template.Name, err)
+	}
+	rng := variationRand(template.ID, recipient)
+	text := renderPlaceholders(spun.render(rng), values)
+
+	if text != "" {
+		if template.Variation.Whitespace {
+			text = varyWhitespace(text, rng)
+		}
+		if emojis := template.Variation.Emojis; len(emojis) > 0 {
+			text += " " + emojis[rng.IntN(len(emojis))]
+		}
+	}
+	variant.MessageText = text
+	return variant, spun.combinations(), nil
+}
+
+// recipientKey names a recipient the same way whichever device or form of
+// its JID a send uses
+func recipientKey(toJID string) string {
+	jid, err := types.ParseJID(toJID)
+	if err != nil {
+		return toJID
+	}
+	return jid.ToNonAD().String()
+}
+
+// templateVariant picks the variant of a language, falling back to its base
+// language ("pt" for "pt-BR") and then to the template's default language
+func templateVariant(template *models.WhatsAppMeowTemplate, language string) models.TemplateVariant {
+	language = normalizeLanguage(language)
+	base, _, _ := strings.Cut(language, "-")
+
+	var fallback models.TemplateVariant
+	for _, variant := range template.Variants {
+		switch variant.Language {
+		case language:
+			return variant
+		case base:
+			fallback = variant
+		case template.DefaultLanguage:
+			if fallback.Language == "" {
+				fallback = variant
+			}
+		}
+	}
+	return fallback
+}
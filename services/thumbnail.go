@@ -0,0 +1,91 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register GIF decoding
+	"image/jpeg"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// thumbnailSize is the longest edge of the previews shown in chat lists
+	thumbnailSize = 72
+	// thumbnailQuality keeps previews to a couple of kilobytes
+	thumbnailQuality = 70
+	// maxThumbnailPixels skips previews of images too large to decode cheaply
+	maxThumbnailPixels = 50_000_000
+)
+
+// thumbnail is a JPEG preview of an image together with the dimensions of
+// the image it was made from
+type thumbnail struct {
+	jpeg          []byte
+	width, height uint32
+	// thumbWidth and thumbHeight are the dimensions of the preview itself
+	thumbWidth, thumbHeight uint32
+}
+
+// makeThumbnail scales an image down to a JPEG preview that fits in
+// thumbnailSize. Transparent areas are flattened onto white.
+func makeThumbnail(data []byte) (*thumbnail, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image of %dx%d cannot be previewed", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width > height {
+			width, height = thumbnailSize, max(1, height*thumbnailSize/width)
+		} else {
+			width, height = max(1, width*thumbnailSize/height), thumbnailSize
+		}
+	}
+
+	preview := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(preview, preview.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(preview, preview.Rect, src, bounds, draw.Over, nil)
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, preview, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+
+	return &thumbnail{
+		jpeg:        encoded.Bytes(),
+		width:       uint32(bounds.Dx()),
+		height:      uint32(bounds.Dy()),
+		thumbWidth:  uint32(width),
+		thumbHeight: uint32(height),
+	}, nil
+}
+
+// fetchThumbnail turns a caller-supplied preview image into a thumbnail.
+// An empty URL means no thumbnail.
+func (s *WhatsAppMeowService) fetchThumbnail(organizationID, thumbnailURL string) (*thumbnail, error) {
+	if thumbnailURL == "" {
+		return nil, nil
+	}
+
+	media, err := s.fetcher.fetch(organizationID, thumbnailURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch thumbnail: %w", err)
+	}
+	thumb, err := makeThumbnail(media.data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: thumbnailUrl must point to a PNG, JPEG, GIF or WebP image", ErrInvalidMessage)
+	}
+	return thumb, nil
+}
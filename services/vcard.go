@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+
+	"whatsmeow-service/models"
+)
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`)
+
+// buildContactMessage sends one contact as a contact message and several as
+// a contacts array message
+func buildContactMessage(contacts []models.ContactCard) (*waE2E.Message, error) {
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("%w: contacts are required", ErrInvalidMessage)
+	}
+
+	messages := make([]*waE2E.ContactMessage, 0, len(contacts))
+	for _, contact := range contacts {
+		if contact.Name == "" {
+			return nil, fmt.Errorf("%w: every contact needs a name", ErrInvalidMessage)
+		}
+		if len(contact.Phones) == 0 && len(contact.Emails) == 0 {
+			return nil, fmt.Errorf("%w: contact %s needs a phone or email", ErrInvalidMessage, contact.Name)
+		}
+		messages = append(messages, &waE2E.ContactMessage{
+			DisplayName: proto.String(contact.Name),
+			Vcard:       proto.String(buildVCard(contact)),
+		})
+	}
+
+	if len(messages) == 1 {
+		return &waE2E.Message{ContactMessage: messages[0]}, nil
+	}
+	return &waE2E.Message{
+		ContactsArrayMessage: &waE2E.ContactsArrayMessage{
+			DisplayName: proto.String(fmt.Sprintf("%d contacts", len(messages))),
+			Contacts:    messages,
+		},
+	}, nil
+}
+
+// buildVCard renders a contact as a vCard 3.0. The waid parameter lets
+// WhatsApp offer to message the number directly.
+func buildVCard(contact models.ContactCard) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	fmt.Fprintf(&b, "N:;%s;;;\n", vcardEscaper.Replace(contact.Name))
+	fmt.Fprintf(&b, "FN:%s\n", vcardEscaper.Replace(contact.Name))
+	if contact.Organization != "" {
+		fmt.Fprintf(&b, "ORG:%s\n", vcardEscaper.Replace(contact.Organization))
+	}
+	for _, phone := range contact.Phones {
+		if digits := phoneDigits(phone); digits != "" {
+			fmt.Fprintf(&b, "TEL;type=CELL;type=VOICE;waid=%s:%s\n", digits, vcardEscaper.Replace(phone))
+		} else {
+			fmt.Fprintf(&b, "TEL;type=CELL;type=VOICE:%s\n", vcardEscaper.Replace(phone))
+		}
+	}
+	for _, email := range contact.Emails {
+		fmt.Fprintf(&b, "EMAIL;type=INTERNET:%s\n", vcardEscaper.Replace(email))
+	}
+	b.WriteString("END:VCARD")
+	return b.String()
+}
+
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// voiceNoteMimeType is what WhatsApp clients expect for push-to-talk audio
+	voiceNoteMimeType = "audio/ogg; codecs=opus"
+	// waveformSamples is the number of bars WhatsApp draws for a voice note
+	waveformSamples = 64
+	// opusSampleRate is the rate Ogg/Opus granule positions count in
+	opusSampleRate = 48000
+)
+
+var errNotOggOpus = errors.New("not an Ogg/Opus stream")
+
+// buildVoiceNoteMessage sends Ogg/Opus audio as a push-to-talk voice note
+// with its duration and waveform
+func (s *WhatsAppMeowService) buildVoiceNoteMessage(client WhatsAppClient, organizationID, mediaURL string) (*waE2E.Message, error) {
+	media, err := s.uploadCached(organizationID, mediaURL, "ptt", func(media *preparedMedia) error {
+		voice, err := parseOggOpus(media.data)
+		if errors.Is(err, errNotOggOpus) {
+			return fmt.Errorf("%w: voice notes must be Ogg/Opus audio", ErrInvalidMessage)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: invalid voice note: %v", ErrInvalidMessage, err)
+		}
+		media.seconds, media.waveform = voice.seconds, voice.waveform
+
+		media.upload, err = client.Upload(context.Background(), media.data, whatsmeow.MediaAudio)
+		if err != nil {
+			return fmt.Errorf("failed to upload media: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &waE2E.Message{
+		AudioMessage: &waE2E.AudioMessage{
+			Mimetype:      proto.String(voiceNoteMimeType),
+			URL:           proto.String(media.upload.URL),
+			DirectPath:    proto.String(media.upload.DirectPath),
+			MediaKey:      media.upload.MediaKey,
+			FileEncSHA256: media.upload.FileEncSHA256,
+			FileSHA256:    media.upload.FileSHA256,
+			FileLength:    proto.Uint64(media.upload.FileLength),
+			PTT:           proto.Bool(true),
+			Seconds:       proto.Uint32(media.seconds),
+			Waveform:      media.waveform,
+		},
+	}, nil
+}
+
+// oggOpusInfo is what a voice note message needs to know about its audio
+type oggOpusInfo struct {
+	seconds  uint32
+	waveform []byte
+}
+
+// parseOggOpus reads the duration of the first Opus stream in an Ogg file
+// and approximates its waveform. Decoding Opus is out of reach without cgo,
+// so loudness is estimated from the bitrate over time: Opus spends more
+// bytes on loud passages and almost none on silence.
+func parseOggOpus(data []byte) (*oggOpusInfo, error) {
+	packets, granule, err := readOggPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(packets) < 2 || len(packets[0]) < 19 || !bytes.HasPrefix(packets[0], []byte("OpusHead")) {
+		return nil, errNotOggOpus
+	}
+	if !bytes.HasPrefix(packets[1], []byte("OpusTags")) {
+		return nil, fmt.Errorf("missing OpusTags header")
+	}
+	preSkip := int64(binary.LittleEndian.Uint16(packets[0][10:12]))
+
+	audio := packets[2:]
+	if len(audio) == 0 || granule <= preSkip {
+		return nil, fmt.Errorf("stream has no audio")
+	}
+	samples := granule - preSkip
+
+	// Average the bitrate of the packets falling into each bar
+	var size, covered [waveformSamples]float64
+	var position int64
+	for _, packet := range audio {
+		duration := opusPacketSamples(packet)
+		if duration <= 0 {
+			continue
+		}
+		bucket := min(position*waveformSamples/samples, waveformSamples-1)
+		size[bucket] += float64(len(packet))
+		covered[bucket] += float64(duration)
+		position += duration
+	}
+	var energy [waveformSamples]float64
+	for i := range energy {
+		if covered[i] > 0 {
+			energy[i] = size[i] / covered[i]
+		}
+	}
+
+	waveform := make([]byte, waveformSamples)
+	var loudest float64
+	for _, value := range energy {
+		loudest = max(loudest, value)
+	}
+	if loudest > 0 {
+		for i, value := range energy {
+			waveform[i] = byte(value / loudest * 100)
+		}
+	}
+
+	return &oggOpusInfo{
+		seconds:  uint32((samples + opusSampleRate/2) / opusSampleRate),
+		waveform: waveform,
+	}, nil
+}
+
+// readOggPackets reassembles the packets of the first logical stream in an
+// Ogg file and returns them with the stream's final granule position
+func readOggPackets(data []byte) ([][]byte, int64, error) {
+	var (
+		packets [][]byte
+		partial []byte
+		serial  uint32
+		granule int64
+	)
+	for pages := 0; len(data) > 0; pages++ {
+		if len(data) < 27 || !bytes.HasPrefix(data, []byte("OggS")) {
+			if pages == 0 {
+				return nil, 0, errNotOggOpus
+			}
+			return nil, 0, fmt.Errorf("truncated Ogg page")
+		}
+		header := data[:27]
+		segments := int(header[26])
+		if len(data) < 27+segments {
+			return nil, 0, fmt.Errorf("truncated Ogg page")
+		}
+		lacing := data[27 : 27+segments]
+		body := data[27+segments:]
+		size := 0
+		for _, l := range lacing {
+			size += int(l)
+		}
+		if len(body) < size {
+			return nil, 0, fmt.Errorf("truncated Ogg page")
+		}
+		body, data = body[:size], body[size:]
+
+		pageSerial := binary.LittleEndian.Uint32(header[14:18])
+		if pages == 0 {
+			serial = pageSerial
+		} else if pageSerial != serial {
+			continue
+		}
+		// -1 marks pages on which no packet ends
+		if position := int64(binary.LittleEndian.Uint64(header[6:14])); position != -1 {
+			granule = position
+		}
+
+		// A lacing value below 255 ends a packet; 255 continues it
+		for _, l := range lacing {
+			partial = append(partial, body[:l]...)
+			body = body[l:]
+			if l < 255 {
+				packets = append(packets, partial)
+				partial = nil
+			}
+		}
+	}
+	return packets, granule, nil
+}
+
+// opusPacketSamples returns how many 48 kHz samples an Opus packet decodes
+// to, from its TOC byte (RFC 6716 section 3.1)
+func opusPacketSamples(packet []byte) int64 {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := packet[0] >> 3
+	var frame int64
+	switch {
+	case config < 12: // SILK: 10, 20, 40 or 60 ms
+		frame = [4]int64{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10 or 20 ms
+		frame = [2]int64{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10 or 20 ms
+		frame = [4]int64{120, 240, 480, 960}[config%4]
+	}
+
+	switch packet[0] & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	default:
+		if len(packet) < 2 {
+			return 0
+		}
+		return int64(packet[1]&0x3f) * frame
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"whatsmeow-service/models"
+)
+
+// oggPage wraps packets in one Ogg page; the CRC is left empty since the
+// parser does not check it
+func oggPage(granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, packet := range packets {
+		n := len(packet)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, packet...)
+	}
+
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint64(header[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(header[14:18], 1)
+	header[26] = byte(len(lacing))
+	return append(append(header, lacing...), body...)
+}
+
+// testVoiceNote builds three seconds of 20 ms CELT packets whose size, and
+// so estimated loudness, rises in the second half
+func testVoiceNote() []byte {
+	head := []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	var file []byte
+	file = append(file, oggPage(0, head)...)
+	file = append(file, oggPage(0, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00"))...)
+
+	const packets = 150
+	for i := 0; i < packets; i += 50 {
+		var page [][]byte
+		for j := i; j < i+50; j++ {
+			size := 20
+			if j >= packets/2 {
+				size = 300
+			}
+			packet := make([]byte, size)
+			packet[0] = 19 << 3 // CELT, 20 ms, one frame
+			page = append(page, packet)
+		}
+		file = append(file, oggPage(int64(312+(i+50)*960), page...)...)
+	}
+	return file
+}
+
+func TestParseOggOpus(t *testing.T) {
+	voice, err := parseOggOpus(testVoiceNote())
+	if err != nil {
+		t.Fatalf("parseOggOpus: %v", err)
+	}
+	if voice.seconds != 3 {
+		t.Errorf("expected 3 seconds, got %d", voice.seconds)
+	}
+	if len(voice.waveform) != waveformSamples {
+		t.Fatalf("expected %d waveform samples, got %d", waveformSamples, len(voice.waveform))
+	}
+	if voice.waveform[0] >= voice.waveform[waveformSamples-1] || voice.waveform[waveformSamples-1] != 100 {
+		t.Errorf("expected the waveform to rise to 100, got %v", voice.waveform)
+	}
+
+	if _, err := parseOggOpus([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")); !errors.Is(err, errNotOggOpus) {
+		t.Errorf("expected WAV to be rejected, got %v", err)
+	}
+	vorbis := oggPage(0, []byte("\x01vorbis\x00\x00\x00\x00\x01\x44\xac\x00\x00"))
+	if _, err := parseOggOpus(vorbis); !errors.Is(err, errNotOggOpus) {
+		t.Errorf("expected Ogg/Vorbis to be rejected, got %v", err)
+	}
+}
+
+func TestSendVoiceNote(t *testing.T) {
+	svc, st, fake, account := newTestService(t)
+
+	files := map[string][]byte{"/note.ogg": testVoiceNote(), "/song.mp3": []byte("ID3\x03\x00\x00\x00")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[r.URL.Path])
+	}))
+	defer server.Close()
+
+	messageID, err := svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "audio",
+		MediaURL:       server.URL + "/note.ogg",
+		PTT:            true,
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	audio := fake.SentMessages()[0].Message.GetAudioMessage()
+	if !audio.GetPTT() || audio.GetSeconds() != 3 || len(audio.GetWaveform()) != waveformSamples || audio.GetMimetype() != voiceNoteMimeType {
+		t.Errorf("unexpected voice note: %v", audio)
+	}
+	if !bytes.Equal(fake.Uploads()[0], files["/note.ogg"]) {
+		t.Errorf("expected the Ogg file to be uploaded unchanged")
+	}
+	if stored, _ := st.GetMessage(account.ID, messageID); stored.MessageType != models.MessageTypeAudio {
+		t.Errorf("unexpected stored message: %+v", stored)
+	}
+
+	_, err = svc.SendMessage(models.SendMessageRequest{
+		OrganizationID: "org_1",
+		ToJID:          testLeadJID.String(),
+		MessageType:    "audio",
+		MediaURL:       server.URL + "/song.mp3",
+		PTT:            true,
+	})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected MP3 to be refused as a voice note, got %v", err)
+	}
+}
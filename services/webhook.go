@@ -0,0 +1,77 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"whatsmeow-service/models"
+)
+
+const webhookAttempts = 3
+
+// WebhookNotifier POSTs events as JSON to a fixed URL. When a secret is
+// configured the body is signed with HMAC-SHA256 in X-Whatsmeow-Signature.
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify delivers the event in the background, retrying failed attempts
+func (n *WebhookNotifier) Notify(event models.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode webhook event %s: %v", event.ID, err)
+		return
+	}
+
+	go func() {
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			err := n.deliver(body)
+			if err == nil {
+				return
+			}
+			log.Printf("Webhook delivery of event %s failed (attempt %d/%d): %v", event.ID, attempt, webhookAttempts, err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}()
+}
+
+func (n *WebhookNotifier) deliver(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		req.Header.Set("X-Whatsmeow-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,505 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+// Lossless WebP (VP8L) encoder. golang.org/x/image only decodes WebP, so
+// stickers are written with this small encoder: subtract-green and a
+// per-tile left/top predictor, run-length backward references and one
+// Huffman group per image. That is far from libwebp's ratio but keeps flat
+// sticker artwork well under WhatsApp's size limit without cgo.
+
+const (
+	vp8lTileBits        = 4
+	vp8lMaxRunLength    = 4096
+	vp8lMinRunLength    = 3
+	vp8lMaxCodeLength   = 15
+	vp8lMaxCLCodeLength = 7
+
+	vp8lLiteralCodes  = 256
+	vp8lLengthCodes   = 24
+	vp8lDistanceCodes = 40
+
+	// Distance codes of the pixel above and the pixel to the left
+	vp8lDistanceUp   = 1
+	vp8lDistanceLeft = 2
+)
+
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// encodeWebP encodes img as a lossless WebP file
+func encodeWebP(img *image.NRGBA) ([]byte, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return nil, fmt.Errorf("invalid WebP dimensions %dx%d", width, height)
+	}
+
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			r, g, b, a := row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]
+			if a != 0xff {
+				hasAlpha = true
+			}
+			// Subtract-green transform
+			argb[y*width+x] = uint32(a)<<24 | uint32(r-g)<<16 | uint32(g)<<8 | uint32(b-g)
+		}
+	}
+	residuals, modes := vp8lPredict(argb, width, height)
+
+	w := &bitWriter{}
+	w.write(0x2f, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if hasAlpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3)
+
+	// Transforms are listed in the order they were applied
+	w.write(1, 1)
+	w.write(2, 2) // subtract green
+	w.write(1, 1)
+	w.write(0, 2) // predictor
+	w.write(vp8lTileBits-2, 3)
+	w.writeImage(modes, vp8lTiles(width), false)
+	w.write(0, 1)
+
+	w.writeImage(residuals, width, true)
+
+	data := w.bytes()
+	chunkSize := len(data)
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+8+len(data)))
+	out.WriteString("WEBPVP8L")
+	binary.Write(&out, binary.LittleEndian, uint32(chunkSize))
+	out.Write(data)
+	return out.Bytes(), nil
+}
+
+func vp8lTiles(size int) int {
+	return (size + 1<<vp8lTileBits - 1) >> vp8lTileBits
+}
+
+// vp8lPredict replaces every pixel with its difference to the left or top
+// neighbour, choosing per tile whichever leaves smaller residuals. It
+// returns the residuals and the tile mode image.
+func vp8lPredict(argb []uint32, width, height int) ([]uint32, []uint32) {
+	tilesX, tilesY := vp8lTiles(width), vp8lTiles(height)
+	modes := make([]uint32, tilesX*tilesY)
+	residuals := make([]uint32, len(argb))
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx<<vp8lTileBits, ty<<vp8lTileBits
+			x1, y1 := min(x0+1<<vp8lTileBits, width), min(y0+1<<vp8lTileBits, height)
+
+			mode, bestCost := uint32(1), -1
+			for _, candidate := range []uint32{1, 2} {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += vp8lResidualCost(vp8lSub(argb[y*width+x], vp8lPrediction(argb, width, x, y, candidate)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					mode, bestCost = candidate, cost
+				}
+			}
+			modes[ty*tilesX+tx] = 0xff000000 | mode<<8
+
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = vp8lSub(argb[y*width+x], vp8lPrediction(argb, width, x, y, mode))
+				}
+			}
+		}
+	}
+
+	return residuals, modes
+}
+
+// vp8lPrediction applies the fixed edge rules of the format before mode
+func vp8lPrediction(argb []uint32, width, x, y int, mode uint32) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[y*width+x-1]
+	case x == 0:
+		return argb[(y-1)*width+x]
+	case mode == 1:
+		return argb[y*width+x-1]
+	default:
+		return argb[(y-1)*width+x]
+	}
+}
+
+// vp8lSub subtracts b from a per channel, modulo 256
+func vp8lSub(a, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		out |= uint32(uint8(a>>shift)-uint8(b>>shift)) << shift
+	}
+	return out
+}
+
+func vp8lResidualCost(residual uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(int8(residual >> shift))
+		if v < 0 {
+			v = -v
+		}
+		cost += v
+	}
+	return cost
+}
+
+// vp8lToken is either a literal pixel or a backward reference
+type vp8lToken struct {
+	pixel    uint32
+	length   int
+	distance int
+}
+
+// vp8lTokenize replaces runs of pixels equal to their left or top
+// neighbour with backward references
+func vp8lTokenize(pix []uint32, width int) []vp8lToken {
+	var tokens []vp8lToken
+	for i := 0; i < len(pix); {
+		left, up := 0, 0
+		if i > 0 {
+			for i+left < len(pix) && left < vp8lMaxRunLength && pix[i+left] == pix[i-1] {
+				left++
+			}
+		}
+		if i >= width {
+			for i+up < len(pix) && up < vp8lMaxRunLength && pix[i+up] == pix[i+up-width] {
+				up++
+			}
+		}
+
+		switch {
+		case left >= up && left >= vp8lMinRunLength:
+			tokens = append(tokens, vp8lToken{length: left, distance: vp8lDistanceLeft})
+			i += left
+		case up > left && up >= vp8lMinRunLength:
+			tokens = append(tokens, vp8lToken{length: up, distance: vp8lDistanceUp})
+			i += up
+		default:
+			tokens = append(tokens, vp8lToken{pixel: pix[i]})
+			i++
+		}
+	}
+	return tokens
+}
+
+// vp8lPrefix splits a backward reference length or distance into its prefix
+// symbol and extra bits
+func vp8lPrefix(value int) (symbol, extraBits int, extra uint32) {
+	x := value - 1
+	if x < 4 {
+		return x, 0, 0
+	}
+	highest := bits.Len(uint(x)) - 1
+	second := (x >> (highest - 1)) & 1
+	extraBits = highest - 1
+	return 2*highest + second, extraBits, uint32(x & (1<<extraBits - 1))
+}
+
+// huffmanCode is a canonical Huffman code of one symbol
+type huffmanCode struct {
+	bits   uint32
+	length int
+}
+
+// bitWriter packs values least significant bit first, as VP8L expects
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (w *bitWriter) write(value uint32, n int) {
+	w.acc |= uint64(value&(1<<n-1)) << w.nBits
+	w.nBits += uint(n)
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nBits -= 8
+	}
+}
+
+// writeCode emits a Huffman code starting with its most significant bit
+func (w *bitWriter) writeCode(code huffmanCode) {
+	for i := code.length - 1; i >= 0; i-- {
+		w.write(code.bits>>i, 1)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		return append(w.buf, byte(w.acc))
+	}
+	return w.buf
+}
+
+// writeImage entropy-codes an ARGB image with a single Huffman group
+func (w *bitWriter) writeImage(pix []uint32, width int, topLevel bool) {
+	w.write(0, 1) // no color cache
+	if topLevel {
+		w.write(0, 1) // no meta Huffman image
+	}
+
+	tokens := vp8lTokenize(pix, width)
+	green := make([]int, vp8lLiteralCodes+vp8lLengthCodes)
+	red := make([]int, vp8lLiteralCodes)
+	blue := make([]int, vp8lLiteralCodes)
+	alpha := make([]int, vp8lLiteralCodes)
+	distance := make([]int, vp8lDistanceCodes)
+	for _, token := range tokens {
+		if token.length == 0 {
+			green[token.pixel>>8&0xff]++
+			red[token.pixel>>16&0xff]++
+			blue[token.pixel&0xff]++
+			alpha[token.pixel>>24]++
+			continue
+		}
+		lengthSymbol, _, _ := vp8lPrefix(token.length)
+		distanceSymbol, _, _ := vp8lPrefix(token.distance)
+		green[vp8lLiteralCodes+lengthSymbol]++
+		distance[distanceSymbol]++
+	}
+
+	greenCodes := w.writeHuffmanCode(green)
+	redCodes := w.writeHuffmanCode(red)
+	blueCodes := w.writeHuffmanCode(blue)
+	alphaCodes := w.writeHuffmanCode(alpha)
+	distanceCodes := w.writeHuffmanCode(distance)
+
+	for _, token := range tokens {
+		if token.length == 0 {
+			w.writeCode(greenCodes[token.pixel>>8&0xff])
+			w.writeCode(redCodes[token.pixel>>16&0xff])
+			w.writeCode(blueCodes[token.pixel&0xff])
+			w.writeCode(alphaCodes[token.pixel>>24])
+			continue
+		}
+		lengthSymbol, lengthBits, lengthExtra := vp8lPrefix(token.length)
+		w.writeCode(greenCodes[vp8lLiteralCodes+lengthSymbol])
+		w.write(lengthExtra, lengthBits)
+		distanceSymbol, distanceBits, distanceExtra := vp8lPrefix(token.distance)
+		w.writeCode(distanceCodes[distanceSymbol])
+		w.write(distanceExtra, distanceBits)
+	}
+}
+
+// writeHuffmanCode writes the code for the given symbol frequencies and
+// returns it. One or two small symbols use the compact "simple" form.
+func (w *bitWriter) writeHuffmanCode(freq []int) []huffmanCode {
+	codes := make([]huffmanCode, len(freq))
+
+	var used []int
+	for symbol, count := range freq {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			codes[used[0]] = huffmanCode{bits: 0, length: 1}
+			codes[used[1]] = huffmanCode{bits: 1, length: 1}
+		}
+		return codes
+	}
+
+	lengths := huffmanLengths(freq, vp8lMaxCodeLength)
+	w.write(0, 1)
+	w.writeCodeLengths(lengths)
+	return canonicalCodes(lengths)
+}
+
+// writeCodeLengths writes the code lengths of a normal Huffman code,
+// collapsing runs of zeros
+func (w *bitWriter) writeCodeLengths(lengths []int) {
+	type clToken struct{ symbol, extra, extraBits int }
+	var tokens []clToken
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, clToken{symbol: lengths[i]})
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, clToken{symbol: 18, extra: run - 11, extraBits: 7})
+		case run >= 3:
+			tokens = append(tokens, clToken{symbol: 17, extra: run - 3, extraBits: 3})
+		default:
+			for j := 0; j < run; j++ {
+				tokens = append(tokens, clToken{symbol: 0})
+			}
+		}
+		i += run
+	}
+
+	freq := make([]int, len(vp8lCodeLengthOrder))
+	for _, token := range tokens {
+		freq[token.symbol]++
+	}
+	clLengths := huffmanLengths(freq, vp8lMaxCLCodeLength)
+	clCodes := canonicalCodes(clLengths)
+
+	count := 4
+	for i, symbol := range vp8lCodeLengthOrder {
+		if clLengths[symbol] != 0 && i+1 > count {
+			count = i + 1
+		}
+	}
+	w.write(uint32(count-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:count] {
+		w.write(uint32(clLengths[symbol]), 3)
+	}
+
+	w.write(0, 1) // lengths cover the whole alphabet
+	for _, token := range tokens {
+		w.writeCode(clCodes[token.symbol])
+		w.write(uint32(token.extra), token.extraBits)
+	}
+}
+
+// huffmanLengths computes code lengths no longer than limit. At least two
+// symbols always get a code so the result is a complete prefix code.
+func huffmanLengths(freq []int, limit int) []int {
+	weights := make([]int, len(freq))
+	copy(weights, freq)
+	nonZero := 0
+	for _, weight := range weights {
+		if weight > 0 {
+			nonZero++
+		}
+	}
+	for symbol := 0; nonZero < 2; symbol++ {
+		if weights[symbol] == 0 {
+			weights[symbol] = 1
+			nonZero++
+		}
+	}
+
+	// Flatten the distribution until the tree fits in the limit
+	for floor := 1; ; floor *= 2 {
+		clamped := make([]int, len(weights))
+		for symbol, weight := range weights {
+			if weight > 0 {
+				clamped[symbol] = max(weight, floor)
+			}
+		}
+		lengths := huffmanTreeDepths(clamped)
+		longest := 0
+		for _, length := range lengths {
+			longest = max(longest, length)
+		}
+		if longest <= limit {
+			return lengths
+		}
+	}
+}
+
+// huffmanTreeDepths builds a Huffman tree and returns each leaf's depth
+func huffmanTreeDepths(weights []int) []int {
+	type node struct{ weight, parent int }
+	var nodes []node
+	leaf := make(map[int]int)
+	var active []int
+	for symbol, weight := range weights {
+		if weight > 0 {
+			leaf[symbol] = len(nodes)
+			active = append(active, len(nodes))
+			nodes = append(nodes, node{weight: weight, parent: -1})
+		}
+	}
+
+	for len(active) > 1 {
+		// Pick the two lightest nodes
+		for pass := 0; pass < 2; pass++ {
+			lightest := pass
+			for i := pass + 1; i < len(active); i++ {
+				if nodes[active[i]].weight < nodes[active[lightest]].weight {
+					lightest = i
+				}
+			}
+			active[pass], active[lightest] = active[lightest], active[pass]
+		}
+		parent := len(nodes)
+		nodes = append(nodes, node{weight: nodes[active[0]].weight + nodes[active[1]].weight, parent: -1})
+		nodes[active[0]].parent = parent
+		nodes[active[1]].parent = parent
+		active = append(active[2:], parent)
+	}
+
+	depths := make([]int, len(weights))
+	for symbol, index := range leaf {
+		for n := index; nodes[n].parent >= 0; n = nodes[n].parent {
+			depths[symbol]++
+		}
+	}
+	return depths
+}
+
+// canonicalCodes assigns canonical Huffman codes to the given lengths
+func canonicalCodes(lengths []int) []huffmanCode {
+	var count [vp8lMaxCodeLength + 1]uint32
+	for _, length := range lengths {
+		count[length]++
+	}
+	count[0] = 0
+
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for length := 1; length <= vp8lMaxCodeLength; length++ {
+		code = (code + count[length-1]) << 1
+		next[length] = code
+	}
+
+	codes := make([]huffmanCode, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			codes[symbol] = huffmanCode{bits: next[length], length: length}
+			next[length]++
+		}
+	}
+	return codes
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	noise := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	rng.Read(noise.Pix)
+
+	flat := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			if (x-256)*(x-256)+(y-256)*(y-256) < 200*200 {
+				flat.SetNRGBA(x, y, color.NRGBA{R: uint8(x / 2), G: 120, B: uint8(y / 2), A: 255})
+			}
+		}
+	}
+
+	opaque := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	opaque.SetNRGBA(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+
+	for name, img := range map[string]*image.NRGBA{"noise": noise, "flat": flat, "single pixel": opaque} {
+		t.Run(name, func(t *testing.T) {
+			data, err := encodeWebP(img)
+			if err != nil {
+				t.Fatalf("encodeWebP: %v", err)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("webp.Decode: %v", err)
+			}
+			got, ok := decoded.(*image.NRGBA)
+			if !ok || got.Rect != img.Rect {
+				t.Fatalf("unexpected decoded image %T %v", decoded, decoded.Bounds())
+			}
+			for y := 0; y < img.Rect.Dy(); y++ {
+				for x := 0; x < img.Rect.Dx(); x++ {
+					if got.NRGBAAt(x, y) != img.NRGBAAt(x, y) {
+						t.Fatalf("pixel %d,%d: got %v, want %v", x, y, got.NRGBAAt(x, y), img.NRGBAAt(x, y))
+					}
+				}
+			}
+		})
+	}
+}
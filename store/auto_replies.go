@@ -0,0 +1,27 @@
+package store
+
+import (
+	"time"
+
+	"whatsmeow-service/models"
+)
+
+// AutoReplyStore persists auto-reply rules and when they last answered each
+// contact
+type AutoReplyStore interface {
+	CreateAutoReplyRule(rule *models.WhatsAppMeowAutoReplyRule) error
+	GetAutoReplyRule(id string) (*models.WhatsAppMeowAutoReplyRule, error)
+	// ListAutoReplyRules returns an account's rules in the order they are
+	// tried: by priority, then oldest first
+	ListAutoReplyRules(accountID string) ([]*models.WhatsAppMeowAutoReplyRule, error)
+	UpdateAutoReplyRule(rule *models.WhatsAppMeowAutoReplyRule) error
+	DeleteAutoReplyRule(id string) error
+
+	// LastAutoReply returns when a rule last answered a contact, or
+	// ErrNotFound
+	LastAutoReply(ruleID, contactJID string) (time.Time, error)
+	// ClaimAutoReply records that a rule answers a contact at the given time,
+	// unless it already did so after notBefore. It reports whether the
+	// claim succeeded.
+	ClaimAutoReply(ruleID, contactJID string, at, notBefore time.Time) (bool, error)
+}
@@ -0,0 +1,14 @@
+package store
+
+import "whatsmeow-service/models"
+
+// GroupStore persists the groups accounts are in
+type GroupStore interface {
+	// SaveGroup inserts a group or replaces the stored copy of the same
+	// group of the account
+	SaveGroup(group *models.WhatsAppMeowGroup) error
+	GetGroup(accountID, jid string) (*models.WhatsAppMeowGroup, error)
+	// ListGroups returns an account's groups by name
+	ListGroups(accountID string) ([]*models.WhatsAppMeowGroup, error)
+	DeleteGroup(accountID, jid string) error
+}
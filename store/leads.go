@@ -0,0 +1,41 @@
+package store
+
+import (
+	"strings"
+)
+
+// LeadStore resolves WhatsApp contacts to SkyFunnel leads
+type LeadStore interface {
+	// FindLeadByPhone returns the ID of the organization's lead whose phone
+	// number normalizes to the given digits, or ErrNotFound
+	FindLeadByPhone(organizationID, phoneDigits string) (string, error)
+}
+
+// LeadLookup names the table and columns leads are looked up in
+type LeadLookup struct {
+	Table              string
+	IDColumn           string
+	PhoneColumn        string
+	OrganizationColumn string
+}
+
+// DefaultLeadLookup matches the SkyFunnel "Lead" table
+var DefaultLeadLookup = LeadLookup{
+	Table:              "Lead",
+	IDColumn:           "id",
+	PhoneColumn:        "phone",
+	OrganizationColumn: "organizationId",
+}
+
+// NormalizePhone keeps the digits of a phone number and drops a leading 00
+// international prefix, so "+1 (555) 010-0002" and "001 555 0100002"
+// compare equal
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	return strings.TrimPrefix(digits, "00")
+}
@@ -0,0 +1,867 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"whatsmeow-service/models"
+)
+
+// Memory implements Store in process memory. It is meant for tests and
+// local development; nothing survives a restart.
+type Memory struct {
+	mu       sync.RWMutex
+	nextID   int
+	accounts map[string]*models.WhatsAppMeowAccount
+	messages map[messageKey]*models.WhatsAppMeowMessage
+	// reactions maps a message to its reactions keyed by sender JID
+	reactions map[messageKey]map[string]models.WhatsAppMeowReaction
+	// leads maps an organization to the normalized phone of each lead ID
+	leads map[string]map[string]string
+	// suppressions maps an organization to its suppressed JIDs
+	suppressions map[string]map[string]models.WhatsAppMeowSuppression
+	rules        map[string]*models.WhatsAppMeowAutoReplyRule
+	// autoReplies maps a rule ID to when it last answered each contact
+	autoReplies map[string]map[string]time.Time
+	templates   map[string]*models.WhatsAppMeowTemplate
+	// groups maps an account to its groups keyed by JID
+	groups map[string]map[string]*models.WhatsAppMeowGroup
+}
+
+// messageKey identifies a stored message; WhatsApp message IDs are only
+// unique within the account that holds them
+type messageKey struct {
+	accountID string
+	messageID string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		accounts:  make(map[string]*models.WhatsAppMeowAccount),
+		messages:  make(map[messageKey]*models.WhatsAppMeowMessage),
+		reactions: make(map[messageKey]map[string]models.WhatsAppMeowReaction),
+		leads:     make(map[string]map[string]string),
+
+		suppressions: make(map[string]map[string]models.WhatsAppMeowSuppression),
+		rules:        make(map[string]*models.WhatsAppMeowAutoReplyRule),
+		autoReplies:  make(map[string]map[string]time.Time),
+		templates:    make(map[string]*models.WhatsAppMeowTemplate),
+		groups:       make(map[string]map[string]*models.WhatsAppMeowGroup),
+	}
+}
+
+func (m *Memory) newID() string {
+	m.nextID++
+	return strconv.Itoa(m.nextID)
+}
+
+// CreateAccount inserts a new account and fills in its generated fields
+func (m *Memory) CreateAccount(account *models.WhatsAppMeowAccount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.accounts {
+		if existing.DeviceID == account.DeviceID {
+			return fmt.Errorf("device ID %s is already in use", account.DeviceID)
+		}
+	}
+
+	now := time.Now()
+	if account.ID == "" {
+		account.ID = m.newID()
+	}
+	if account.ConnectionStatus == "" {
+		account.ConnectionStatus = models.ConnectionStatusDisconnected
+	}
+	if account.ReadReceipts == "" {
+		account.ReadReceipts = models.ReadReceiptsNever
+	}
+	account.CreatedAt = now
+	account.UpdatedAt = now
+
+	stored := *account
+	m.accounts[account.ID] = &stored
+	return nil
+}
+
+// GetAccount retrieves an account by its ID
+func (m *Memory) GetAccount(id string) (*models.WhatsAppMeowAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	account, ok := m.accounts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *account
+	return &found, nil
+}
+
+// GetAccountByOrganization retrieves the oldest account of an organization
+func (m *Memory) GetAccountByOrganization(organizationID string) (*models.WhatsAppMeowAccount, error) {
+	accounts, err := m.ListAccounts(organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, ErrNotFound
+	}
+	return accounts[0], nil
+}
+
+// ListAccounts returns all accounts of an organization, oldest first
+func (m *Memory) ListAccounts(organizationID string) ([]*models.WhatsAppMeowAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var accounts []*models.WhatsAppMeowAccount
+	for _, account := range m.accounts {
+		if account.OrganizationID == organizationID {
+			found := *account
+			accounts = append(accounts, &found)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].CreatedAt.Equal(accounts[j].CreatedAt) {
+			return accounts[i].ID < accounts[j].ID
+		}
+		return accounts[i].CreatedAt.Before(accounts[j].CreatedAt)
+	})
+
+	return accounts, nil
+}
+
+// UpdateAccount overwrites the mutable fields of an account
+func (m *Memory) UpdateAccount(account *models.WhatsAppMeowAccount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.accounts[account.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	account.OrganizationID = existing.OrganizationID
+	account.CreatedAt = existing.CreatedAt
+	account.UpdatedAt = time.Now()
+	stored := *account
+	m.accounts[account.ID] = &stored
+	return nil
+}
+
+// UpdateConnectionStatus changes only the connection flags of an account
+func (m *Memory) UpdateConnectionStatus(id string, status models.WhatsAppMeowConnectionStatus, isConnected bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.accounts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	account.ConnectionStatus = status
+	account.IsConnected = isConnected
+	account.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeleteAccount removes an account together with its messages
+func (m *Memory) DeleteAccount(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.accounts[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.accounts, id)
+	for key := range m.messages {
+		if key.accountID == id {
+			delete(m.messages, key)
+			delete(m.reactions, key)
+		}
+	}
+	for ruleID, rule := range m.rules {
+		if rule.WhatsAppMeowAccountID == id {
+			delete(m.rules, ruleID)
+			delete(m.autoReplies, ruleID)
+		}
+	}
+	delete(m.groups, id)
+	return nil
+}
+
+// InsertMessage stores a new message and fills in its generated ID
+func (m *Memory) InsertMessage(message *models.WhatsAppMeowMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.accounts[message.WhatsAppMeowAccountID]; !ok {
+		return fmt.Errorf("account %s does not exist", message.WhatsAppMeowAccountID)
+	}
+	key := messageKey{message.WhatsAppMeowAccountID, message.MessageID}
+	if _, ok := m.messages[key]; ok {
+		return fmt.Errorf("message ID %s is already in use", message.MessageID)
+	}
+
+	message.ID = m.newID()
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+
+	stored := *message
+	m.messages[key] = &stored
+	return nil
+}
+
+// UpdateMessage overwrites the mutable fields of a message
+func (m *Memory) UpdateMessage(message *models.WhatsAppMeowMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := messageKey{message.WhatsAppMeowAccountID, message.MessageID}
+	existing, ok := m.messages[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	updated := *existing
+	updated.LeadID = message.LeadID
+	updated.MessageText = message.MessageText
+	updated.MediaURL = message.MediaURL
+	updated.MediaType = message.MediaType
+	updated.IsSent = message.IsSent
+	updated.IsDelivered = message.IsDelivered
+	updated.IsRead = message.IsRead
+	updated.SentAt = message.SentAt
+	updated.DeliveredAt = message.DeliveredAt
+	updated.ReadAt = message.ReadAt
+	updated.ErrorCode = message.ErrorCode
+	updated.ErrorMessage = message.ErrorMessage
+	updated.RetryCount = message.RetryCount
+	m.messages[key] = &updated
+	return nil
+}
+
+// GetMessage retrieves a message of an account by its WhatsApp message ID
+func (m *Memory) GetMessage(accountID, messageID string) (*models.WhatsAppMeowMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	message, ok := m.messages[messageKey{accountID, messageID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.copyMessage(message), nil
+}
+
+// ListMessages returns messages matching the filter, newest first
+func (m *Memory) ListMessages(filter MessageFilter) ([]*models.WhatsAppMeowMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []*models.WhatsAppMeowMessage
+	for _, message := range m.messages {
+		if !m.inOrganization(message, filter.OrganizationID) {
+			continue
+		}
+		if filter.AccountID != "" && message.WhatsAppMeowAccountID != filter.AccountID {
+			continue
+		}
+		if filter.MessageID != "" && message.MessageID != filter.MessageID {
+			continue
+		}
+		if filter.LeadID != "" && (message.LeadID == nil || *message.LeadID != filter.LeadID) {
+			continue
+		}
+		if filter.FromJID != "" && message.FromJID != filter.FromJID {
+			continue
+		}
+		if filter.ToJID != "" && message.ToJID != filter.ToJID {
+			continue
+		}
+		if filter.ChatJID != "" && message.FromJID != filter.ChatJID && message.ToJID != filter.ChatJID {
+			continue
+		}
+		if filter.HasLead && message.LeadID == nil {
+			continue
+		}
+		if filter.TemplateID != "" && (message.TemplateID == nil || *message.TemplateID != filter.TemplateID) {
+			continue
+		}
+		if filter.FromMe != nil && message.IsFromMe != *filter.FromMe {
+			continue
+		}
+		if filter.MessageType != "" && message.MessageType != filter.MessageType {
+			continue
+		}
+		if filter.Status != "" && messageStatus(message) != filter.Status {
+			continue
+		}
+		if !filter.Since.IsZero() && message.Timestamp.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !message.Timestamp.Before(filter.Until) {
+			continue
+		}
+		if filter.After != nil && !filter.After.before(message) {
+			continue
+		}
+		messages = append(messages, m.copyMessage(message))
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return newerFirst(messages[i], messages[j])
+	})
+	if filter.Limit > 0 && len(messages) > filter.Limit {
+		messages = messages[:filter.Limit]
+	}
+
+	return messages, nil
+}
+
+// ListConversations returns the chats of matching messages, most recently
+// active first
+func (m *Memory) ListConversations(filter ConversationFilter) ([]*models.WhatsAppMeowConversation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type chatKey struct{ account, chat string }
+	byChat := make(map[chatKey]*models.WhatsAppMeowConversation)
+	for _, message := range m.messages {
+		if !m.inOrganization(message, filter.OrganizationID) {
+			continue
+		}
+		if filter.AccountID != "" && message.WhatsAppMeowAccountID != filter.AccountID {
+			continue
+		}
+
+		key := chatKey{message.WhatsAppMeowAccountID, conversationJID(message)}
+		conversation, ok := byChat[key]
+		if !ok {
+			conversation = &models.WhatsAppMeowConversation{WhatsAppMeowAccountID: key.account, ChatJID: key.chat}
+			byChat[key] = conversation
+		}
+		if !message.IsFromMe && !message.IsRead {
+			conversation.UnreadCount++
+		}
+		if conversation.LastMessage == nil || newerFirst(message, conversation.LastMessage) {
+			conversation.LastMessage = message
+		}
+	}
+
+	var conversations []*models.WhatsAppMeowConversation
+	for _, conversation := range byChat {
+		if filter.After != nil && !filter.After.before(conversation.LastMessage) {
+			continue
+		}
+		conversations = append(conversations, conversation)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return newerFirst(conversations[i].LastMessage, conversations[j].LastMessage)
+	})
+	if filter.Limit > 0 && len(conversations) > filter.Limit {
+		conversations = conversations[:filter.Limit]
+	}
+
+	for _, conversation := range conversations {
+		conversation.LeadID = m.latestLeadID(conversation.WhatsAppMeowAccountID, conversation.ChatJID)
+		conversation.LastMessage = m.copyMessage(conversation.LastMessage)
+		conversation.LastActivity = conversation.LastMessage.Timestamp
+	}
+	return conversations, nil
+}
+
+// AddLead registers a lead for FindLeadByPhone, standing in for the
+// SkyFunnel "Lead" table
+func (m *Memory) AddLead(organizationID, leadID, phone string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.leads[organizationID] == nil {
+		m.leads[organizationID] = make(map[string]string)
+	}
+	m.leads[organizationID][leadID] = NormalizePhone(phone)
+}
+
+func (m *Memory) FindLeadByPhone(organizationID, phoneDigits string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found string
+	for leadID, phone := range m.leads[organizationID] {
+		if phoneDigits != "" && phone == phoneDigits && (found == "" || leadID < found) {
+			found = leadID
+		}
+	}
+	if found == "" {
+		return "", ErrNotFound
+	}
+	return found, nil
+}
+
+func (m *Memory) AddSuppression(suppression *models.WhatsAppMeowSuppression) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if suppression.CreatedAt.IsZero() {
+		suppression.CreatedAt = time.Now()
+	}
+	if m.suppressions[suppression.OrganizationID] == nil {
+		m.suppressions[suppression.OrganizationID] = make(map[string]models.WhatsAppMeowSuppression)
+	}
+	m.suppressions[suppression.OrganizationID][suppression.JID] = *suppression
+	return nil
+}
+
+func (m *Memory) RemoveSuppression(organizationID, jid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.suppressions[organizationID][jid]; !ok {
+		return ErrNotFound
+	}
+	delete(m.suppressions[organizationID], jid)
+	return nil
+}
+
+func (m *Memory) IsSuppressed(organizationID string, jids ...string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, jid := range jids {
+		if _, ok := m.suppressions[organizationID][jid]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) CreateAutoReplyRule(rule *models.WhatsAppMeowAutoReplyRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.accounts[rule.WhatsAppMeowAccountID]; !ok {
+		return fmt.Errorf("account %s does not exist", rule.WhatsAppMeowAccountID)
+	}
+	now := time.Now()
+	rule.ID = m.newID()
+	rule.CreatedAt, rule.UpdatedAt = now, now
+
+	stored := *rule
+	m.rules[rule.ID] = &stored
+	return nil
+}
+
+func (m *Memory) GetAutoReplyRule(id string) (*models.WhatsAppMeowAutoReplyRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rule, ok := m.rules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *rule
+	return &found, nil
+}
+
+func (m *Memory) ListAutoReplyRules(accountID string) ([]*models.WhatsAppMeowAutoReplyRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rules []*models.WhatsAppMeowAutoReplyRule
+	for _, rule := range m.rules {
+		if rule.WhatsAppMeowAccountID == accountID {
+			found := *rule
+			rules = append(rules, &found)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		if !rules[i].CreatedAt.Equal(rules[j].CreatedAt) {
+			return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+func (m *Memory) UpdateAutoReplyRule(rule *models.WhatsAppMeowAutoReplyRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.rules[rule.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	rule.WhatsAppMeowAccountID = existing.WhatsAppMeowAccountID
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+
+	stored := *rule
+	m.rules[rule.ID] = &stored
+	return nil
+}
+
+func (m *Memory) DeleteAutoReplyRule(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.rules, id)
+	delete(m.autoReplies, id)
+	return nil
+}
+
+func (m *Memory) LastAutoReply(ruleID, contactJID string) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	at, ok := m.autoReplies[ruleID][contactJID]
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+	return at, nil
+}
+
+func (m *Memory) ClaimAutoReply(ruleID, contactJID string, at, notBefore time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.autoReplies[ruleID][contactJID]; ok && last.After(notBefore) {
+		return false, nil
+	}
+	if m.autoReplies[ruleID] == nil {
+		m.autoReplies[ruleID] = make(map[string]time.Time)
+	}
+	m.autoReplies[ruleID][contactJID] = at
+	return true, nil
+}
+
+func (m *Memory) CreateTemplate(template *models.WhatsAppMeowTemplate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.templates {
+		if existing.OrganizationID == template.OrganizationID && existing.Name == template.Name {
+			return fmt.Errorf("template name %s is already in use", template.Name)
+		}
+	}
+	now := time.Now()
+	template.ID = m.newID()
+	template.CreatedAt, template.UpdatedAt = now, now
+
+	m.templates[template.ID] = copyTemplate(template)
+	return nil
+}
+
+func (m *Memory) GetTemplate(id string) (*models.WhatsAppMeowTemplate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	template, ok := m.templates[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyTemplate(template), nil
+}
+
+func (m *Memory) ListTemplates(organizationID string) ([]*models.WhatsAppMeowTemplate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var templates []*models.WhatsAppMeowTemplate
+	for _, template := range m.templates {
+		if template.OrganizationID == organizationID {
+			templates = append(templates, copyTemplate(template))
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func (m *Memory) UpdateTemplate(template *models.WhatsAppMeowTemplate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.templates[template.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, other := range m.templates {
+		if other.ID != template.ID && other.OrganizationID == existing.OrganizationID && other.Name == template.Name {
+			return fmt.Errorf("template name %s is already in use", template.Name)
+		}
+	}
+	template.OrganizationID = existing.OrganizationID
+	template.CreatedAt = existing.CreatedAt
+	template.UpdatedAt = time.Now()
+
+	m.templates[template.ID] = copyTemplate(template)
+	return nil
+}
+
+func (m *Memory) DeleteTemplate(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.templates[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.templates, id)
+	return nil
+}
+
+// copyTemplate keeps callers from sharing a stored template's variants
+func copyTemplate(template *models.WhatsAppMeowTemplate) *models.WhatsAppMeowTemplate {
+	copied := *template
+	copied.Variants = append(models.TemplateVariants(nil), template.Variants...)
+	copied.Variation.Emojis = append([]string(nil), template.Variation.Emojis...)
+	if template.Defaults != nil {
+		copied.Defaults = make(models.TemplateDefaults, len(template.Defaults))
+		for name, value := range template.Defaults {
+			copied.Defaults[name] = value
+		}
+	}
+	return &copied
+}
+
+func (m *Memory) SaveGroup(group *models.WhatsAppMeowGroup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := m.groups[group.WhatsAppMeowAccountID][group.JID]; ok {
+		group.ID = existing.ID
+		group.CreatedAt = existing.CreatedAt
+	} else {
+		group.ID = m.newID()
+		group.CreatedAt = now
+	}
+	group.UpdatedAt = now
+
+	if m.groups[group.WhatsAppMeowAccountID] == nil {
+		m.groups[group.WhatsAppMeowAccountID] = make(map[string]*models.WhatsAppMeowGroup)
+	}
+	m.groups[group.WhatsAppMeowAccountID][group.JID] = copyGroup(group)
+	return nil
+}
+
+func (m *Memory) GetGroup(accountID, jid string) (*models.WhatsAppMeowGroup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	group, ok := m.groups[accountID][jid]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyGroup(group), nil
+}
+
+func (m *Memory) ListGroups(accountID string) ([]*models.WhatsAppMeowGroup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var groups []*models.WhatsAppMeowGroup
+	for _, group := range m.groups[accountID] {
+		groups = append(groups, copyGroup(group))
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].JID < groups[j].JID
+	})
+	return groups, nil
+}
+
+func (m *Memory) DeleteGroup(accountID, jid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[accountID][jid]; !ok {
+		return ErrNotFound
+	}
+	delete(m.groups[accountID], jid)
+	return nil
+}
+
+// copyGroup keeps callers from sharing a stored group's participants
+func copyGroup(group *models.WhatsAppMeowGroup) *models.WhatsAppMeowGroup {
+	copied := *group
+	copied.Participants = append(models.GroupParticipants(nil), group.Participants...)
+	return &copied
+}
+
+func (m *Memory) inOrganization(message *models.WhatsAppMeowMessage, organizationID string) bool {
+	if organizationID == "" {
+		return true
+	}
+	account, ok := m.accounts[message.WhatsAppMeowAccountID]
+	return ok && account.OrganizationID == organizationID
+}
+
+// latestLeadID returns the lead most recently linked to a chat
+func (m *Memory) latestLeadID(accountID, chatJID string) *string {
+	var latest *models.WhatsAppMeowMessage
+	for _, message := range m.messages {
+		if message.LeadID == nil || message.WhatsAppMeowAccountID != accountID || conversationJID(message) != chatJID {
+			continue
+		}
+		if latest == nil || newerFirst(message, latest) {
+			latest = message
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	leadID := *latest.LeadID
+	return &leadID
+}
+
+// CountOutboundMessages counts messages an account sent since the given time
+func (m *Memory) CountOutboundMessages(accountID string, since time.Time) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, message := range m.messages {
+		if message.WhatsAppMeowAccountID == accountID && message.IsFromMe && !message.Timestamp.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UpdateMessageStatus moves a message forward to the given delivery status
+func (m *Memory) UpdateMessageStatus(accountID, messageID string, status models.WhatsAppMeowMessageStatus, at time.Time) error {
+	switch status {
+	case models.MessageStatusSent, models.MessageStatusDelivered, models.MessageStatusRead:
+	default:
+		return fmt.Errorf("unsupported message status: %s", status)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message, ok := m.messages[messageKey{accountID, messageID}]
+	if !ok {
+		return ErrNotFound
+	}
+	applyStatus(message, status, at)
+	return nil
+}
+
+// MarkMessageFailed records a send failure and bumps the retry counter
+func (m *Memory) MarkMessageFailed(accountID, messageID, errorCode, errorMessage string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message, ok := m.messages[messageKey{accountID, messageID}]
+	if !ok {
+		return ErrNotFound
+	}
+	message.ErrorCode = &errorCode
+	message.ErrorMessage = &errorMessage
+	message.RetryCount++
+	return nil
+}
+
+// EditMessage replaces the text of a message and keeps the previous text
+// in its edit history
+func (m *Memory) EditMessage(accountID, messageID, text string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message, ok := m.messages[messageKey{accountID, messageID}]
+	if !ok {
+		return ErrNotFound
+	}
+
+	previous := ""
+	if message.MessageText != nil {
+		previous = *message.MessageText
+	}
+	message.EditHistory = append(append([]models.MessageEdit(nil), message.EditHistory...), models.MessageEdit{
+		PreviousText: previous,
+		EditedAt:     at,
+	})
+	message.MessageText = &text
+	message.EditedAt = &at
+	return nil
+}
+
+// RevokeMessage flags a message as deleted for everyone
+func (m *Memory) RevokeMessage(accountID, messageID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message, ok := m.messages[messageKey{accountID, messageID}]
+	if !ok {
+		return ErrNotFound
+	}
+	if !message.IsRevoked {
+		message.IsRevoked = true
+		message.RevokedAt = &at
+	}
+	return nil
+}
+
+func (m *Memory) SetMessageMedia(accountID, messageID, mediaURL, mediaType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message, ok := m.messages[messageKey{accountID, messageID}]
+	if !ok {
+		return ErrNotFound
+	}
+	message.MediaURL = &mediaURL
+	message.MediaType = &mediaType
+	return nil
+}
+
+// SetReaction replaces the sender's reaction on a message; an empty
+// reaction removes it. Reactions older than the stored one are ignored.
+func (m *Memory) SetReaction(reaction *models.WhatsAppMeowReaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := messageKey{reaction.WhatsAppMeowAccountID, reaction.MessageID}
+	if _, ok := m.messages[key]; !ok {
+		return ErrNotFound
+	}
+
+	reactions := m.reactions[key]
+	if existing, ok := reactions[reaction.SenderJID]; ok && existing.Timestamp.After(reaction.Timestamp) {
+		return nil
+	}
+
+	if reaction.Reaction == "" {
+		delete(reactions, reaction.SenderJID)
+		return nil
+	}
+	if reactions == nil {
+		reactions = make(map[string]models.WhatsAppMeowReaction)
+		m.reactions[key] = reactions
+	}
+	reactions[reaction.SenderJID] = *reaction
+	return nil
+}
+
+// copyMessage returns a copy of a stored message with its reactions, oldest
+// first. Callers must hold the lock.
+func (m *Memory) copyMessage(message *models.WhatsAppMeowMessage) *models.WhatsAppMeowMessage {
+	found := *message
+	found.Reactions = nil
+	for _, reaction := range m.reactions[messageKey{message.WhatsAppMeowAccountID, message.MessageID}] {
+		found.Reactions = append(found.Reactions, reaction)
+	}
+	sort.Slice(found.Reactions, func(i, j int) bool {
+		return found.Reactions[i].Timestamp.Before(found.Reactions[j].Timestamp)
+	})
+	return &found
+}
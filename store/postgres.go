@@ -0,0 +1,1235 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsmeow-service/models"
+)
+
+// Postgres implements Store on top of the SkyFunnel PostgreSQL database
+type Postgres struct {
+	db         *sql.DB
+	leadLookup LeadLookup
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db, leadLookup: DefaultLeadLookup}
+}
+
+// SetLeadLookup changes where FindLeadByPhone looks; an empty table turns
+// the lookup off
+func (p *Postgres) SetLeadLookup(lookup LeadLookup) {
+	p.leadLookup = lookup
+}
+
+const accountColumns = `id, organization_id, device_id, session_data, qr_code, is_connected, is_paired,
+		       phone_number, display_name, profile_picture, last_seen, connection_status,
+		       read_receipts, created_at, updated_at`
+
+const messageColumns = `id, whats_app_meow_account_id, message_id, lead_id, from_jid, to_jid, message_type,
+		       message_text, media_url, media_type, media_reference, quoted_message_id, latitude, longitude, is_from_me, is_sent,
+		       is_delivered, is_read, timestamp, sent_at, delivered_at, read_at, error_code, error_message, retry_count,
+		       edited_at, edit_history, is_revoked, revoked_at, template_id`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// CreateAccount inserts a new account and fills in its generated fields
+func (p *Postgres) CreateAccount(account *models.WhatsAppMeowAccount) error {
+	if account.ConnectionStatus == "" {
+		account.ConnectionStatus = models.ConnectionStatusDisconnected
+	}
+	if account.ReadReceipts == "" {
+		account.ReadReceipts = models.ReadReceiptsNever
+	}
+
+	query := `
+		INSERT INTO "WhatsAppMeowAccount"
+		(organization_id, device_id, session_data, qr_code, is_connected, is_paired,
+		 phone_number, display_name, profile_picture, last_seen, connection_status, read_receipts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at, updated_at
+	`
+
+	return p.db.QueryRow(query,
+		account.OrganizationID,
+		account.DeviceID,
+		account.SessionData,
+		account.QRCode,
+		account.IsConnected,
+		account.IsPaired,
+		account.PhoneNumber,
+		account.DisplayName,
+		account.ProfilePicture,
+		account.LastSeen,
+		account.ConnectionStatus,
+		account.ReadReceipts,
+	).Scan(&account.ID, &account.CreatedAt, &account.UpdatedAt)
+}
+
+// GetAccount retrieves an account by its ID
+func (p *Postgres) GetAccount(id string) (*models.WhatsAppMeowAccount, error) {
+	query := `SELECT ` + accountColumns + ` FROM "WhatsAppMeowAccount" WHERE id = $1`
+	return scanAccount(p.db.QueryRow(query, id))
+}
+
+// GetAccountByOrganization retrieves the oldest account of an organization
+func (p *Postgres) GetAccountByOrganization(organizationID string) (*models.WhatsAppMeowAccount, error) {
+	query := `SELECT ` + accountColumns + ` FROM "WhatsAppMeowAccount"
+		WHERE organization_id = $1
+		ORDER BY created_at
+		LIMIT 1`
+	return scanAccount(p.db.QueryRow(query, organizationID))
+}
+
+// ListAccounts returns all accounts of an organization, oldest first
+func (p *Postgres) ListAccounts(organizationID string) ([]*models.WhatsAppMeowAccount, error) {
+	query := `SELECT ` + accountColumns + ` FROM "WhatsAppMeowAccount"
+		WHERE organization_id = $1
+		ORDER BY created_at`
+
+	rows, err := p.db.Query(query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*models.WhatsAppMeowAccount
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// UpdateAccount overwrites the mutable fields of an account
+func (p *Postgres) UpdateAccount(account *models.WhatsAppMeowAccount) error {
+	query := `
+		UPDATE "WhatsAppMeowAccount"
+		SET device_id = $2, session_data = $3, qr_code = $4, is_connected = $5, is_paired = $6,
+		    phone_number = $7, display_name = $8, profile_picture = $9, last_seen = $10,
+		    connection_status = $11, read_receipts = $12, updated_at = $13
+		WHERE id = $1
+	`
+
+	account.UpdatedAt = time.Now()
+	result, err := p.db.Exec(query,
+		account.ID,
+		account.DeviceID,
+		account.SessionData,
+		account.QRCode,
+		account.IsConnected,
+		account.IsPaired,
+		account.PhoneNumber,
+		account.DisplayName,
+		account.ProfilePicture,
+		account.LastSeen,
+		account.ConnectionStatus,
+		account.ReadReceipts,
+		account.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// UpdateConnectionStatus changes only the connection flags of an account
+func (p *Postgres) UpdateConnectionStatus(id string, status models.WhatsAppMeowConnectionStatus, isConnected bool) error {
+	result, err := p.db.Exec(`
+		UPDATE "WhatsAppMeowAccount"
+		SET connection_status = $1, is_connected = $2, updated_at = $3
+		WHERE id = $4
+	`, status, isConnected, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteAccount removes an account; its messages are removed by cascade
+func (p *Postgres) DeleteAccount(id string) error {
+	result, err := p.db.Exec(`DELETE FROM "WhatsAppMeowAccount" WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// InsertMessage stores a new message and fills in its generated ID
+func (p *Postgres) InsertMessage(message *models.WhatsAppMeowMessage) error {
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+
+	query := `
+		INSERT INTO "WhatsAppMeowMessage"
+		(whats_app_meow_account_id, message_id, lead_id, from_jid, to_jid, message_type, message_text,
+		 media_url, media_type, media_reference, quoted_message_id, latitude, longitude, is_from_me, is_sent,
+		 is_delivered, is_read, timestamp, sent_at, delivered_at, read_at, error_code, error_message,
+		 retry_count, template_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21,
+		        $22, $23, $24, $25)
+		RETURNING id
+	`
+
+	return p.db.QueryRow(query,
+		message.WhatsAppMeowAccountID,
+		message.MessageID,
+		message.LeadID,
+		message.FromJID,
+		message.ToJID,
+		message.MessageType,
+		message.MessageText,
+		message.MediaURL,
+		message.MediaType,
+		message.MediaReference,
+		message.QuotedMessageID,
+		message.Latitude,
+		message.Longitude,
+		message.IsFromMe,
+		message.IsSent,
+		message.IsDelivered,
+		message.IsRead,
+		message.Timestamp,
+		message.SentAt,
+		message.DeliveredAt,
+		message.ReadAt,
+		message.ErrorCode,
+		message.ErrorMessage,
+		message.RetryCount,
+		message.TemplateID,
+	).Scan(&message.ID)
+}
+
+// UpdateMessage overwrites the mutable fields of a message
+func (p *Postgres) UpdateMessage(message *models.WhatsAppMeowMessage) error {
+	query := `
+		UPDATE "WhatsAppMeowMessage"
+		SET lead_id = $2, message_text = $3, media_url = $4, media_type = $5, is_sent = $6,
+		    is_delivered = $7, is_read = $8, sent_at = $9, delivered_at = $10, read_at = $11,
+		    error_code = $12, error_message = $13, retry_count = $14
+		WHERE whats_app_meow_account_id = $15 AND message_id = $1
+	`
+
+	result, err := p.db.Exec(query,
+		message.MessageID,
+		message.LeadID,
+		message.MessageText,
+		message.MediaURL,
+		message.MediaType,
+		message.IsSent,
+		message.IsDelivered,
+		message.IsRead,
+		message.SentAt,
+		message.DeliveredAt,
+		message.ReadAt,
+		message.ErrorCode,
+		message.ErrorMessage,
+		message.RetryCount,
+		message.WhatsAppMeowAccountID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// GetMessage retrieves a message of an account by its WhatsApp message ID
+func (p *Postgres) GetMessage(accountID, messageID string) (*models.WhatsAppMeowMessage, error) {
+	query := `SELECT ` + messageColumns + ` FROM "WhatsAppMeowMessage" WHERE whats_app_meow_account_id = $1 AND message_id = $2`
+	message, err := scanMessage(p.db.QueryRow(query, accountID, messageID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.loadReactions([]*models.WhatsAppMeowMessage{message}); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ListMessages returns messages matching the filter, newest first
+func (p *Postgres) ListMessages(filter MessageFilter) ([]*models.WhatsAppMeowMessage, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if filter.OrganizationID != "" {
+		args = append(args, filter.OrganizationID)
+		conditions = append(conditions, fmt.Sprintf(
+			`whats_app_meow_account_id IN (SELECT id FROM "WhatsAppMeowAccount" WHERE organization_id = $%d)`, len(args)))
+	}
+	if filter.AccountID != "" {
+		addCondition("whats_app_meow_account_id", filter.AccountID)
+	}
+	if filter.MessageID != "" {
+		addCondition("message_id", filter.MessageID)
+	}
+	if filter.TemplateID != "" {
+		addCondition("template_id", filter.TemplateID)
+	}
+	if filter.LeadID != "" {
+		addCondition("lead_id", filter.LeadID)
+	}
+	if filter.FromJID != "" {
+		addCondition("from_jid", filter.FromJID)
+	}
+	if filter.ToJID != "" {
+		addCondition("to_jid", filter.ToJID)
+	}
+	if filter.ChatJID != "" {
+		args = append(args, filter.ChatJID)
+		conditions = append(conditions, fmt.Sprintf("(from_jid = $%d OR to_jid = $%d)", len(args), len(args)))
+	}
+	if filter.HasLead {
+		conditions = append(conditions, "lead_id IS NOT NULL")
+	}
+	if filter.FromMe != nil {
+		addCondition("is_from_me", *filter.FromMe)
+	}
+	if filter.MessageType != "" {
+		addCondition("message_type", filter.MessageType)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, statusCondition(filter.Status))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		conditions = append(conditions, fmt.Sprintf("timestamp >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until)
+		conditions = append(conditions, fmt.Sprintf("timestamp < $%d", len(args)))
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.Timestamp, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(timestamp, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM "WhatsAppMeowMessage"`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY timestamp DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.WhatsAppMeowMessage
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := p.loadReactions(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListConversations returns the chats of matching messages, most recently
+// active first. A chat is the group for group messages and the other party
+// otherwise.
+func (p *Postgres) ListConversations(filter ConversationFilter) ([]*models.WhatsAppMeowConversation, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.OrganizationID != "" {
+		args = append(args, filter.OrganizationID)
+		conditions = append(conditions, fmt.Sprintf(
+			`whats_app_meow_account_id IN (SELECT id FROM "WhatsAppMeowAccount" WHERE organization_id = $%d)`, len(args)))
+	}
+	if filter.AccountID != "" {
+		args = append(args, filter.AccountID)
+		conditions = append(conditions, fmt.Sprintf("whats_app_meow_account_id = $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		WITH chats AS (
+			SELECT *, CASE WHEN is_from_me OR to_jid LIKE '%@` + types.GroupServer + `' THEN to_jid ELSE from_jid END AS chat_jid
+			FROM "WhatsAppMeowMessage"` + where + `
+		), ranked AS (
+			SELECT *,
+				ROW_NUMBER() OVER (PARTITION BY whats_app_meow_account_id, chat_jid ORDER BY timestamp DESC, id DESC) AS position,
+				COUNT(*) FILTER (WHERE NOT is_from_me AND NOT is_read) OVER (PARTITION BY whats_app_meow_account_id, chat_jid) AS unread,
+				FIRST_VALUE(lead_id) OVER (PARTITION BY whats_app_meow_account_id, chat_jid ORDER BY lead_id IS NULL, timestamp DESC, id DESC) AS chat_lead_id
+			FROM chats
+		)
+		SELECT ` + messageColumns + `, chat_jid, unread, chat_lead_id
+		FROM ranked
+		WHERE position = 1`
+	if filter.After != nil {
+		args = append(args, filter.After.Timestamp, filter.After.ID)
+		query += fmt.Sprintf(" AND (timestamp, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	query += " ORDER BY timestamp DESC, id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []*models.WhatsAppMeowConversation
+	var messages []*models.WhatsAppMeowMessage
+	for rows.Next() {
+		var conversation models.WhatsAppMeowConversation
+		var leadID sql.NullString
+		message, err := scanMessage(rows, &conversation.ChatJID, &conversation.UnreadCount, &leadID)
+		if err != nil {
+			return nil, err
+		}
+		if leadID.Valid {
+			conversation.LeadID = &leadID.String
+		}
+		conversation.WhatsAppMeowAccountID = message.WhatsAppMeowAccountID
+		conversation.LastMessage = message
+		conversation.LastActivity = message.Timestamp
+		conversations = append(conversations, &conversation)
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := p.loadReactions(messages); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+func (p *Postgres) FindLeadByPhone(organizationID, phoneDigits string) (string, error) {
+	lookup := p.leadLookup
+	if lookup.Table == "" || phoneDigits == "" {
+		return "", ErrNotFound
+	}
+
+	// Compare digits only, the same way NormalizePhone does
+	id := pq.QuoteIdentifier(lookup.IDColumn)
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s
+		WHERE %s = $1
+		  AND regexp_replace(regexp_replace(%s, '[^0-9]', '', 'g'), '^00', '') = $2
+		ORDER BY %s
+		LIMIT 1
+	`, id, pq.QuoteIdentifier(lookup.Table), pq.QuoteIdentifier(lookup.OrganizationColumn),
+		pq.QuoteIdentifier(lookup.PhoneColumn), id)
+
+	var leadID string
+	err := p.db.QueryRow(query, organizationID, phoneDigits).Scan(&leadID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return leadID, err
+}
+
+func (p *Postgres) AddSuppression(suppression *models.WhatsAppMeowSuppression) error {
+	return p.db.QueryRow(`
+		INSERT INTO "WhatsAppMeowSuppression" (organization_id, jid, keyword, message_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, jid) DO UPDATE
+		SET keyword = EXCLUDED.keyword, message_id = EXCLUDED.message_id
+		RETURNING created_at
+	`, suppression.OrganizationID, suppression.JID, suppression.Keyword, suppression.MessageID).Scan(&suppression.CreatedAt)
+}
+
+func (p *Postgres) RemoveSuppression(organizationID, jid string) error {
+	result, err := p.db.Exec(`
+		DELETE FROM "WhatsAppMeowSuppression" WHERE organization_id = $1 AND jid = $2
+	`, organizationID, jid)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (p *Postgres) IsSuppressed(organizationID string, jids ...string) (bool, error) {
+	var suppressed bool
+	err := p.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM "WhatsAppMeowSuppression" WHERE organization_id = $1 AND jid = ANY($2)
+		)
+	`, organizationID, pq.Array(jids)).Scan(&suppressed)
+	return suppressed, err
+}
+
+const templateColumns = `id, organization_id, name, default_language, variants, defaults, variation, created_at, updated_at`
+
+func (p *Postgres) CreateTemplate(template *models.WhatsAppMeowTemplate) error {
+	return p.db.QueryRow(`
+		INSERT INTO "WhatsAppMeowTemplate" (organization_id, name, default_language, variants, defaults, variation)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`,
+		template.OrganizationID,
+		template.Name,
+		template.DefaultLanguage,
+		template.Variants,
+		template.Defaults,
+		template.Variation,
+	).Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+}
+
+func (p *Postgres) GetTemplate(id string) (*models.WhatsAppMeowTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM "WhatsAppMeowTemplate" WHERE id = $1`
+	return scanTemplate(p.db.QueryRow(query, id))
+}
+
+func (p *Postgres) ListTemplates(organizationID string) ([]*models.WhatsAppMeowTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM "WhatsAppMeowTemplate"
+		WHERE organization_id = $1
+		ORDER BY name`
+
+	rows, err := p.db.Query(query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []*models.WhatsAppMeowTemplate
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func (p *Postgres) UpdateTemplate(template *models.WhatsAppMeowTemplate) error {
+	err := p.db.QueryRow(`
+		UPDATE "WhatsAppMeowTemplate"
+		SET name = $2, default_language = $3, variants = $4, defaults = $5, variation = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING organization_id, created_at, updated_at
+	`,
+		template.ID,
+		template.Name,
+		template.DefaultLanguage,
+		template.Variants,
+		template.Defaults,
+		template.Variation,
+	).Scan(&template.OrganizationID, &template.CreatedAt, &template.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) DeleteTemplate(id string) error {
+	result, err := p.db.Exec(`DELETE FROM "WhatsAppMeowTemplate" WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func scanTemplate(row scanner) (*models.WhatsAppMeowTemplate, error) {
+	var template models.WhatsAppMeowTemplate
+	var variants, defaults, variation []byte
+
+	err := row.Scan(
+		&template.ID,
+		&template.OrganizationID,
+		&template.Name,
+		&template.DefaultLanguage,
+		&variants,
+		&defaults,
+		&variation,
+		&template.CreatedAt,
+		&template.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(variants, &template.Variants); err != nil {
+		return nil, fmt.Errorf("failed to parse template variants: %w", err)
+	}
+	if err := json.Unmarshal(defaults, &template.Defaults); err != nil {
+		return nil, fmt.Errorf("failed to parse template defaults: %w", err)
+	}
+	if err := json.Unmarshal(variation, &template.Variation); err != nil {
+		return nil, fmt.Errorf("failed to parse template variation: %w", err)
+	}
+
+	return &template, nil
+}
+
+const groupColumns = `id, whats_app_meow_account_id, jid, name, description, owner_jid, is_announce, is_locked,
+		participants, invite_link, photo_id, group_created_at, created_at, updated_at`
+
+func (p *Postgres) SaveGroup(group *models.WhatsAppMeowGroup) error {
+	return p.db.QueryRow(`
+		INSERT INTO "WhatsAppMeowGroup" (
+			whats_app_meow_account_id, jid, name, description, owner_jid, is_announce, is_locked,
+			participants, invite_link, photo_id, group_created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (whats_app_meow_account_id, jid) DO UPDATE
+		SET name = EXCLUDED.name, description = EXCLUDED.description, owner_jid = EXCLUDED.owner_jid,
+			is_announce = EXCLUDED.is_announce, is_locked = EXCLUDED.is_locked,
+			participants = EXCLUDED.participants, invite_link = EXCLUDED.invite_link,
+			photo_id = EXCLUDED.photo_id, group_created_at = EXCLUDED.group_created_at,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, updated_at
+	`,
+		group.WhatsAppMeowAccountID,
+		group.JID,
+		group.Name,
+		group.Description,
+		group.OwnerJID,
+		group.IsAnnounce,
+		group.IsLocked,
+		group.Participants,
+		group.InviteLink,
+		group.PhotoID,
+		group.GroupCreatedAt,
+	).Scan(&group.ID, &group.CreatedAt, &group.UpdatedAt)
+}
+
+func (p *Postgres) GetGroup(accountID, jid string) (*models.WhatsAppMeowGroup, error) {
+	query := `SELECT ` + groupColumns + ` FROM "WhatsAppMeowGroup" WHERE whats_app_meow_account_id = $1 AND jid = $2`
+	return scanGroup(p.db.QueryRow(query, accountID, jid))
+}
+
+func (p *Postgres) ListGroups(accountID string) ([]*models.WhatsAppMeowGroup, error) {
+	query := `SELECT ` + groupColumns + ` FROM "WhatsAppMeowGroup"
+		WHERE whats_app_meow_account_id = $1
+		ORDER BY name, jid`
+
+	rows, err := p.db.Query(query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []*models.WhatsAppMeowGroup
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func (p *Postgres) DeleteGroup(accountID, jid string) error {
+	result, err := p.db.Exec(`
+		DELETE FROM "WhatsAppMeowGroup" WHERE whats_app_meow_account_id = $1 AND jid = $2
+	`, accountID, jid)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func scanGroup(row scanner) (*models.WhatsAppMeowGroup, error) {
+	var group models.WhatsAppMeowGroup
+	var participants []byte
+	var inviteLink, photoID sql.NullString
+	var groupCreatedAt sql.NullTime
+
+	err := row.Scan(
+		&group.ID,
+		&group.WhatsAppMeowAccountID,
+		&group.JID,
+		&group.Name,
+		&group.Description,
+		&group.OwnerJID,
+		&group.IsAnnounce,
+		&group.IsLocked,
+		&participants,
+		&inviteLink,
+		&photoID,
+		&groupCreatedAt,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(participants, &group.Participants); err != nil {
+		return nil, fmt.Errorf("failed to parse group participants: %w", err)
+	}
+	if inviteLink.Valid {
+		group.InviteLink = &inviteLink.String
+	}
+	if photoID.Valid {
+		group.PhotoID = &photoID.String
+	}
+	if groupCreatedAt.Valid {
+		group.GroupCreatedAt = &groupCreatedAt.Time
+	}
+
+	return &group, nil
+}
+
+const autoReplyRuleColumns = `id, whats_app_meow_account_id, name, priority, is_enabled, match_type, pattern,
+		schedule, business_hours, cooldown_seconds, response, created_at, updated_at`
+
+func (p *Postgres) CreateAutoReplyRule(rule *models.WhatsAppMeowAutoReplyRule) error {
+	return p.db.QueryRow(`
+		INSERT INTO "WhatsAppMeowAutoReplyRule"
+		(whats_app_meow_account_id, name, priority, is_enabled, match_type, pattern, schedule,
+		 business_hours, cooldown_seconds, response)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, updated_at
+	`,
+		rule.WhatsAppMeowAccountID,
+		rule.Name,
+		rule.Priority,
+		rule.IsEnabled,
+		rule.MatchType,
+		rule.Pattern,
+		rule.Schedule,
+		rule.BusinessHours,
+		rule.CooldownSeconds,
+		rule.Response,
+	).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+}
+
+func (p *Postgres) GetAutoReplyRule(id string) (*models.WhatsAppMeowAutoReplyRule, error) {
+	query := `SELECT ` + autoReplyRuleColumns + ` FROM "WhatsAppMeowAutoReplyRule" WHERE id = $1`
+	return scanAutoReplyRule(p.db.QueryRow(query, id))
+}
+
+func (p *Postgres) ListAutoReplyRules(accountID string) ([]*models.WhatsAppMeowAutoReplyRule, error) {
+	query := `SELECT ` + autoReplyRuleColumns + ` FROM "WhatsAppMeowAutoReplyRule"
+		WHERE whats_app_meow_account_id = $1
+		ORDER BY priority, created_at, id`
+
+	rows, err := p.db.Query(query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*models.WhatsAppMeowAutoReplyRule
+	for rows.Next() {
+		rule, err := scanAutoReplyRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (p *Postgres) UpdateAutoReplyRule(rule *models.WhatsAppMeowAutoReplyRule) error {
+	err := p.db.QueryRow(`
+		UPDATE "WhatsAppMeowAutoReplyRule"
+		SET name = $2, priority = $3, is_enabled = $4, match_type = $5, pattern = $6, schedule = $7,
+		    business_hours = $8, cooldown_seconds = $9, response = $10, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING whats_app_meow_account_id, created_at, updated_at
+	`,
+		rule.ID,
+		rule.Name,
+		rule.Priority,
+		rule.IsEnabled,
+		rule.MatchType,
+		rule.Pattern,
+		rule.Schedule,
+		rule.BusinessHours,
+		rule.CooldownSeconds,
+		rule.Response,
+	).Scan(&rule.WhatsAppMeowAccountID, &rule.CreatedAt, &rule.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) DeleteAutoReplyRule(id string) error {
+	result, err := p.db.Exec(`DELETE FROM "WhatsAppMeowAutoReplyRule" WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (p *Postgres) LastAutoReply(ruleID, contactJID string) (time.Time, error) {
+	var at time.Time
+	err := p.db.QueryRow(`
+		SELECT replied_at FROM "WhatsAppMeowAutoReply" WHERE rule_id = $1 AND contact_jid = $2
+	`, ruleID, contactJID).Scan(&at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	return at, err
+}
+
+func (p *Postgres) ClaimAutoReply(ruleID, contactJID string, at, notBefore time.Time) (bool, error) {
+	// The conditional upsert lets only one of several concurrent messages
+	// from a contact trigger the rule
+	var claimed time.Time
+	err := p.db.QueryRow(`
+		INSERT INTO "WhatsAppMeowAutoReply" (rule_id, contact_jid, replied_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (rule_id, contact_jid) DO UPDATE
+		SET replied_at = EXCLUDED.replied_at
+		WHERE "WhatsAppMeowAutoReply".replied_at <= $4
+		RETURNING replied_at
+	`, ruleID, contactJID, at, notBefore).Scan(&claimed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func scanAutoReplyRule(row scanner) (*models.WhatsAppMeowAutoReplyRule, error) {
+	var rule models.WhatsAppMeowAutoReplyRule
+	var businessHours, response []byte
+
+	err := row.Scan(
+		&rule.ID,
+		&rule.WhatsAppMeowAccountID,
+		&rule.Name,
+		&rule.Priority,
+		&rule.IsEnabled,
+		&rule.MatchType,
+		&rule.Pattern,
+		&rule.Schedule,
+		&businessHours,
+		&rule.CooldownSeconds,
+		&response,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if businessHours != nil {
+		rule.BusinessHours = &models.BusinessHours{}
+		if err := json.Unmarshal(businessHours, rule.BusinessHours); err != nil {
+			return nil, fmt.Errorf("failed to parse business hours: %w", err)
+		}
+	}
+	if err := json.Unmarshal(response, &rule.Response); err != nil {
+		return nil, fmt.Errorf("failed to parse auto-reply response: %w", err)
+	}
+
+	return &rule, nil
+}
+
+// statusCondition matches messages whose latest delivery status is status
+func statusCondition(status models.WhatsAppMeowMessageStatus) string {
+	switch status {
+	case models.MessageStatusFailed:
+		return "error_code IS NOT NULL"
+	case models.MessageStatusRead:
+		return "error_code IS NULL AND is_read"
+	case models.MessageStatusDelivered:
+		return "error_code IS NULL AND is_delivered AND NOT is_read"
+	case models.MessageStatusSent:
+		return "error_code IS NULL AND is_sent AND NOT is_delivered AND NOT is_read"
+	default:
+		return "false"
+	}
+}
+
+// CountOutboundMessages counts messages an account sent since the given time
+func (p *Postgres) CountOutboundMessages(accountID string, since time.Time) (int, error) {
+	var count int
+	err := p.db.QueryRow(`
+		SELECT COUNT(*) FROM "WhatsAppMeowMessage"
+		WHERE whats_app_meow_account_id = $1 AND is_from_me = true AND timestamp >= $2
+	`, accountID, since).Scan(&count)
+	return count, err
+}
+
+// UpdateMessageStatus moves a message forward to the given delivery status
+func (p *Postgres) UpdateMessageStatus(accountID, messageID string, status models.WhatsAppMeowMessageStatus, at time.Time) error {
+	var set string
+	switch status {
+	case models.MessageStatusSent:
+		set = `is_sent = true, sent_at = COALESCE(sent_at, $3)`
+	case models.MessageStatusDelivered:
+		set = `is_sent = true, sent_at = COALESCE(sent_at, $3),
+		       is_delivered = true, delivered_at = COALESCE(delivered_at, $3)`
+	case models.MessageStatusRead:
+		set = `is_sent = true, sent_at = COALESCE(sent_at, $3),
+		       is_delivered = true, delivered_at = COALESCE(delivered_at, $3),
+		       is_read = true, read_at = COALESCE(read_at, $3)`
+	default:
+		return fmt.Errorf("unsupported message status: %s", status)
+	}
+
+	result, err := p.db.Exec(`UPDATE "WhatsAppMeowMessage" SET `+set+` WHERE whats_app_meow_account_id = $1 AND message_id = $2`,
+		accountID, messageID, at)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// MarkMessageFailed records a send failure and bumps the retry counter
+func (p *Postgres) MarkMessageFailed(accountID, messageID, errorCode, errorMessage string) error {
+	result, err := p.db.Exec(`
+		UPDATE "WhatsAppMeowMessage"
+		SET error_code = $3, error_message = $4, retry_count = retry_count + 1
+		WHERE whats_app_meow_account_id = $1 AND message_id = $2
+	`, accountID, messageID, errorCode, errorMessage)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func scanAccount(row scanner) (*models.WhatsAppMeowAccount, error) {
+	var account models.WhatsAppMeowAccount
+	var sessionDataJSON []byte
+	var qrCode sql.NullString
+	var phoneNumber sql.NullString
+	var displayName sql.NullString
+	var profilePicture sql.NullString
+	var lastSeen sql.NullTime
+
+	err := row.Scan(
+		&account.ID,
+		&account.OrganizationID,
+		&account.DeviceID,
+		&sessionDataJSON,
+		&qrCode,
+		&account.IsConnected,
+		&account.IsPaired,
+		&phoneNumber,
+		&displayName,
+		&profilePicture,
+		&lastSeen,
+		&account.ConnectionStatus,
+		&account.ReadReceipts,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Handle nullable fields
+	if sessionDataJSON != nil {
+		account.SessionData = &models.SessionData{}
+		if err := json.Unmarshal(sessionDataJSON, account.SessionData); err != nil {
+			return nil, fmt.Errorf("failed to parse session data: %w", err)
+		}
+	}
+	if qrCode.Valid {
+		account.QRCode = &qrCode.String
+	}
+	if phoneNumber.Valid {
+		account.PhoneNumber = &phoneNumber.String
+	}
+	if displayName.Valid {
+		account.DisplayName = &displayName.String
+	}
+	if profilePicture.Valid {
+		account.ProfilePicture = &profilePicture.String
+	}
+	if lastSeen.Valid {
+		account.LastSeen = &lastSeen.Time
+	}
+
+	return &account, nil
+}
+
+// SetReaction replaces the sender's reaction on a message; an empty
+// reaction removes it. Reactions older than the stored one are ignored.
+func (p *Postgres) SetReaction(reaction *models.WhatsAppMeowReaction) error {
+	var exists bool
+	err := p.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM "WhatsAppMeowMessage" WHERE whats_app_meow_account_id = $1 AND message_id = $2)`,
+		reaction.WhatsAppMeowAccountID, reaction.MessageID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	if reaction.Reaction == "" {
+		_, err = p.db.Exec(`
+			DELETE FROM "WhatsAppMeowReaction"
+			WHERE whats_app_meow_account_id = $1 AND message_id = $2 AND sender_jid = $3 AND timestamp <= $4
+		`, reaction.WhatsAppMeowAccountID, reaction.MessageID, reaction.SenderJID, reaction.Timestamp)
+		return err
+	}
+
+	_, err = p.db.Exec(`
+		INSERT INTO "WhatsAppMeowReaction" (whats_app_meow_account_id, message_id, sender_jid, reaction, is_from_me, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (whats_app_meow_account_id, message_id, sender_jid) DO UPDATE
+		SET reaction = EXCLUDED.reaction, timestamp = EXCLUDED.timestamp
+		WHERE "WhatsAppMeowReaction".timestamp <= EXCLUDED.timestamp
+	`, reaction.WhatsAppMeowAccountID, reaction.MessageID, reaction.SenderJID, reaction.Reaction, reaction.IsFromMe, reaction.Timestamp)
+	return err
+}
+
+// EditMessage replaces the text of a message and keeps the previous text
+// in its edit history
+func (p *Postgres) EditMessage(accountID, messageID, text string, at time.Time) error {
+	result, err := p.db.Exec(`
+		UPDATE "WhatsAppMeowMessage"
+		SET edit_history = COALESCE(edit_history, '[]'::jsonb) ||
+		        jsonb_build_array(jsonb_build_object('previousText', COALESCE(message_text, ''), 'editedAt', $5::text)),
+		    message_text = $3, edited_at = $4
+		WHERE whats_app_meow_account_id = $1 AND message_id = $2
+	`, accountID, messageID, text, at, at.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// RevokeMessage flags a message as deleted for everyone
+func (p *Postgres) RevokeMessage(accountID, messageID string, at time.Time) error {
+	result, err := p.db.Exec(`
+		UPDATE "WhatsAppMeowMessage"
+		SET is_revoked = true, revoked_at = COALESCE(revoked_at, $3)
+		WHERE whats_app_meow_account_id = $1 AND message_id = $2
+	`, accountID, messageID, at)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (p *Postgres) SetMessageMedia(accountID, messageID, mediaURL, mediaType string) error {
+	result, err := p.db.Exec(`
+		UPDATE "WhatsAppMeowMessage"
+		SET media_url = $3, media_type = $4
+		WHERE whats_app_meow_account_id = $1 AND message_id = $2
+	`, accountID, messageID, mediaURL, mediaType)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// loadReactions fills in the reactions of the given messages
+func (p *Postgres) loadReactions(messages []*models.WhatsAppMeowMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byKey := make(map[messageKey]*models.WhatsAppMeowMessage, len(messages))
+	accountIDs := make([]string, 0, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		byKey[messageKey{message.WhatsAppMeowAccountID, message.MessageID}] = message
+		accountIDs = append(accountIDs, message.WhatsAppMeowAccountID)
+		ids = append(ids, message.MessageID)
+	}
+
+	rows, err := p.db.Query(`
+		SELECT whats_app_meow_account_id, message_id, sender_jid, reaction, is_from_me, timestamp
+		FROM "WhatsAppMeowReaction"
+		WHERE (whats_app_meow_account_id, message_id) IN (SELECT * FROM unnest($1::text[], $2::text[]))
+		ORDER BY timestamp
+	`, pq.Array(accountIDs), pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reaction models.WhatsAppMeowReaction
+		err := rows.Scan(&reaction.WhatsAppMeowAccountID, &reaction.MessageID, &reaction.SenderJID, &reaction.Reaction,
+			&reaction.IsFromMe, &reaction.Timestamp)
+		if err != nil {
+			return err
+		}
+		message := byKey[messageKey{reaction.WhatsAppMeowAccountID, reaction.MessageID}]
+		message.Reactions = append(message.Reactions, reaction)
+	}
+
+	return rows.Err()
+}
+
+// scanMessage reads a row of messageColumns followed by the extra columns
+func scanMessage(row scanner, extra ...interface{}) (*models.WhatsAppMeowMessage, error) {
+	var message models.WhatsAppMeowMessage
+	var leadID sql.NullString
+	var messageText sql.NullString
+	var mediaURL sql.NullString
+	var mediaType sql.NullString
+	var mediaReference []byte
+	var quotedMessageID sql.NullString
+	var latitude sql.NullFloat64
+	var longitude sql.NullFloat64
+	var sentAt sql.NullTime
+	var deliveredAt sql.NullTime
+	var readAt sql.NullTime
+	var errorCode sql.NullString
+	var errorMessage sql.NullString
+	var editedAt sql.NullTime
+	var editHistory []byte
+	var revokedAt sql.NullTime
+	var templateID sql.NullString
+
+	dest := []interface{}{
+		&message.ID,
+		&message.WhatsAppMeowAccountID,
+		&message.MessageID,
+		&leadID,
+		&message.FromJID,
+		&message.ToJID,
+		&message.MessageType,
+		&messageText,
+		&mediaURL,
+		&mediaType,
+		&mediaReference,
+		&quotedMessageID,
+		&latitude,
+		&longitude,
+		&message.IsFromMe,
+		&message.IsSent,
+		&message.IsDelivered,
+		&message.IsRead,
+		&message.Timestamp,
+		&sentAt,
+		&deliveredAt,
+		&readAt,
+		&errorCode,
+		&errorMessage,
+		&message.RetryCount,
+		&editedAt,
+		&editHistory,
+		&message.IsRevoked,
+		&revokedAt,
+		&templateID,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Handle nullable fields
+	if leadID.Valid {
+		message.LeadID = &leadID.String
+	}
+	if messageText.Valid {
+		message.MessageText = &messageText.String
+	}
+	if mediaURL.Valid {
+		message.MediaURL = &mediaURL.String
+	}
+	if mediaType.Valid {
+		message.MediaType = &mediaType.String
+	}
+	if len(mediaReference) > 0 {
+		message.MediaReference = &models.MediaReference{}
+		if err := json.Unmarshal(mediaReference, message.MediaReference); err != nil {
+			return nil, fmt.Errorf("failed to parse media reference: %w", err)
+		}
+	}
+	if quotedMessageID.Valid {
+		message.QuotedMessageID = &quotedMessageID.String
+	}
+	if latitude.Valid {
+		message.Latitude = &latitude.Float64
+	}
+	if longitude.Valid {
+		message.Longitude = &longitude.Float64
+	}
+	if sentAt.Valid {
+		message.SentAt = &sentAt.Time
+	}
+	if deliveredAt.Valid {
+		message.DeliveredAt = &deliveredAt.Time
+	}
+	if readAt.Valid {
+		message.ReadAt = &readAt.Time
+	}
+	if errorCode.Valid {
+		message.ErrorCode = &errorCode.String
+	}
+	if errorMessage.Valid {
+		message.ErrorMessage = &errorMessage.String
+	}
+	if editedAt.Valid {
+		message.EditedAt = &editedAt.Time
+	}
+	if len(editHistory) > 0 {
+		if err := json.Unmarshal(editHistory, &message.EditHistory); err != nil {
+			return nil, fmt.Errorf("failed to parse edit history: %w", err)
+		}
+	}
+	if revokedAt.Valid {
+		message.RevokedAt = &revokedAt.Time
+	}
+	if templateID.Valid {
+		message.TemplateID = &templateID.String
+	}
+
+	return &message, nil
+}
+
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsmeow-service/models"
+)
+
+// ErrNotFound is returned when the requested row does not exist
+var ErrNotFound = errors.New("not found")
+
+// Store bundles every repository the service depends on
+type Store interface {
+	AccountStore
+	MessageStore
+	LeadStore
+	SuppressionStore
+	AutoReplyStore
+	TemplateStore
+	GroupStore
+}
+
+// AccountStore persists WhatsAppMeowAccount rows
+type AccountStore interface {
+	CreateAccount(account *models.WhatsAppMeowAccount) error
+	GetAccount(id string) (*models.WhatsAppMeowAccount, error)
+	GetAccountByOrganization(organizationID string) (*models.WhatsAppMeowAccount, error)
+	ListAccounts(organizationID string) ([]*models.WhatsAppMeowAccount, error)
+	UpdateAccount(account *models.WhatsAppMeowAccount) error
+	UpdateConnectionStatus(id string, status models.WhatsAppMeowConnectionStatus, isConnected bool) error
+	DeleteAccount(id string) error
+}
+
+// MessageStore persists WhatsAppMeowMessage rows. WhatsApp message IDs are
+// only unique within an account, so messages are looked up by both.
+type MessageStore interface {
+	InsertMessage(message *models.WhatsAppMeowMessage) error
+	UpdateMessage(message *models.WhatsAppMeowMessage) error
+	GetMessage(accountID, messageID string) (*models.WhatsAppMeowMessage, error)
+	// ListMessages returns matching messages, newest first
+	ListMessages(filter MessageFilter) ([]*models.WhatsAppMeowMessage, error)
+	// ListConversations returns the chats of matching messages, most
+	// recently active first
+	ListConversations(filter ConversationFilter) ([]*models.WhatsAppMeowConversation, error)
+	CountOutboundMessages(accountID string, since time.Time) (int, error)
+	UpdateMessageStatus(accountID, messageID string, status models.WhatsAppMeowMessageStatus, at time.Time) error
+	MarkMessageFailed(accountID, messageID, errorCode, errorMessage string) error
+	// SetReaction replaces the sender's reaction on a message; an empty
+	// reaction removes it
+	SetReaction(reaction *models.WhatsAppMeowReaction) error
+	// EditMessage replaces the text of a message and keeps the previous
+	// text in its edit history
+	EditMessage(accountID, messageID, text string, at time.Time) error
+	RevokeMessage(accountID, messageID string, at time.Time) error
+	// SetMessageMedia records where the downloaded media of a message is
+	// stored and its MIME type
+	SetMessageMedia(accountID, messageID, mediaURL, mediaType string) error
+}
+
+// MessageFilter narrows ListMessages results; zero-valued fields are ignored
+type MessageFilter struct {
+	OrganizationID string
+	AccountID      string
+	MessageID      string
+	LeadID         string
+	FromJID        string
+	ToJID          string
+	// ChatJID matches messages sent either to or from the JID
+	ChatJID string
+	// HasLead keeps only messages linked to a lead
+	HasLead    bool
+	TemplateID string
+	// FromMe keeps only outbound (true) or inbound (false) messages
+	FromMe      *bool
+	MessageType models.WhatsAppMeowMessageType
+	// Status matches the current delivery status of a message
+	Status models.WhatsAppMeowMessageStatus
+	// Since and Until bound the message timestamp, Until exclusively
+	Since time.Time
+	Until time.Time
+	// After continues a listing behind the given message
+	After *MessageCursor
+	Limit int
+}
+
+// ConversationFilter narrows ListConversations results; zero-valued fields
+// are ignored
+type ConversationFilter struct {
+	OrganizationID string
+	AccountID      string
+	// After continues a listing behind the conversation whose last message
+	// is at the cursor
+	After *MessageCursor
+	Limit int
+}
+
+// MessageCursor is a position in a newest-first listing of messages
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// before reports whether message comes after the cursor in a newest-first
+// listing
+func (c *MessageCursor) before(message *models.WhatsAppMeowMessage) bool {
+	if !message.Timestamp.Equal(c.Timestamp) {
+		return message.Timestamp.Before(c.Timestamp)
+	}
+	return message.ID < c.ID
+}
+
+// newerFirst orders messages by timestamp, then row ID, newest first
+func newerFirst(a, b *models.WhatsAppMeowMessage) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	return a.ID > b.ID
+}
+
+// messageStatus returns the delivery status a message has reached
+func messageStatus(message *models.WhatsAppMeowMessage) models.WhatsAppMeowMessageStatus {
+	switch {
+	case message.ErrorCode != nil:
+		return models.MessageStatusFailed
+	case message.IsRead:
+		return models.MessageStatusRead
+	case message.IsDelivered:
+		return models.MessageStatusDelivered
+	case message.IsSent:
+		return models.MessageStatusSent
+	default:
+		return ""
+	}
+}
+
+// conversationJID returns the chat a message belongs to: the group, or the
+// other party of a direct chat
+func conversationJID(message *models.WhatsAppMeowMessage) string {
+	if message.IsFromMe || strings.HasSuffix(message.ToJID, "@"+types.GroupServer) {
+		return message.ToJID
+	}
+	return message.FromJID
+}
+
+// applyStatus moves the delivery flags of a message forward to the given
+// status. Statuses never move backwards, so a late "delivered" receipt
+// arriving after "read" leaves the message read.
+func applyStatus(message *models.WhatsAppMeowMessage, status models.WhatsAppMeowMessageStatus, at time.Time) {
+	switch status {
+	case models.MessageStatusRead:
+		if !message.IsRead {
+			message.IsRead = true
+			message.ReadAt = &at
+		}
+		fallthrough
+	case models.MessageStatusDelivered:
+		if !message.IsDelivered {
+			message.IsDelivered = true
+			message.DeliveredAt = &at
+		}
+		fallthrough
+	case models.MessageStatusSent:
+		if !message.IsSent {
+			message.IsSent = true
+			message.SentAt = &at
+		}
+	}
+}
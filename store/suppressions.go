@@ -0,0 +1,14 @@
+package store
+
+import "whatsmeow-service/models"
+
+// SuppressionStore persists the contacts each organization must not message
+type SuppressionStore interface {
+	// AddSuppression adds a JID to its organization's suppression list, or
+	// refreshes the entry when it is already there
+	AddSuppression(suppression *models.WhatsAppMeowSuppression) error
+	// RemoveSuppression takes a JID off the list, or returns ErrNotFound
+	RemoveSuppression(organizationID, jid string) error
+	// IsSuppressed reports whether any of the JIDs is on the list
+	IsSuppressed(organizationID string, jids ...string) (bool, error)
+}
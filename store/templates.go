@@ -0,0 +1,13 @@
+package store
+
+import "whatsmeow-service/models"
+
+// TemplateStore persists the message templates of organizations
+type TemplateStore interface {
+	CreateTemplate(template *models.WhatsAppMeowTemplate) error
+	GetTemplate(id string) (*models.WhatsAppMeowTemplate, error)
+	// ListTemplates returns an organization's templates by name
+	ListTemplates(organizationID string) ([]*models.WhatsAppMeowTemplate, error)
+	UpdateTemplate(template *models.WhatsAppMeowTemplate) error
+	DeleteTemplate(id string) error
+}